@@ -6,6 +6,7 @@ import (
 	"os"
 
 	vcs "github.com/harveysanders/protohackers/10-voracious-code-storage"
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/disk"
 )
 
 func main() {
@@ -15,7 +16,15 @@ func main() {
 	}
 
 	addr := fmt.Sprintf(":%s", port)
-	srv := vcs.New()
+	opts := []vcs.Option{}
+	if STORAGE_DIR := os.Getenv("STORAGE_DIR"); STORAGE_DIR != "" {
+		store, err := disk.Open(STORAGE_DIR)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, vcs.WithStore(store))
+	}
+	srv := vcs.New(opts...)
 
 	fmt.Printf("Voracious Code Storage server starting on %s...\n", addr)
 	err := srv.Start(addr)
@@ -0,0 +1,460 @@
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/disk"
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+const help = "OK usage: PUT|GET|LIST|USAGE|LOG|DIFF|DELETE|MOVE|HELP\n"
+
+// Timestamp matches the revision times in LOG responses, which roundTrip replaces with "<time>".
+var timestamp = regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ`)
+
+type connTestCase struct {
+	desc  string
+	input string
+	want  string
+}
+
+// RunConnTests runs each test case on a new server, with each kind of store.
+func runConnTests(t *testing.T, testCases []connTestCase) {
+	stores := map[string]func(t *testing.T) Store{
+		"inmem": func(t *testing.T) Store { return inmem.New() },
+		"disk": func(t *testing.T) Store {
+			s, err := disk.Open(t.TempDir())
+			require.NoError(t, err)
+			return s
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range testCases {
+				t.Run(tc.desc, func(t *testing.T) {
+					s := New(WithStore(newStore(t)))
+					require.Equal(t, tc.want, roundTrip(t, s, tc.input))
+				})
+			}
+		})
+	}
+}
+
+// RoundTrip sends input to a new connection to s, closes the client's side for writing, and returns everything the server sends until it closes the connection.
+func roundTrip(t *testing.T, s *Server, input string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.handleConnection(nc, 0)
+	}()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = io.WriteString(client, input)
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	return timestamp.ReplaceAllString(string(out), "<time>")
+}
+
+func TestConnErrors(t *testing.T) {
+	runConnTests(t, []connTestCase{
+		{
+			desc:  "help",
+			input: "HELP\n",
+			want:  "READY\n" + help + "READY\n",
+		},
+		{
+			desc:  "blank lines are ignored",
+			input: "\n  \nHELP\n",
+			want:  "READY\n" + help + "READY\n",
+		},
+		{
+			desc:  "illegal method closes the connection",
+			input: "FETCH /a.txt\nHELP\n",
+			want:  "READY\nERR illegal method: FETCH\n",
+		},
+		{
+			desc:  "put and get",
+			input: "PUT /a.txt 3\nhi\nGET /a.txt\n",
+			want:  "READY\nOK r1\nREADY\nOK 3\nhi\nREADY\n",
+		},
+		{
+			desc:  "put with no arguments",
+			input: "PUT\nHELP\n",
+			want:  "READY\nERR usage: PUT file length newline data\nREADY\n" + help + "READY\n",
+		},
+		{
+			desc:  "put with no length",
+			input: "PUT /a.txt\n",
+			want:  "READY\nERR usage: PUT file length newline data\nREADY\n",
+		},
+		{
+			desc:  "put with too many arguments",
+			input: "PUT /a.txt 3 4\n",
+			want:  "READY\nERR usage: PUT file length newline data\nREADY\n",
+		},
+		{
+			desc:  "put with a length that isn't a number",
+			input: "PUT /a.txt three\n",
+			want:  "READY\nERR usage: PUT file length newline data\nREADY\n",
+		},
+		{
+			desc:  "put with a negative length",
+			input: "PUT /a.txt -3\n",
+			want:  "READY\nERR usage: PUT file length newline data\nREADY\n",
+		},
+		{
+			desc:  "put of an empty file",
+			input: "PUT /a.txt 0\nGET /a.txt\n",
+			want:  "READY\nOK r1\nREADY\nOK 0\nREADY\n",
+		},
+		{
+			desc:  "put contents without a trailing newline",
+			input: "PUT /a.txt 2\nhiGET /a.txt\n",
+			want:  "READY\nOK r1\nREADY\nOK 2\nhiREADY\n",
+		},
+		{
+			desc:  "put of a relative file name consumes the contents",
+			input: "PUT a.txt 3\nhi\nHELP\n",
+			want:  "READY\nERR illegal file name\nREADY\n" + help + "READY\n",
+		},
+		{
+			desc:  "put of a file too large to store consumes the contents",
+			input: fmt.Sprintf("PUT /a.txt %d\n%s\nHELP\n", maxFileSize+1, strings.Repeat("a", maxFileSize)),
+			want:  "READY\nERR file too large\nREADY\n" + help + "READY\n",
+		},
+		{
+			desc:  "put of a file as large as can be stored",
+			input: fmt.Sprintf("PUT /a.txt %d\n%s\n", maxFileSize, strings.Repeat("a", maxFileSize-1)),
+			want:  "READY\nOK r1\nREADY\n",
+		},
+		{
+			desc:  "put of a directory",
+			input: "PUT /a/ 3\nhi\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "put of the root",
+			input: "PUT / 3\nhi\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "put with an empty path element",
+			input: "PUT /a//b.txt 3\nhi\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "put with an illegal character",
+			input: "PUT /a*.txt 3\nhi\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "put of binary contents",
+			input: "PUT /a.txt 3\n\x00i\nGET /a.txt\n",
+			want:  "READY\nERR text files only\nREADY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "put of non-ASCII contents",
+			input: "PUT /a.txt 3\n\xc2\xa1\n",
+			want:  "READY\nERR text files only\nREADY\n",
+		},
+		{
+			desc:  "put with contents cut short",
+			input: "PUT /a.txt 10\nhi\n",
+			want:  "READY\n",
+		},
+		{
+			desc:  "get with no arguments",
+			input: "GET\n",
+			want:  "READY\nERR usage: GET file [revision]\nREADY\n",
+		},
+		{
+			desc:  "get with too many arguments",
+			input: "GET /a.txt r1 r2\n",
+			want:  "READY\nERR usage: GET file [revision]\nREADY\n",
+		},
+		{
+			desc:  "get of an illegal file name",
+			input: "GET a.txt\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "get of a missing file",
+			input: "GET /a.txt\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "get of a missing file at a revision",
+			input: "GET /a.txt r1\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "get of a missing revision",
+			input: "PUT /a.txt 3\nhi\nGET /a.txt r2\n",
+			want:  "READY\nOK r1\nREADY\nERR no such revision\nREADY\n",
+		},
+		{
+			desc:  "get of revision 0",
+			input: "PUT /a.txt 3\nhi\nGET /a.txt r0\n",
+			want:  "READY\nOK r1\nREADY\nERR no such revision\nREADY\n",
+		},
+		{
+			desc:  "get of a revision that isn't a number",
+			input: "PUT /a.txt 3\nhi\nGET /a.txt rx\n",
+			want:  "READY\nOK r1\nREADY\nERR no such revision\nREADY\n",
+		},
+		{
+			desc:  "get of a revision without the r",
+			input: "PUT /a.txt 3\nhi\nPUT /a.txt 4\nbye\nGET /a.txt 1\n",
+			want:  "READY\nOK r1\nREADY\nOK r2\nREADY\nOK 3\nhi\nREADY\n",
+		},
+		{
+			desc:  "list with no arguments",
+			input: "LIST\n",
+			want:  "READY\nERR usage: LIST dir\nREADY\n",
+		},
+		{
+			desc:  "list with too many arguments",
+			input: "LIST / /a\n",
+			want:  "READY\nERR usage: LIST dir\nREADY\n",
+		},
+		{
+			desc:  "list of an illegal dir name",
+			input: "LIST a\n",
+			want:  "READY\nERR illegal dir name\nREADY\n",
+		},
+		{
+			desc:  "list of a missing dir",
+			input: "LIST /a/\n",
+			want:  "READY\nOK 0\nREADY\n",
+		},
+		{
+			desc:  "list of a dir with or without a trailing slash",
+			input: "PUT /a/b.txt 3\nhi\nLIST /a\nLIST /a/\n",
+			want:  "READY\nOK r1\nREADY\nOK 1\nb.txt r1\nREADY\nOK 1\nb.txt r1\nREADY\n",
+		},
+		{
+			desc:  "usage with no arguments",
+			input: "USAGE\n",
+			want:  "READY\nERR usage: USAGE file\nREADY\n",
+		},
+		{
+			desc:  "usage of an illegal file name",
+			input: "USAGE /a/\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "usage of a missing file",
+			input: "USAGE /a.txt\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+	})
+}
+
+func TestConnHistory(t *testing.T) {
+	const putAB = "PUT /a.txt 4\na\nb\nPUT /a.txt 4\na\nc\n"
+	const putABReply = "READY\nOK r1\nREADY\nOK r2\nREADY\n"
+
+	runConnTests(t, []connTestCase{
+		{
+			desc:  "log",
+			input: putAB + "LOG /a.txt\n",
+			want:  putABReply + "OK 2\nr1 4 <time>\nr2 4 <time>\nREADY\n",
+		},
+		{
+			desc:  "log with no arguments",
+			input: "LOG\n",
+			want:  "READY\nERR usage: LOG file\nREADY\n",
+		},
+		{
+			desc:  "log of an illegal file name",
+			input: "LOG /a/\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "log of a missing file",
+			input: "LOG /a.txt\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "diff",
+			input: putAB + "DIFF /a.txt r1 2\n",
+			want:  putABReply + "OK 53\n--- /a.txt r1\n+++ /a.txt r2\n@@ -1,2 +1,2 @@\n a\n-b\n+c\nREADY\n",
+		},
+		{
+			desc:  "diff of a revision with itself",
+			input: putAB + "DIFF /a.txt r2 r2\n",
+			want:  putABReply + "OK 0\nREADY\n",
+		},
+		{
+			desc:  "diff with one revision",
+			input: "DIFF /a.txt r1\n",
+			want:  "READY\nERR usage: DIFF file revision revision\nREADY\n",
+		},
+		{
+			desc:  "diff of an illegal file name",
+			input: "DIFF a.txt r1 r2\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "diff of a missing file",
+			input: "DIFF /a.txt r1 r2\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "diff of a missing revision",
+			input: putAB + "DIFF /a.txt r1 r3\n",
+			want:  putABReply + "ERR no such revision\nREADY\n",
+		},
+		{
+			desc:  "delete",
+			input: putAB + "DELETE /a.txt\nGET /a.txt\nGET /a.txt r2\nLIST /\nLOG /a.txt\n",
+			want: putABReply +
+				"OK r3\nREADY\n" +
+				"ERR no such file\nREADY\n" +
+				"OK 4\na\nc\nREADY\n" +
+				"OK 0\nREADY\n" +
+				"OK 3\nr1 4 <time>\nr2 4 <time>\nr3 0 <time> DELETED\nREADY\n",
+		},
+		{
+			desc:  "delete leaves no empty directories",
+			input: "PUT /a/b.txt 3\nhi\nDELETE /a/b.txt\nLIST /\n",
+			want:  "READY\nOK r1\nREADY\nOK r2\nREADY\nOK 0\nREADY\n",
+		},
+		{
+			desc:  "diff with a tombstone",
+			input: putAB + "DELETE /a.txt\nDIFF /a.txt r2 r3\n",
+			want:  putABReply + "OK r3\nREADY\nERR no such revision\nREADY\n",
+		},
+		{
+			desc:  "put after delete",
+			input: putAB + "DELETE /a.txt\nPUT /a.txt 2\nd\nGET /a.txt\nGET /a.txt r1\nLIST /\n",
+			want: putABReply +
+				"OK r3\nREADY\n" +
+				"OK r4\nREADY\n" +
+				"OK 2\nd\nREADY\n" +
+				"OK 4\na\nb\nREADY\n" +
+				"OK 1\na.txt r4\nREADY\n",
+		},
+		{
+			desc:  "delete with no arguments",
+			input: "DELETE\n",
+			want:  "READY\nERR usage: DELETE file\nREADY\n",
+		},
+		{
+			desc:  "delete of an illegal file name",
+			input: "DELETE a.txt\n",
+			want:  "READY\nERR illegal file name\nREADY\n",
+		},
+		{
+			desc:  "delete of a missing file",
+			input: "DELETE /a.txt\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "delete of a deleted file",
+			input: "PUT /a.txt 3\nhi\nDELETE /a.txt\nDELETE /a.txt\n",
+			want:  "READY\nOK r1\nREADY\nOK r2\nREADY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "move",
+			input: putAB + "MOVE /a.txt /b/a.txt\nGET /b/a.txt r1\nGET /a.txt\nLIST /\nPUT /b/a.txt 2\nd\n",
+			want: putABReply +
+				"OK r2\nREADY\n" +
+				"OK 4\na\nb\nREADY\n" +
+				"ERR no such file\nREADY\n" +
+				"OK 1\nb/ DIR\nREADY\n" +
+				"OK r3\nREADY\n",
+		},
+		{
+			desc:  "put where a file was moved from",
+			input: putAB + "MOVE /a.txt /b.txt\nPUT /a.txt 2\nd\nGET /b.txt r1\nGET /a.txt r1\n",
+			want: putABReply +
+				"OK r2\nREADY\n" +
+				"OK r1\nREADY\n" +
+				"OK 4\na\nb\nREADY\n" +
+				"OK 2\nd\nREADY\n",
+		},
+		{
+			desc:  "move to the same path",
+			input: putAB + "MOVE /a.txt /a.txt\nGET /a.txt\n",
+			want:  putABReply + "OK r2\nREADY\nOK 4\na\nc\nREADY\n",
+		},
+		{
+			desc:  "move onto a file",
+			input: putAB + "PUT /b.txt 2\nd\nMOVE /a.txt /b.txt\n",
+			want:  putABReply + "OK r1\nREADY\nERR file exists\nREADY\n",
+		},
+		{
+			desc:  "move onto a deleted file",
+			input: putAB + "PUT /b.txt 2\nd\nDELETE /b.txt\nMOVE /a.txt /b.txt\n",
+			want:  putABReply + "OK r1\nREADY\nOK r2\nREADY\nERR file exists\nREADY\n",
+		},
+		{
+			desc:  "move of a missing file",
+			input: "MOVE /a.txt /b.txt\n",
+			want:  "READY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "move of a deleted file",
+			input: "PUT /a.txt 3\nhi\nDELETE /a.txt\nMOVE /a.txt /b.txt\n",
+			want:  "READY\nOK r1\nREADY\nOK r2\nREADY\nERR no such file\nREADY\n",
+		},
+		{
+			desc:  "move with one file",
+			input: "MOVE /a.txt\n",
+			want:  "READY\nERR usage: MOVE file file\nREADY\n",
+		},
+		{
+			desc:  "move to an illegal file name",
+			input: "PUT /a.txt 3\nhi\nMOVE /a.txt /b/\n",
+			want:  "READY\nOK r1\nREADY\nERR illegal file name\nREADY\n",
+		},
+	})
+}
+
+func TestValidPath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		wantPath bool
+		wantFile bool
+	}{
+		{path: "/", wantPath: true, wantFile: false},
+		{path: "/a.txt", wantPath: true, wantFile: true},
+		{path: "/a/b-c_d.E9", wantPath: true, wantFile: true},
+		{path: "/a/", wantPath: true, wantFile: false},
+		{path: "", wantPath: false, wantFile: false},
+		{path: "a.txt", wantPath: false, wantFile: false},
+		{path: "/a//b", wantPath: false, wantFile: false},
+		{path: "//", wantPath: false, wantFile: false},
+		{path: "/a b", wantPath: false, wantFile: false},
+		{path: "/a~", wantPath: false, wantFile: false},
+		{path: "/é", wantPath: false, wantFile: false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.wantPath, validPath(tc.path), "validPath(%q)", tc.path)
+		require.Equal(t, tc.wantFile, validFileName(tc.path), "validFileName(%q)", tc.path)
+	}
+}
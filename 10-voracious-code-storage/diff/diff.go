@@ -0,0 +1,257 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Op is what an Edit does with its lines.
+	Op int
+
+	// Edit is a run of N lines with the same Op. A and B are the indexes of its first line in a and b.
+	Edit struct {
+		Op Op
+		A  int
+		B  int
+		N  int
+	}
+)
+
+const (
+	Equal  Op = iota // Lines kept from a.
+	Delete           // Lines of a removed.
+	Insert           // Lines of b added.
+)
+
+// maxCost bounds the work Lines does on very different inputs. Past it, the rest of a is replaced by the rest of b, which is a correct edit script, if not the shortest.
+const maxCost = 1000
+
+// Lines returns an edit script turning a into b, using Myers' algorithm. For inputs within maxCost edits of each other, it is the shortest script.
+func Lines(a, b []string) []Edit {
+	// Small edits leave most lines in a common prefix and suffix. Trim them so the search only runs over what changed.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var edits []Edit
+	edits = appendEdit(edits, Edit{Op: Equal, A: 0, B: 0, N: pre})
+	for _, e := range myers(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		e.A += pre
+		e.B += pre
+		edits = appendEdit(edits, e)
+	}
+	return appendEdit(edits, Edit{Op: Equal, A: len(a) - suf, B: len(b) - suf, N: suf})
+}
+
+// SplitLines splits text after each newline. The last line has no newline if text doesn't end with one.
+func SplitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Myers returns the shortest edit script turning a into b, one line per edit, unless it costs more than maxCost.
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	max := n + m
+	offset := max + 1
+	// V holds the furthest x reached on each diagonal k = x - y.
+	v := make([]int, 2*max+3)
+	// Trace holds the diagonals -d-1..d+1 of v before each round d, to walk back through.
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		if d > maxCost {
+			return replace(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down from diagonal k+1: insert.
+			} else {
+				x = v[offset+k-1] + 1 // Right from diagonal k-1: delete.
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+// Backtrack walks back from (n, m) through the rounds of the search, and returns the edits in order.
+func backtrack(trace [][]int, n, m int) []Edit {
+	var rev []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, Edit{Op: Equal, A: x, B: y, N: 1})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			rev = append(rev, Edit{Op: Insert, A: x, B: prevY, N: 1})
+		} else {
+			rev = append(rev, Edit{Op: Delete, A: prevX, B: y, N: 1})
+		}
+		x, y = prevX, prevY
+	}
+
+	var edits []Edit
+	for i := len(rev) - 1; i >= 0; i-- {
+		edits = appendEdit(edits, rev[i])
+	}
+	return edits
+}
+
+func replace(a, b []string) []Edit {
+	var edits []Edit
+	edits = appendEdit(edits, Edit{Op: Delete, A: 0, B: 0, N: len(a)})
+	return appendEdit(edits, Edit{Op: Insert, A: len(a), B: 0, N: len(b)})
+}
+
+// AppendEdit adds e to edits, merging it into the last edit if it carries on from it.
+func appendEdit(edits []Edit, e Edit) []Edit {
+	if e.N == 0 {
+		return edits
+	}
+	if len(edits) > 0 {
+		last := &edits[len(edits)-1]
+		if next := last.end(); last.Op == e.Op && next.A == e.A && next.B == e.B {
+			last.N += e.N
+			return edits
+		}
+	}
+	return append(edits, e)
+}
+
+// End returns where an edit carrying on from e would start.
+func (e Edit) end() Edit {
+	next := Edit{Op: e.Op, A: e.A, B: e.B}
+	if e.Op != Insert {
+		next.A += e.N
+	}
+	if e.Op != Delete {
+		next.B += e.N
+	}
+	return next
+}
+
+// context is the number of unchanged lines Unified shows around each change.
+const context = 3
+
+// Unified returns the differences between a and b in unified diff format, with a and b named nameA and nameB in the header. It returns "" if a and b are the same.
+func Unified(nameA, nameB string, a, b []string) string {
+	edits := Lines(a, b)
+
+	var buf strings.Builder
+	var h *hunk
+	for i, e := range edits {
+		if e.Op == Equal {
+			if h == nil {
+				continue
+			}
+			// Changes close enough to share their context go in the same hunk.
+			if i < len(edits)-1 && e.N <= 2*context {
+				h.add(' ', a[e.A:e.A+e.N], true, true)
+				continue
+			}
+			h.add(' ', a[e.A:e.A+min(e.N, context)], true, true)
+			h.writeTo(&buf)
+			h = nil
+			continue
+		}
+
+		if h == nil {
+			n := 0
+			if i > 0 {
+				n = min(edits[i-1].N, context)
+			}
+			h = &hunk{a: e.A - n, b: e.B - n}
+			h.add(' ', a[e.A-n:e.A], true, true)
+		}
+		if e.Op == Delete {
+			h.add('-', a[e.A:e.A+e.N], true, false)
+		} else {
+			h.add('+', b[e.B:e.B+e.N], false, true)
+		}
+	}
+	if h != nil {
+		h.writeTo(&buf)
+	}
+
+	if buf.Len() == 0 {
+		return ""
+	}
+	return "--- " + nameA + "\n+++ " + nameB + "\n" + buf.String()
+}
+
+// Hunk is a run of changed lines, with the unchanged lines around them. A and B are the indexes of its first line in a and b, and NA and NB its number of lines from each.
+type hunk struct {
+	a, b   int
+	na, nb int
+	lines  strings.Builder
+}
+
+func (h *hunk) add(prefix byte, lines []string, inA, inB bool) {
+	for _, l := range lines {
+		h.lines.WriteByte(prefix)
+		h.lines.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			h.lines.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	if inA {
+		h.na += len(lines)
+	}
+	if inB {
+		h.nb += len(lines)
+	}
+}
+
+func (h *hunk) writeTo(buf *strings.Builder) {
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(h.a, h.na), hunkRange(h.b, h.nb))
+	buf.WriteString(h.lines.String())
+}
+
+// HunkRange formats the lines of a hunk from one side, numbered from 1. An empty range is given as the line before it.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
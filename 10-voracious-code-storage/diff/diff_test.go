@@ -0,0 +1,188 @@
+package diff_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/diff"
+	"github.com/stretchr/testify/require"
+)
+
+// apply follows the edit script over a, checking it accounts for every line of a and b in order.
+func apply(t *testing.T, a, b []string, edits []diff.Edit) []string {
+	t.Helper()
+	var out []string
+	i, j := 0, 0
+	for _, e := range edits {
+		require.Equal(t, i, e.A, "edit %+v", e)
+		require.Equal(t, j, e.B, "edit %+v", e)
+		switch e.Op {
+		case diff.Equal:
+			require.Equal(t, a[i:i+e.N], b[j:j+e.N])
+			out = append(out, a[i:i+e.N]...)
+			i += e.N
+			j += e.N
+		case diff.Delete:
+			i += e.N
+		case diff.Insert:
+			out = append(out, b[j:j+e.N]...)
+			j += e.N
+		}
+	}
+	require.Equal(t, len(a), i)
+	require.Equal(t, len(b), j)
+	return out
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func randomLines(rng *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = string(rune('a'+rng.Intn(4))) + "\n"
+	}
+	return lines
+}
+
+func TestLines(t *testing.T) {
+	t.Run("example", func(t *testing.T) {
+		a := diff.SplitLines("a\nb\nc\nd\n")
+		b := diff.SplitLines("a\nc\nd\ne\n")
+		require.Equal(t, []diff.Edit{
+			{Op: diff.Equal, A: 0, B: 0, N: 1},
+			{Op: diff.Delete, A: 1, B: 1, N: 1},
+			{Op: diff.Equal, A: 2, B: 1, N: 2},
+			{Op: diff.Insert, A: 4, B: 3, N: 1},
+		}, diff.Lines(a, b))
+	})
+
+	t.Run("is the shortest edit script", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 1000; i++ {
+			a := randomLines(rng, rng.Intn(20))
+			b := randomLines(rng, rng.Intn(20))
+			edits := diff.Lines(a, b)
+			require.Equal(t, b, append([]string{}, apply(t, a, b, edits)...), "a %q b %q", a, b)
+
+			changed := 0
+			for _, e := range edits {
+				if e.Op != diff.Equal {
+					changed += e.N
+				}
+			}
+			require.Equal(t, len(a)+len(b)-2*lcs(a, b), changed, "a %q b %q", a, b)
+		}
+	})
+
+	t.Run("falls back to replacing very different inputs", func(t *testing.T) {
+		a := make([]string, 3000)
+		b := make([]string, 3000)
+		for i := range a {
+			a[i] = "a\n"
+			b[i] = "b\n"
+		}
+		edits := diff.Lines(a, b)
+		require.Equal(t, b, apply(t, a, b, edits))
+	})
+}
+
+func TestSplitLines(t *testing.T) {
+	testCases := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: []string{}},
+		{text: "\n", want: []string{"\n"}},
+		{text: "a\nb\n", want: []string{"a\n", "b\n"}},
+		{text: "a\nb", want: []string{"a\n", "b"}},
+	}
+
+	for _, tc := range testCases {
+		got := diff.SplitLines(tc.text)
+		require.Equal(t, tc.want, append([]string{}, got...), "%q", tc.text)
+		require.Equal(t, tc.text, strings.Join(got, ""))
+	}
+}
+
+func TestUnified(t *testing.T) {
+	lines := func(n int) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			sb.WriteString(string(rune('a'+i-1)) + "\n")
+		}
+		return sb.String()
+	}
+
+	testCases := []struct {
+		desc string
+		a, b string
+		want string
+	}{
+		{
+			desc: "same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			desc: "from empty",
+			a:    "",
+			b:    "a\nb\n",
+			want: "--- A\n+++ B\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			desc: "to empty",
+			a:    "a\n",
+			b:    "",
+			want: "--- A\n+++ B\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			desc: "context is limited to three lines",
+			a:    lines(9),
+			b:    strings.Replace(lines(9), "e\n", "E\n", 1),
+			want: "--- A\n+++ B\n@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			desc: "nearby changes share a hunk",
+			a:    lines(12),
+			b:    strings.NewReplacer("b\n", "B\n", "h\n", "H\n").Replace(lines(12)),
+			want: "--- A\n+++ B\n@@ -1,11 +1,11 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n-h\n+H\n i\n j\n k\n",
+		},
+		{
+			desc: "distant changes get their own hunks",
+			a:    lines(12),
+			b:    strings.NewReplacer("a\n", "A\n", "l\n", "L\n").Replace(lines(12)),
+			want: "--- A\n+++ B\n@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n d\n@@ -9,4 +9,4 @@\n i\n j\n k\n-l\n+L\n",
+		},
+		{
+			desc: "missing newline at end of file",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: "--- A\n+++ B\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := diff.Unified("A", "B", diff.SplitLines(tc.a), diff.SplitLines(tc.b))
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/history"
+)
+
+var (
+	ErrNotFound = fmt.Errorf("not found: %w", fs.ErrNotExist)
+	ErrExists   = fmt.Errorf("exists: %w", fs.ErrExist)
+)
+
+type (
+	// Store keeps file revisions on disk. Revision contents are stored once per distinct content, as blobs named by their SHA-256. Each file's list of revisions is kept in its own metadata file, which is replaced atomically on every new revision.
+	//
+	// Layout under the root directory:
+	//
+	//	blobs/ab/abcdef...  Revision contents, by SHA-256
+	//	files/0123abcd.json Revisions of one file, named by the SHA-256 of its path
+	Store struct {
+		root string
+		mu   sync.RWMutex
+		// Revisions of each file, by public path. Rebuilt from the metadata files on Open.
+		files map[string]*fileMeta
+	}
+
+	// FileMeta is the metadata file of one public file.
+	fileMeta struct {
+		Path      string     `json:"path"`
+		Revisions []revision `json:"revisions"`
+	}
+
+	revision struct {
+		ID      string    `json:"id"`
+		Blob    string    `json:"blob"` // SHA-256 of the contents, hex encoded. Empty for a tombstone.
+		Size    int64     `json:"size"`
+		Created time.Time `json:"created"`
+		Deleted bool      `json:"deleted,omitempty"`
+	}
+)
+
+const (
+	blobsDir = "blobs"
+	filesDir = "files"
+	// Prefix of files being written. Any left over from a crash are removed on Open.
+	tmpPrefix = ".tmp-"
+)
+
+// Open opens the store in dir, creating it if needed, and rebuilds the index of files from their metadata.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		root:  dir,
+		files: make(map[string]*fileMeta, 512),
+	}
+	for _, d := range []string{blobsDir, filesDir} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return nil, fmt.Errorf("os.MkdirAll: %w", err)
+		}
+	}
+	if err := s.loadIndex(); err != nil {
+		return nil, fmt.Errorf("loadIndex: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Store) loadIndex() error {
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), tmpPrefix) {
+			return os.Remove(p)
+		}
+		if filepath.Dir(p) != filepath.Join(s.root, filesDir) {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var meta fileMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		s.files[meta.Path] = &meta
+		return nil
+	})
+}
+
+// CreateRevision stores the contents of r as a new revision of the file at filePath, and returns its size and revision ID.
+func (s *Store) CreateRevision(filePath string, r io.Reader) (int64, string, error) {
+	// Write the blob before taking the lock. Concurrent writes of the same content write the same bytes.
+	sum, size, err := s.writeBlob(r)
+	if err != nil {
+		return 0, "", fmt.Errorf("writeBlob: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.appendRevision(filePath, revision{Blob: sum, Size: size})
+	if err != nil {
+		return 0, "", err
+	}
+	return size, id, nil
+}
+
+// AppendRevision numbers rev as the next revision of the file at filePath, and writes the file's metadata. The caller must hold the write lock.
+func (s *Store) appendRevision(filePath string, rev revision) (string, error) {
+	meta := fileMeta{Path: filePath}
+	if cur, ok := s.files[filePath]; ok {
+		meta.Revisions = slices.Clone(cur.Revisions)
+	}
+	rev.ID = fmt.Sprintf("r%d", len(meta.Revisions)+1)
+	rev.Created = time.Now().UTC()
+	meta.Revisions = append(meta.Revisions, rev)
+
+	if err := s.writeMeta(&meta); err != nil {
+		return "", err
+	}
+	s.files[filePath] = &meta
+	return rev.ID, nil
+}
+
+func (s *Store) writeMeta(meta *fileMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if err := s.writeFileAtomic(s.metaPath(meta.Path), data); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	return nil
+}
+
+// GetRevision opens a revision of the file at filePath. An empty revID opens the latest revision. A deleted file's latest revision, or any tombstone, isn't found.
+func (s *Store) GetRevision(filePath, revID string) (fs.File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, ok := s.files[filePath]
+	if !ok || len(meta.Revisions) == 0 {
+		return nil, fmt.Errorf("no revisions for %s: %w", filePath, ErrNotFound)
+	}
+
+	i := len(meta.Revisions) - 1
+	if revID != "" {
+		i = slices.IndexFunc(meta.Revisions, func(r revision) bool { return r.ID == revID })
+		if i < 0 {
+			return nil, fmt.Errorf("no revision %s for %s: %w", revID, filePath, ErrNotFound)
+		}
+	}
+	if r := meta.Revisions[i]; r.Deleted {
+		return nil, fmt.Errorf("%s deleted at %s: %w", filePath, r.ID, ErrNotFound)
+	}
+	return s.openBlob(meta.Revisions[i].Blob)
+}
+
+// Usage returns the bytes of the blobs kept for the file at filePath, and the total size of its revisions. Revisions with the same contents share a blob, which is counted once.
+func (s *Store) Usage(filePath string) (stored, size int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, ok := s.files[filePath]
+	if !ok || len(meta.Revisions) == 0 {
+		return 0, 0, fmt.Errorf("no revisions for %s: %w", filePath, ErrNotFound)
+	}
+	blobs := map[string]bool{}
+	for _, r := range meta.Revisions {
+		size += r.Size
+		if !blobs[r.Blob] {
+			blobs[r.Blob] = true
+			stored += r.Size
+		}
+	}
+	return stored, size, nil
+}
+
+// ListEntries returns a list of entries in the given path.
+// If the entry is a file, the list item will contain the file name and the latest revision ID.
+// If the entry is a directory, the list item will contain the directory name followed by a space and the string "DIR".
+// Deleted files aren't listed, nor are directories holding only deleted files.
+// Ex:
+//
+//	"/tmp" -> ["dirA/ DIR", "test.txt r2"]
+func (s *Store) ListEntries(dir string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	dirs := map[string]bool{}
+	res := []string{}
+	for p, meta := range s.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok || len(meta.Revisions) == 0 || meta.Revisions[len(meta.Revisions)-1].Deleted {
+			continue
+		}
+		if name, _, isDir := strings.Cut(rest, "/"); isDir {
+			if !dirs[name] {
+				dirs[name] = true
+				res = append(res, fmt.Sprintf("%s/ DIR", name))
+			}
+			continue
+		}
+		latest := meta.Revisions[len(meta.Revisions)-1]
+		res = append(res, fmt.Sprintf("%s %s", rest, latest.ID))
+	}
+
+	slices.Sort(res)
+	return res, nil
+}
+
+// Log lists the revisions of the file at filePath, oldest first, including tombstones.
+func (s *Store) Log(filePath string) ([]history.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, ok := s.files[filePath]
+	if !ok || len(meta.Revisions) == 0 {
+		return nil, fmt.Errorf("no revisions for %s: %w", filePath, ErrNotFound)
+	}
+	res := make([]history.Revision, 0, len(meta.Revisions))
+	for _, r := range meta.Revisions {
+		res = append(res, history.Revision{ID: r.ID, Size: r.Size, Created: r.Created, Deleted: r.Deleted})
+	}
+	return res, nil
+}
+
+// Delete adds a tombstone revision to the file at filePath, and returns its ID. The earlier revisions can still be read.
+func (s *Store) Delete(filePath string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.live(filePath) {
+		return "", fmt.Errorf("no file %s: %w", filePath, ErrNotFound)
+	}
+	return s.appendRevision(filePath, revision{Deleted: true})
+}
+
+// Move moves the revisions of the file at src to dst, and returns the ID of the latest. Dst must have no revisions, not even deleted ones.
+//
+// The metadata is written under dst before it's removed from src. A crash in between leaves the history under both paths.
+func (s *Store) Move(src, dst string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.live(src) {
+		return "", fmt.Errorf("no file %s: %w", src, ErrNotFound)
+	}
+	cur := s.files[src]
+	latest := cur.Revisions[len(cur.Revisions)-1].ID
+	if src == dst {
+		return latest, nil
+	}
+	if m, ok := s.files[dst]; ok && len(m.Revisions) > 0 {
+		return "", fmt.Errorf("%s has revisions: %w", dst, ErrExists)
+	}
+
+	meta := fileMeta{Path: dst, Revisions: cur.Revisions}
+	if err := s.writeMeta(&meta); err != nil {
+		return "", err
+	}
+	if err := os.Remove(s.metaPath(src)); err != nil {
+		// Undo writing dst, so the disk matches the index again. If that fails too, index dst as well, so a retried Move sees what the next Open would.
+		if rmErr := os.Remove(s.metaPath(dst)); rmErr != nil {
+			s.files[dst] = &meta
+		}
+		return "", fmt.Errorf("os.Remove: %w", err)
+	}
+	if err := syncDir(filepath.Dir(s.metaPath(src))); err != nil {
+		return "", fmt.Errorf("syncDir: %w", err)
+	}
+	s.files[dst] = &meta
+	delete(s.files, src)
+	return latest, nil
+}
+
+// Live reports whether the file at filePath has revisions, and isn't deleted. The caller must hold the lock.
+func (s *Store) live(filePath string) bool {
+	meta, ok := s.files[filePath]
+	return ok && len(meta.Revisions) > 0 && !meta.Revisions[len(meta.Revisions)-1].Deleted
+}
+
+// WriteBlob copies r into a blob named by its SHA-256, unless the blob exists already.
+func (s *Store) writeBlob(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, blobsDir), tmpPrefix+"*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed.
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	p := s.blobPath(sum)
+	if _, err := os.Stat(p); err == nil {
+		return sum, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", 0, err
+	}
+	if err := syncDir(filepath.Dir(p)); err != nil {
+		return "", 0, err
+	}
+	return sum, size, nil
+}
+
+func (s *Store) openBlob(sum string) (fs.File, error) {
+	f, err := os.Open(s.blobPath(sum))
+	if err != nil {
+		return nil, fmt.Errorf("open blob: %w", err)
+	}
+	return f, nil
+}
+
+// WriteFileAtomic replaces the file at p with data. Readers, and the store after a crash, see either the old file or the new one.
+func (s *Store) writeFileAtomic(p string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+// SyncDir flushes the directory's entries to disk, so a rename or removal in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cErr := d.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+func (s *Store) blobPath(sum string) string {
+	return filepath.Join(s.root, blobsDir, sum[:2], sum)
+}
+
+func (s *Store) metaPath(filePath string) string {
+	sum := sha256.Sum256([]byte(filePath))
+	return filepath.Join(s.root, filesDir, hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,214 @@
+package disk_test
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	vcs "github.com/harveysanders/protohackers/10-voracious-code-storage"
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/disk"
+	"github.com/stretchr/testify/require"
+)
+
+var _ vcs.Store = (*disk.Store)(nil)
+
+func readRevision(t *testing.T, s *disk.Store, filePath, rev string) string {
+	t.Helper()
+	f, err := s.GetRevision(filePath, rev)
+	require.NoError(t, err)
+	defer f.Close()
+	contents, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+// countBlobs returns the number of revision blobs stored in dir.
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(dir, "blobs"), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func TestStore(t *testing.T) {
+	t.Run("stores and reads revisions", func(t *testing.T) {
+		s, err := disk.Open(t.TempDir())
+		require.NoError(t, err)
+
+		n, rev, err := s.CreateRevision("/test.txt", strings.NewReader("Hello, World!\n"))
+		require.NoError(t, err)
+		require.Equal(t, int64(14), n)
+		require.Equal(t, "r1", rev)
+
+		_, rev, err = s.CreateRevision("/test.txt", strings.NewReader("hola\n"))
+		require.NoError(t, err)
+		require.Equal(t, "r2", rev)
+
+		require.Equal(t, "Hello, World!\n", readRevision(t, s, "/test.txt", "r1"))
+		require.Equal(t, "hola\n", readRevision(t, s, "/test.txt", "r2"))
+		require.Equal(t, "hola\n", readRevision(t, s, "/test.txt", ""))
+
+		f, err := s.GetRevision("/test.txt", "")
+		require.NoError(t, err)
+		defer f.Close()
+		stat, err := f.Stat()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), stat.Size())
+	})
+
+	t.Run("reports missing files and revisions", func(t *testing.T) {
+		s, err := disk.Open(t.TempDir())
+		require.NoError(t, err)
+		_, err = s.GetRevision("/missing.txt", "")
+		require.ErrorIs(t, err, disk.ErrNotFound)
+
+		_, _, err = s.CreateRevision("/test.txt", strings.NewReader("hi\n"))
+		require.NoError(t, err)
+		_, err = s.GetRevision("/test.txt", "r2")
+		require.ErrorIs(t, err, disk.ErrNotFound)
+	})
+
+	t.Run("stores identical contents once", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := disk.Open(dir)
+		require.NoError(t, err)
+
+		for _, p := range []string{"/a.txt", "/a.txt", "/b/a.txt"} {
+			_, _, err := s.CreateRevision(p, strings.NewReader("same\n"))
+			require.NoError(t, err)
+		}
+		_, _, err = s.CreateRevision("/a.txt", strings.NewReader("different\n"))
+		require.NoError(t, err)
+
+		require.Equal(t, 2, countBlobs(t, dir))
+		stored, size, err := s.Usage("/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, int64(5+5+10), size)
+		require.Equal(t, int64(5+10), stored)
+		require.Equal(t, "same\n", readRevision(t, s, "/a.txt", "r2"))
+		require.Equal(t, "same\n", readRevision(t, s, "/b/a.txt", "r1"))
+	})
+
+	t.Run("rebuilds the index when reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := disk.Open(dir)
+		require.NoError(t, err)
+		for _, p := range []string{"/test.txt", "/test.txt", "/abc/test.txt", "/abc/def/x.txt"} {
+			_, _, err := s.CreateRevision(p, strings.NewReader(p+"\n"))
+			require.NoError(t, err)
+		}
+		// A crash part way through a write leaves a temp file behind.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "files", ".tmp-123"), []byte(`{"path":`), 0644))
+
+		s, err = disk.Open(dir)
+		require.NoError(t, err)
+		require.Equal(t, "/test.txt\n", readRevision(t, s, "/test.txt", "r1"))
+
+		entries, err := s.ListEntries("/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"abc/ DIR", "test.txt r2"}, entries)
+
+		entries, err = s.ListEntries("/abc")
+		require.NoError(t, err)
+		require.Equal(t, []string{"def/ DIR", "test.txt r1"}, entries)
+
+		_, rev, err := s.CreateRevision("/test.txt", strings.NewReader("again\n"))
+		require.NoError(t, err)
+		require.Equal(t, "r3", rev)
+		_, err = os.Stat(filepath.Join(dir, "files", ".tmp-123"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("numbers concurrent revisions in order", func(t *testing.T) {
+		s, err := disk.Open(t.TempDir())
+		require.NoError(t, err)
+
+		const n = 20
+		var wg sync.WaitGroup
+		revs := make(chan string, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, rev, err := s.CreateRevision("/test.txt", strings.NewReader(strings.Repeat("x", i)+"\n"))
+				require.NoError(t, err)
+				revs <- rev
+			}(i)
+		}
+		wg.Wait()
+		close(revs)
+
+		seen := map[string]bool{}
+		for rev := range revs {
+			seen[rev] = true
+		}
+		require.Len(t, seen, n)
+		entries, err := s.ListEntries("/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"test.txt r20"}, entries)
+	})
+
+	t.Run("deletes and moves files, across reopens", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := disk.Open(dir)
+		require.NoError(t, err)
+		for _, contents := range []string{"one\n", "two\n"} {
+			_, _, err := s.CreateRevision("/a.txt", strings.NewReader(contents))
+			require.NoError(t, err)
+		}
+		_, _, err = s.CreateRevision("/b.txt", strings.NewReader("b\n"))
+		require.NoError(t, err)
+
+		rev, err := s.Delete("/b.txt")
+		require.NoError(t, err)
+		require.Equal(t, "r2", rev)
+		_, err = s.Delete("/b.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		rev, err = s.Move("/a.txt", "/c/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "r2", rev)
+		_, err = s.Move("/a.txt", "/d.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, _, err = s.CreateRevision("/d.txt", strings.NewReader("d\n"))
+		require.NoError(t, err)
+		_, err = s.Move("/d.txt", "/b.txt")
+		require.ErrorIs(t, err, fs.ErrExist)
+
+		s, err = disk.Open(dir)
+		require.NoError(t, err)
+
+		_, err = s.GetRevision("/b.txt", "")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = s.GetRevision("/b.txt", "r2")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		require.Equal(t, "b\n", readRevision(t, s, "/b.txt", "r1"))
+		require.Equal(t, "one\n", readRevision(t, s, "/c/a.txt", "r1"))
+		_, err = s.GetRevision("/a.txt", "r1")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		revs, err := s.Log("/b.txt")
+		require.NoError(t, err)
+		require.Len(t, revs, 2)
+		require.Equal(t, "r1", revs[0].ID)
+		require.Equal(t, int64(2), revs[0].Size)
+		require.False(t, revs[0].Deleted)
+		require.Equal(t, "r2", revs[1].ID)
+		require.True(t, revs[1].Deleted)
+		require.False(t, revs[1].Created.Before(revs[0].Created))
+
+		entries, err := s.ListEntries("/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"c/ DIR", "d.txt r1"}, entries)
+	})
+}
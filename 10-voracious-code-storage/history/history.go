@@ -0,0 +1,14 @@
+// Package history describes the revisions of a file, for stores to report them in the same form.
+package history
+
+import "time"
+
+// Revision describes one revision of a file.
+type Revision struct {
+	ID string // "r1"
+	// Size of the revision's contents.
+	Size    int64
+	Created time.Time
+	// Whether the revision is a tombstone, left when the file was deleted. A tombstone has no contents.
+	Deleted bool
+}
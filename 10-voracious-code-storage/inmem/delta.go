@@ -0,0 +1,89 @@
+package inmem
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/diff"
+)
+
+// A delta is a line-based edit script that rebuilds a revision from the previous one. It is a list of commands, each on its own line:
+//
+//	c<start> <n>   Copy n lines of the previous revision, from line start.
+//	i<len>         Insert the len bytes that follow the command line.
+//
+// Lines of the previous revision that aren't copied are dropped.
+
+// EncodeDelta returns the delta from prev to next.
+func encodeDelta(prev, next []byte) []byte {
+	a := diff.SplitLines(string(prev))
+	b := diff.SplitLines(string(next))
+
+	var buf bytes.Buffer
+	for _, e := range diff.Lines(a, b) {
+		switch e.Op {
+		case diff.Equal:
+			fmt.Fprintf(&buf, "c%d %d\n", e.A, e.N)
+		case diff.Insert:
+			n := 0
+			for _, l := range b[e.B : e.B+e.N] {
+				n += len(l)
+			}
+			fmt.Fprintf(&buf, "i%d\n", n)
+			for _, l := range b[e.B : e.B+e.N] {
+				buf.WriteString(l)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// ApplyDelta rebuilds the next revision from prev and the delta between them.
+func applyDelta(prev, delta []byte) ([]byte, error) {
+	lines := bytes.SplitAfter(prev, []byte{'\n'})
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	out := make([]byte, 0, len(prev)+len(delta))
+	for len(delta) > 0 {
+		cmd, rest, ok := bytes.Cut(delta, []byte{'\n'})
+		if !ok || len(cmd) == 0 {
+			return nil, fmt.Errorf("invalid delta command %q", cmd)
+		}
+		delta = rest
+
+		switch cmd[0] {
+		case 'c':
+			rawStart, rawN, _ := bytes.Cut(cmd[1:], []byte{' '})
+			start, err := strconv.Atoi(string(rawStart))
+			if err != nil {
+				return nil, fmt.Errorf("copy command %q: %w", cmd, err)
+			}
+			n, err := strconv.Atoi(string(rawN))
+			if err != nil {
+				return nil, fmt.Errorf("copy command %q: %w", cmd, err)
+			}
+			if start < 0 || n < 0 || start+n > len(lines) {
+				return nil, fmt.Errorf("copy command %q: out of range of %d lines", cmd, len(lines))
+			}
+			for _, l := range lines[start : start+n] {
+				out = append(out, l...)
+			}
+		case 'i':
+			n, err := strconv.Atoi(string(cmd[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("insert command %q: %w", cmd, err)
+			}
+			if n < 0 || n > len(delta) {
+				return nil, fmt.Errorf("insert command %q: %d bytes left in delta", cmd, len(delta))
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+		default:
+			return nil, fmt.Errorf("unknown delta command %q", cmd)
+		}
+	}
+	return out, nil
+}
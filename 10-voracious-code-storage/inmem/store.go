@@ -1,50 +1,70 @@
 package inmem
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
-	"os"
 	"path"
-	"regexp"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/history"
 	"github.com/harveysanders/protohackers/fs/inmem"
 )
 
-var ErrNotFound = fmt.Errorf("not found")
+var (
+	ErrNotFound = fmt.Errorf("not found: %w", fs.ErrNotExist)
+	ErrExists   = fmt.Errorf("exists: %w", fs.ErrExist)
+)
 
 type revision struct {
 	privatePath string
 	id          string
+	// Size of the revision's contents.
+	size int64
+	// Bytes kept for the revision, whether a snapshot or a delta.
+	stored int64
+	// Whether the revision is kept in full, rather than as a delta from the previous revision.
+	snapshot bool
+	created  time.Time
+	// Whether the revision is a tombstone, left by Delete. A tombstone has no contents, and counts as an empty snapshot.
+	deleted bool
 }
 
+// DefaultSnapshotEvery is how often a revision is kept in full by default. The revisions in between are kept as deltas.
+const DefaultSnapshotEvery = 16
+
 type Store struct {
-	fileRevRegex *regexp.Regexp
 	// Path to the directory where revisions are stored.
 	revisionsDir string
 	mu           sync.RWMutex
-	// Map of public file paths to a map revision IDs to private file paths.
+	// Map of public file paths to their revisions, which hold the private paths of their contents.
 	// Ex:
-	//  "/test.txt" -> {"r1": "/tmp/test.txt.r1"}
+	//  "/test.txt" -> [{id: "r1", privatePath: "/.revisions/test.txt.r1.1"}]
 	revs map[string][]revision
-	fs   *inmem.FS
+	// Number of revision files written. Private paths end with it, so a file created where another was moved from doesn't overwrite the moved revisions.
+	written int
+	// Contents of the latest revision of each file, so new revisions and reads of the latest don't rebuild it from deltas.
+	latest map[string][]byte
+	fs     *inmem.FS
+	// Every snapshotEvery revisions, starting with r1, the revision is kept in full.
+	snapshotEvery int
 }
 
 type Option func(*Store)
 
 // New creates a new Store with the given options.
 func New(opts ...Option) *Store {
-	fileRevRegex := regexp.MustCompile(`\.r\d+$`)
 	s := &Store{
-		revisionsDir: "/.revisions",
-		fileRevRegex: fileRevRegex,
-		mu:           sync.RWMutex{},
-		revs:         make(map[string][]revision, 512),
-		fs:           inmem.New(),
+		revisionsDir:  "/.revisions",
+		mu:            sync.RWMutex{},
+		revs:          make(map[string][]revision, 512),
+		latest:        make(map[string][]byte, 512),
+		fs:            inmem.New(),
+		snapshotEvery: DefaultSnapshotEvery,
 	}
 	for _, o := range opts {
 		o(s)
@@ -53,10 +73,17 @@ func New(opts ...Option) *Store {
 	return s
 }
 
-// CreateRevision creates a new revision of the file at the given path. The file contains meta information about the revisions. The revisions are stores in the /.revisions directory.
+// WithSnapshotEvery keeps every nth revision in full, and the others as line-based deltas from the revision before. Rebuilding a revision applies up to n-1 deltas. An n of 1 keeps every revision in full.
+func WithSnapshotEvery(n int) Option {
+	return func(s *Store) {
+		s.snapshotEvery = max(n, 1)
+	}
+}
+
+// CreateRevision creates a new revision of the file at the given path. The revisions are stored in the /.revisions directory, either in full or as a delta from the previous revision, whichever is smaller.
 // Ex:
 //
-//	"/tmp/test.txt" -> "/tmp/test.txt
+//	"/tmp/test.txt" -> "/.revisions/tmp/test.txt.r1.1"
 func (s *Store) CreateRevision(filepath string, r io.Reader) (int64, string, error) {
 	publicPath := filepath
 	var revN int
@@ -73,91 +100,230 @@ func (s *Store) CreateRevision(filepath string, r io.Reader) (int64, string, err
 	}
 
 	revisionTag := fmt.Sprintf("r%d", revN+1)
-	revisionPath := path.Join(s.revisionsDir, filepath+"."+revisionTag)
+	s.written++
+	revisionPath := path.Join(s.revisionsDir, fmt.Sprintf("%s.%s.%d", filepath, revisionTag, s.written))
 
 	contents, err := io.ReadAll(r)
 	if err != nil {
 		return 0, "", fmt.Errorf("read contents: %w", err)
 	}
-	err = s.fs.WriteFile(revisionPath, contents, 0755)
-	if err != nil {
-		return 0, revisionTag, fmt.Errorf("io.Copy: %w", err)
+	rev := revision{
+		id:          revisionTag,
+		privatePath: revisionPath,
+		size:        int64(len(contents)),
+		snapshot:    true,
+		created:     time.Now().UTC(),
+	}
+	stored := contents
+	if revN%s.snapshotEvery != 0 {
+		if delta := encodeDelta(s.latest[publicPath], contents); len(delta) < len(contents) {
+			stored = delta
+			rev.snapshot = false
+		}
 	}
+	rev.stored = int64(len(stored))
 
-	// If the file doesn't exist, create it, or append to the file
-	f, err := s.fs.OpenFile(publicPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	err = s.fs.WriteFile(revisionPath, stored, 0755)
 	if err != nil {
-		return 0, revisionTag, fmt.Errorf("s.fs.openFile: %q %w", publicPath, err)
-	}
-	metadata := fmt.Sprintf("%s:%s\n", revisionTag, revisionPath)
-	if _, err := f.Write([]byte(metadata)); err != nil {
-		f.Close() // ignore error; Write error takes precedence
-		return 0, revisionTag, fmt.Errorf("f.Write: %w", err)
+		return 0, revisionTag, fmt.Errorf("io.Copy: %w", err)
 	}
 
-	revs = append(revs, revision{id: revisionTag, privatePath: revisionPath})
+	revs = append(revs, rev)
 	s.revs[publicPath] = revs
+	s.latest[publicPath] = contents
 
 	return int64(len(contents)), revisionTag, nil
 }
 
+// GetRevision opens a revision of the file at filepath, rebuilt from its snapshot and deltas. An empty revID opens the latest revision. A deleted file's latest revision, or any tombstone, isn't found.
 func (s *Store) GetRevision(filepath, revID string) (fs.File, error) {
+	// Reading moves the shared reader of the stored file, so rebuilding takes the write lock.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revs, ok := s.revs[filepath]
+	if !ok || len(revs) == 0 {
+		return nil, fmt.Errorf("no revisions for %s: %w", filepath, ErrNotFound)
+	}
+
+	i := len(revs) - 1
+	if revID != "" {
+		i = slices.IndexFunc(revs, func(r revision) bool { return r.id == revID })
+		if i < 0 {
+			return nil, fmt.Errorf("no revision %s for %s: %w", revID, filepath, ErrNotFound)
+		}
+	}
+
+	if revs[i].deleted {
+		return nil, fmt.Errorf("%s deleted at %s: %w", filepath, revs[i].id, ErrNotFound)
+	}
+
+	contents := s.latest[filepath]
+	if i < len(revs)-1 {
+		var err error
+		if contents, err = s.contents(revs, i); err != nil {
+			return nil, fmt.Errorf("contents: %w", err)
+		}
+	}
+	return &revisionFile{Reader: bytes.NewReader(contents), name: path.Base(filepath)}, nil
+}
+
+// Usage returns the bytes kept for all revisions of the file at filepath, and the total size of those revisions.
+func (s *Store) Usage(filepath string) (stored, size int64, err error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	revs, ok := s.revs[filepath]
 	if !ok || len(revs) == 0 {
-		return nil, fmt.Errorf("no revisions for %s", filepath)
+		return 0, 0, fmt.Errorf("no revisions for %s: %w", filepath, ErrNotFound)
 	}
+	for _, r := range revs {
+		stored += r.stored
+		size += r.size
+	}
+	return stored, size, nil
+}
 
-	if revID == "" {
-		latest := revs[len(revs)-1]
-		return s.fs.Open(latest.privatePath)
+// Contents rebuilds revs[i] from the snapshot before it, applying each delta in turn. The caller must hold the write lock.
+func (s *Store) contents(revs []revision, i int) ([]byte, error) {
+	start := i
+	for !revs[start].snapshot {
+		start--
 	}
 
-	for _, r := range revs {
-		if r.id == revID {
-			return s.fs.Open(r.privatePath)
+	var contents []byte
+	for _, r := range revs[start : i+1] {
+		if r.deleted {
+			contents = nil
+			continue
+		}
+		stored, err := s.readFile(r.privatePath)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", r.id, err)
+		}
+		if r.snapshot {
+			contents = stored
+			continue
+		}
+		if contents, err = applyDelta(contents, stored); err != nil {
+			return nil, fmt.Errorf("apply delta %s: %w", r.id, err)
 		}
 	}
+	return contents, nil
+}
 
-	return nil, fmt.Errorf("no revision %s for %s", revID, filepath)
+func (s *Store) readFile(name string) ([]byte, error) {
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
 
 // ListEntries returns a list of entries in the given path.
 // If the entry is a file, the list item will contain the file name and the latest revision ID.
 // If the entry is a directory, the list item will contain the directory name followed by a space and the string "DIR".
+// Deleted files aren't listed, nor are directories holding only deleted files.
 // Ex:
 //
 //	"/tmp" -> ["dirA/ DIR", "test.txt r2"]
-func (s *Store) ListEntries(path string) ([]string, error) {
+func (s *Store) ListEntries(dir string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entries, err := s.fs.ReadDir(path)
-	if err != nil {
-		return nil, fmt.Errorf("fs.ReadDir: %w", err)
-	}
-	res := make([]string, 0, len(entries))
-	for _, e := range entries {
-		if e.IsDir() {
-			if strings.Contains(s.revisionsDir, e.Name()) {
-				continue
-			}
-			res = append(res, fmt.Sprintf("%s/ DIR", e.Name()))
-		} else {
-			ogFileName := s.fileRevRegex.ReplaceAllString(e.Name(), "")
-			revKey := path + ogFileName
-			revs, ok := s.revs[revKey]
-			if !ok {
-				log.Printf("no revisions for %s", revKey)
-				continue
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	dirs := map[string]bool{}
+	res := []string{}
+	for p, revs := range s.revs {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok || len(revs) == 0 || revs[len(revs)-1].deleted {
+			continue
+		}
+		if name, _, isDir := strings.Cut(rest, "/"); isDir {
+			if !dirs[name] {
+				dirs[name] = true
+				res = append(res, fmt.Sprintf("%s/ DIR", name))
 			}
-			latest := revs[len(revs)-1]
-			res = append(res, fmt.Sprintf("%s %s", ogFileName, latest.id))
+			continue
 		}
+		res = append(res, fmt.Sprintf("%s %s", rest, revs[len(revs)-1].id))
 	}
 
 	slices.Sort(res)
 	return res, nil
 }
+
+// Log lists the revisions of the file at filepath, oldest first, including tombstones.
+func (s *Store) Log(filepath string) ([]history.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revs, ok := s.revs[filepath]
+	if !ok || len(revs) == 0 {
+		return nil, fmt.Errorf("no revisions for %s: %w", filepath, ErrNotFound)
+	}
+	res := make([]history.Revision, 0, len(revs))
+	for _, r := range revs {
+		res = append(res, history.Revision{ID: r.id, Size: r.size, Created: r.created, Deleted: r.deleted})
+	}
+	return res, nil
+}
+
+// Delete adds a tombstone revision to the file at filepath, and returns its ID. The earlier revisions can still be read.
+func (s *Store) Delete(filepath string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revs, ok := s.revs[filepath]
+	if !ok || len(revs) == 0 || revs[len(revs)-1].deleted {
+		return "", fmt.Errorf("no file %s: %w", filepath, ErrNotFound)
+	}
+	rev := revision{
+		id:       fmt.Sprintf("r%d", len(revs)+1),
+		snapshot: true,
+		created:  time.Now().UTC(),
+		deleted:  true,
+	}
+	s.revs[filepath] = append(revs, rev)
+	delete(s.latest, filepath)
+	return rev.id, nil
+}
+
+// Move moves the revisions of the file at src to dst, and returns the ID of the latest. The revisions' contents stay where they are. Dst must have no revisions, not even deleted ones.
+func (s *Store) Move(src, dst string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revs, ok := s.revs[src]
+	if !ok || len(revs) == 0 || revs[len(revs)-1].deleted {
+		return "", fmt.Errorf("no file %s: %w", src, ErrNotFound)
+	}
+	latest := revs[len(revs)-1].id
+	if src == dst {
+		return latest, nil
+	}
+	if len(s.revs[dst]) > 0 {
+		return "", fmt.Errorf("%s has revisions: %w", dst, ErrExists)
+	}
+
+	s.revs[dst] = revs
+	s.latest[dst] = s.latest[src]
+	delete(s.revs, src)
+	delete(s.latest, src)
+	return latest, nil
+}
+
+// RevisionFile is a revision rebuilt in memory.
+type revisionFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f *revisionFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *revisionFile) Close() error               { return nil }
+func (f *revisionFile) Name() string               { return f.name }
+func (f *revisionFile) Mode() fs.FileMode          { return 0444 }
+func (f *revisionFile) ModTime() time.Time         { return time.Time{} }
+func (f *revisionFile) IsDir() bool                { return false }
+func (f *revisionFile) Sys() any                   { return nil }
@@ -1,6 +1,13 @@
 package inmem_test
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
@@ -20,3 +27,221 @@ func TestCreateRevision(t *testing.T) {
 		require.Equal(t, "r1", rev)
 	})
 }
+
+// editHistory returns revisions of a source file of n lines, each a few line edits from the one before, like a file under development.
+func editHistory(rng *rand.Rand, n, revisions int) [][]byte {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("\tx%d := compute(%d, %q) // line %d\n", i, rng.Intn(1000), "some text", i)
+	}
+
+	history := make([][]byte, 0, revisions)
+	for r := 0; r < revisions; r++ {
+		history = append(history, []byte(strings.Join(lines, "")))
+		for e := rng.Intn(5) + 1; e > 0; e-- {
+			i := rng.Intn(len(lines))
+			switch rng.Intn(3) {
+			case 0:
+				lines[i] = fmt.Sprintf("\t// edited in revision %d\n", r+2)
+			case 1:
+				lines = slices.Insert(lines, i, fmt.Sprintf("\tlog.Println(%d)\n", rng.Intn(1000)))
+			case 2:
+				lines = slices.Delete(lines, i, i+1)
+			}
+		}
+	}
+	return history
+}
+
+func readRevision(t testing.TB, s *inmem.Store, filePath, rev string) []byte {
+	t.Helper()
+	f, err := s.GetRevision(filePath, rev)
+	require.NoError(t, err)
+	defer f.Close()
+	contents, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return contents
+}
+
+func TestDeltaRevisions(t *testing.T) {
+	history := editHistory(rand.New(rand.NewSource(1)), 500, 40)
+
+	t.Run("rebuilds every revision", func(t *testing.T) {
+		for _, every := range []int{1, 3, inmem.DefaultSnapshotEvery} {
+			store := inmem.New(inmem.WithSnapshotEvery(every))
+			for i, contents := range history {
+				_, rev, err := store.CreateRevision("/main.go", bytes.NewReader(contents))
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("r%d", i+1), rev)
+			}
+
+			for i, want := range history {
+				require.Equal(t, want, readRevision(t, store, "/main.go", fmt.Sprintf("r%d", i+1)), "every %d, r%d", every, i+1)
+			}
+			require.Equal(t, history[len(history)-1], readRevision(t, store, "/main.go", ""))
+
+			f, err := store.GetRevision("/main.go", "r1")
+			require.NoError(t, err)
+			stat, err := f.Stat()
+			require.NoError(t, err)
+			require.Equal(t, int64(len(history[0])), stat.Size())
+		}
+	})
+
+	t.Run("keeps less than full copies", func(t *testing.T) {
+		var total int64
+		for _, contents := range history {
+			total += int64(len(contents))
+		}
+
+		full := inmem.New(inmem.WithSnapshotEvery(1))
+		deltas := inmem.New()
+		for _, contents := range history {
+			_, _, err := full.CreateRevision("/main.go", bytes.NewReader(contents))
+			require.NoError(t, err)
+			_, _, err = deltas.CreateRevision("/main.go", bytes.NewReader(contents))
+			require.NoError(t, err)
+		}
+
+		stored, size, err := full.Usage("/main.go")
+		require.NoError(t, err)
+		require.Equal(t, total, size)
+		require.Equal(t, total, stored)
+
+		stored, size, err = deltas.Usage("/main.go")
+		require.NoError(t, err)
+		require.Equal(t, total, size)
+		require.Less(t, stored, total/5)
+	})
+
+	t.Run("keeps a revision in full when its delta is larger", func(t *testing.T) {
+		store := inmem.New()
+		for _, contents := range []string{"a\nb\n", "c\nd\n", ""} {
+			_, _, err := store.CreateRevision("/short.txt", strings.NewReader(contents))
+			require.NoError(t, err)
+		}
+		stored, size, err := store.Usage("/short.txt")
+		require.NoError(t, err)
+		require.Equal(t, int64(8), size)
+		require.Equal(t, int64(8), stored)
+		require.Equal(t, "c\nd\n", string(readRevision(t, store, "/short.txt", "r2")))
+		require.Empty(t, readRevision(t, store, "/short.txt", "r3"))
+	})
+}
+
+func TestDeleteAndMove(t *testing.T) {
+	t.Run("keeps the history of a deleted file", func(t *testing.T) {
+		store := inmem.New(inmem.WithSnapshotEvery(4))
+		for _, contents := range []string{"a\nb\nc\nd\n", "a\nb\nc\ne\n"} {
+			_, _, err := store.CreateRevision("/a.txt", strings.NewReader(contents))
+			require.NoError(t, err)
+		}
+		rev, err := store.Delete("/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "r3", rev)
+		_, err = store.GetRevision("/a.txt", "")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = store.GetRevision("/a.txt", "r3")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		// The revision after the tombstone has nothing to be a delta from, so it is kept in full.
+		_, rev, err = store.CreateRevision("/a.txt", strings.NewReader("f\n"))
+		require.NoError(t, err)
+		require.Equal(t, "r4", rev)
+		require.Equal(t, "a\nb\nc\ne\n", string(readRevision(t, store, "/a.txt", "r2")))
+		require.Equal(t, "f\n", string(readRevision(t, store, "/a.txt", "r4")))
+
+		revs, err := store.Log("/a.txt")
+		require.NoError(t, err)
+		ids := []string{}
+		for _, r := range revs {
+			ids = append(ids, fmt.Sprintf("%s %d %t", r.ID, r.Size, r.Deleted))
+		}
+		require.Equal(t, []string{"r1 8 false", "r2 8 false", "r3 0 true", "r4 2 false"}, ids)
+	})
+
+	t.Run("moves the history of a file", func(t *testing.T) {
+		store := inmem.New()
+		for _, contents := range []string{"a\n", "b\n"} {
+			_, _, err := store.CreateRevision("/a.txt", strings.NewReader(contents))
+			require.NoError(t, err)
+		}
+		rev, err := store.Move("/a.txt", "/x/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "r2", rev)
+
+		// A new file where the old one was keeps its revisions apart.
+		_, rev, err = store.CreateRevision("/a.txt", strings.NewReader("c\n"))
+		require.NoError(t, err)
+		require.Equal(t, "r1", rev)
+		require.Equal(t, "a\n", string(readRevision(t, store, "/x/a.txt", "r1")))
+		require.Equal(t, "b\n", string(readRevision(t, store, "/x/a.txt", "")))
+		require.Equal(t, "c\n", string(readRevision(t, store, "/a.txt", "r1")))
+
+		_, err = store.Move("/a.txt", "/x/a.txt")
+		require.ErrorIs(t, err, fs.ErrExist)
+		_, err = store.Move("/missing.txt", "/y.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		entries, err := store.ListEntries("/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a.txt r1", "x/ DIR"}, entries)
+	})
+}
+
+// BenchmarkEditHistory stores a realistic edit history, kept as full copies and as deltas. Stored-B is the bytes kept for the history, and heap-B the heap the store holds on to.
+func BenchmarkEditHistory(b *testing.B) {
+	history := editHistory(rand.New(rand.NewSource(1)), 2000, 100)
+
+	for _, bc := range []struct {
+		name  string
+		every int
+	}{
+		{name: "full copies", every: 1},
+		{name: "deltas", every: inmem.DefaultSnapshotEvery},
+	} {
+		build := func(b *testing.B) *inmem.Store {
+			store := inmem.New(inmem.WithSnapshotEvery(bc.every))
+			for _, contents := range history {
+				if _, _, err := store.CreateRevision("/main.go", bytes.NewReader(contents)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			return store
+		}
+
+		b.Run("put "+bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			store := build(b)
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			stored, _, err := store.Usage("/main.go")
+			if err != nil {
+				b.Fatal(err)
+			}
+			runtime.KeepAlive(store)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				build(b)
+			}
+			// Reported after the timer reset, which clears them.
+			b.ReportMetric(float64(stored), "stored-B")
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "heap-B")
+		})
+
+		// With deltas, r96 is the furthest revision from a snapshot, r81.
+		b.Run("get r96 "+bc.name, func(b *testing.B) {
+			store := build(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				readRevision(b, store, "/main.go", "r96")
+			}
+		})
+	}
+}
@@ -6,27 +6,56 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/diff"
+	"github.com/harveysanders/protohackers/10-voracious-code-storage/history"
 	"github.com/harveysanders/protohackers/10-voracious-code-storage/inmem"
 )
 
 const (
-	ReqTypeGet  = "GET"
-	ReqTypePut  = "PUT"
-	ReqTypeList = "LIST"
-	ReqTypeHelp = "HELP"
+	ReqTypeGet    = "GET"
+	ReqTypePut    = "PUT"
+	ReqTypeList   = "LIST"
+	ReqTypeHelp   = "HELP"
+	ReqTypeUsage  = "USAGE"
+	ReqTypeLog    = "LOG"
+	ReqTypeDiff   = "DIFF"
+	ReqTypeDelete = "DELETE"
+	ReqTypeMove   = "MOVE"
 )
 
 type (
 	Server struct {
 		listener net.Listener
-		store    inmem.Store
+		store    Store
 	}
 
+	// Store keeps the revisions of every file. Its methods may be called from many connections at once. Errors for a file, revision or directory that doesn't exist wrap fs.ErrNotExist. A deleted file doesn't exist, though its earlier revisions do.
+	Store interface {
+		// CreateRevision stores the contents of r as a new revision of the file at filePath, and returns its size and revision ID, like "r1".
+		CreateRevision(filePath string, r io.Reader) (int64, string, error)
+		// GetRevision opens a revision of the file at filePath. An empty revID opens the latest revision.
+		GetRevision(filePath, revID string) (fs.File, error)
+		// Usage returns the bytes the store keeps for every revision of the file at filePath, and the total size of those revisions.
+		Usage(filePath string) (stored, size int64, err error)
+		// ListEntries lists the files and directories in the directory at path, sorted. Files are listed as "name rN", with their latest revision, and directories as "name/ DIR".
+		ListEntries(path string) ([]string, error)
+		// Log lists the revisions of the file at filePath, oldest first, including tombstones left by Delete.
+		Log(filePath string) ([]history.Revision, error)
+		// Delete adds a tombstone revision to the file at filePath, and returns its ID.
+		Delete(filePath string) (string, error)
+		// Move moves the revisions of the file at src to dst, and returns the ID of the latest. If dst has revisions, even deleted ones, the error wraps fs.ErrExist.
+		Move(src, dst string) (string, error)
+	}
+
+	Option func(*Server)
+
 	RequestPut struct {
 		method     string // Method type, always "PUT".
 		filePath   string // "/test.txt"
@@ -45,6 +74,34 @@ type (
 		path   string // Path to directory to list. Ex: "/"
 	}
 
+	RequestUsage struct {
+		method   string // Method Type, always "USAGE".
+		filePath string // File path Ex: "/test.txt"
+	}
+
+	RequestLog struct {
+		method   string // Method Type, always "LOG".
+		filePath string // File path Ex: "/test.txt"
+	}
+
+	RequestDiff struct {
+		method   string // Method Type, always "DIFF".
+		filePath string // File path Ex: "/test.txt"
+		revA     string // Revision to diff from. Ex: "r1"
+		revB     string // Revision to diff to. Ex: "r2"
+	}
+
+	RequestDelete struct {
+		method   string // Method Type, always "DELETE".
+		filePath string // File path Ex: "/test.txt"
+	}
+
+	RequestMove struct {
+		method string // Method Type, always "MOVE".
+		src    string // File path to move from. Ex: "/test.txt"
+		dst    string // File path to move to. Ex: "/old/test.txt"
+	}
+
 	Conn struct {
 		conn net.Conn
 		s    *Server
@@ -54,9 +111,21 @@ type (
 	}
 )
 
-func New() *Server {
-	return &Server{
-		store: *inmem.New(),
+// New creates a Server. Revisions are kept in memory, unless another store is given with WithStore.
+func New(opts ...Option) *Server {
+	s := &Server{
+		store: inmem.New(),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// WithStore keeps revisions in st.
+func WithStore(st Store) Option {
+	return func(s *Server) {
+		s.store = st
 	}
 }
 
@@ -85,6 +154,33 @@ func (s *Server) Close() error {
 	return s.listener.Close()
 }
 
+// ErrResp is an error the client is told about, in an "ERR" line. The connection stays open for the next request.
+type errResp string
+
+func (e errResp) Error() string { return string(e) }
+
+const (
+	errUsagePut  errResp = "usage: PUT file length newline data"
+	errUsageGet  errResp = "usage: GET file [revision]"
+	errUsageList errResp = "usage: LIST dir"
+	errUsageUse  errResp = "usage: USAGE file"
+	errUsageLog  errResp = "usage: LOG file"
+	errUsageDiff errResp = "usage: DIFF file revision revision"
+	errUsageDel  errResp = "usage: DELETE file"
+	errUsageMove errResp = "usage: MOVE file file"
+
+	errIllegalFileName errResp = "illegal file name"
+	errIllegalDirName  errResp = "illegal dir name"
+	errNoSuchFile      errResp = "no such file"
+	errNoSuchRevision  errResp = "no such revision"
+	errTextFilesOnly   errResp = "text files only"
+	errFileExists      errResp = "file exists"
+	errFileTooLarge    errResp = "file too large"
+)
+
+// MaxFileSize is the most bytes a PUT may store. The contents of a PUT are held in memory until they're checked.
+const maxFileSize = 1 << 20
+
 func (s *Server) handleConnection(nc net.Conn, id int) {
 	c := &Conn{
 		id:   id,
@@ -108,8 +204,10 @@ func (s *Server) handleConnection(nc net.Conn, id int) {
 
 	for {
 		line, err := c.rdr.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			log.Printf("[%d] rdr.ReadBytes: %v", c.id, err)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("[%d] rdr.ReadBytes: %v", c.id, err)
+			}
 			return
 		}
 
@@ -121,17 +219,45 @@ func (s *Server) handleConnection(nc net.Conn, id int) {
 		reqType := string(fields[0])
 		switch reqType {
 		case ReqTypeHelp:
-			c.handleHelp()
+			err = c.handleHelp()
 		case ReqTypePut:
-			c.handlePut(line)
+			err = c.handlePut(line)
 		case ReqTypeGet:
-			c.handleGet(line)
+			err = c.handleGet(line)
 		case ReqTypeList:
-			c.handleList(line)
+			err = c.handleList(line)
+		case ReqTypeUsage:
+			err = c.handleUsage(line)
+		case ReqTypeLog:
+			err = c.handleLog(line)
+		case ReqTypeDiff:
+			err = c.handleDiff(line)
+		case ReqTypeDelete:
+			err = c.handleDelete(line)
+		case ReqTypeMove:
+			err = c.handleMove(line)
 		default:
-			if _, err := c.w.WriteString("ERROR unknown command\n"); err != nil {
+			// There's no telling what follows an unknown method, so the connection is closed.
+			if _, err := fmt.Fprintf(c.w, "ERR illegal method: %s\n", reqType); err != nil {
 				log.Printf("[%d] write: %v", c.id, err)
+				return
 			}
+			if err := c.w.Flush(); err != nil {
+				log.Printf("[%d] Flush: %v", c.id, err)
+			}
+			return
+		}
+
+		var resp errResp
+		if errors.As(err, &resp) {
+			err = nil
+			if _, wErr := fmt.Fprintf(c.w, "ERR %s\n", resp); wErr != nil {
+				err = wErr
+			}
+		}
+		if err != nil {
+			log.Printf("[%d] %s: %v", c.id, reqType, err)
+			return
 		}
 
 		// Write "READY" message after handling each request
@@ -146,54 +272,107 @@ func (s *Server) handleConnection(nc net.Conn, id int) {
 	}
 }
 
+// ValidPath reports whether p is an absolute path of letters, digits and "._-", without empty path elements. A trailing "/" is allowed.
+func validPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.Contains(p, "//") {
+		return false
+	}
+	for _, r := range p {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune("/._-", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidFileName reports whether name is a valid path to a file.
+func validFileName(name string) bool {
+	return validPath(name) && !strings.HasSuffix(name, "/")
+}
+
+// IsText reports whether data is printable ASCII, tabs and line breaks.
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7e) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseRevision returns the revision ID of a revision number, like "r2" or "2".
+func parseRevision(rev string) (string, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(rev, "r"), 10, 64)
+	if err != nil || n == 0 {
+		return "", errNoSuchRevision
+	}
+	return fmt.Sprintf("r%d", n), nil
+}
+
 func (m *RequestPut) unmarshal(line []byte) error {
 	fields := bytes.Fields(line)
+	if len(fields) != 3 {
+		return errUsagePut
+	}
 
 	m.method = string(fields[0])
 	m.filePath = string(fields[1])
 	contentLen, err := strconv.Atoi(string(fields[2]))
-	if err != nil {
-		return fmt.Errorf("atoi: %w", err)
+	if err != nil || contentLen < 0 {
+		return errUsagePut
 	}
 	m.contentLen = contentLen
 	return nil
 }
 
-func (c *Conn) handlePut(line []byte) {
+func (c *Conn) handlePut(line []byte) error {
 	var req RequestPut
-	err := req.unmarshal(line)
-	if err != nil {
-		log.Printf("[%d] parseMeta: %v", c.id, err)
-		return
+	if err := req.unmarshal(line); err != nil {
+		return err
 	}
 
-	req.contents = make([]byte, 0, req.contentLen)
-	for bytesRead := 0; bytesRead < req.contentLen; {
-		line, err := c.rdr.ReadBytes('\n')
-		if err != nil {
-			log.Printf("[%d] rdr.ReadBytes: %v", c.id, err)
-			return
+	// Skip the contents of a refused PUT, so they aren't taken for the next request.
+	if req.contentLen > maxFileSize || !validFileName(req.filePath) {
+		if _, err := io.CopyN(io.Discard, c.rdr, int64(req.contentLen)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("skip contents: %w", err)
+		}
+		if req.contentLen > maxFileSize {
+			return errFileTooLarge
 		}
-		req.contents = append(req.contents, line...)
-		bytesRead += len(line)
+		return errIllegalFileName
 	}
 
-	_, rev, err := c.s.store.CreateRevision(req.filePath, bytes.NewReader(req.contents))
+	contents, err := io.ReadAll(io.LimitReader(c.rdr, int64(req.contentLen)))
 	if err != nil {
-		log.Printf("[%d] CreateRevision: %v", c.id, err)
-		return
+		return fmt.Errorf("read contents: %w", err)
+	}
+	if len(contents) < req.contentLen {
+		return fmt.Errorf("read contents: %w", io.ErrUnexpectedEOF)
+	}
+	req.contents = contents
+
+	if !isText(req.contents) {
+		return errTextFilesOnly
 	}
-	_, err = c.conn.Write([]byte(fmt.Sprintf("OK %s\n", rev)))
+
+	_, rev, err := c.s.store.CreateRevision(req.filePath, bytes.NewReader(req.contents))
 	if err != nil {
-		log.Printf("[%d] write: %v", c.id, err)
-		return
+		return fmt.Errorf("CreateRevision: %w", err)
 	}
+	_, err = fmt.Fprintf(c.w, "OK %s\n", rev)
+	return err
 }
 
 func (r *RequestGet) unmarshal(line []byte) error {
 	fields := bytes.Fields(line)
-	if len(fields) < 2 {
-		return fmt.Errorf("invalid request: %s", line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return errUsageGet
 	}
 	r.method = string(fields[0])
 	r.filePath = string(fields[1])
@@ -205,82 +384,302 @@ func (r *RequestGet) unmarshal(line []byte) error {
 
 func (r *RequestList) unmarshal(line []byte) error {
 	fields := bytes.Fields(line)
-	if len(fields) < 2 {
-		return fmt.Errorf("invalid request: %s", line)
+	if len(fields) != 2 {
+		return errUsageList
 	}
 	r.method = string(fields[0])
 	r.path = string(fields[1])
 	return nil
 }
 
-func (c *Conn) handleGet(line []byte) {
+func (c *Conn) handleGet(line []byte) error {
 	var req RequestGet
 	if err := req.unmarshal(line); err != nil {
-		log.Printf("[%d] unmarshal: %v", c.id, err)
-		return
+		return err
+	}
+	if !validFileName(req.filePath) {
+		return errIllegalFileName
+	}
+	revID := ""
+	if req.rev != "" {
+		var err error
+		if revID, err = parseRevision(req.rev); err != nil {
+			return err
+		}
 	}
 
-	file, err := c.s.store.GetRevision(req.filePath, req.rev)
+	file, err := c.getRevision(req.filePath, revID)
 	if err != nil {
-		log.Printf("[%d] GetRevision: %v", c.id, err)
-		return
+		return err
 	}
 	defer func() {
 		_ = file.Close()
 	}()
 	stat, err := file.Stat()
 	if err != nil {
-		log.Printf("[%d] Stat: %v", c.id, err)
-		return
+		return fmt.Errorf("Stat: %w", err)
 	}
-	okMsg := fmt.Sprintf("OK %d\n", stat.Size())
-	_, err = c.w.WriteString(okMsg)
-	if err != nil {
-		log.Printf("[%d] WriteString: %v", c.id, err)
-		return
+	if _, err := fmt.Fprintf(c.w, "OK %d\n", stat.Size()); err != nil {
+		return err
 	}
 	if _, err := io.Copy(c.w, file); err != nil {
-		log.Printf("[%d] io.Copy: %v", c.id, err)
-		return
+		return fmt.Errorf("io.Copy: %w", err)
 	}
-	if err = c.w.Flush(); err != nil {
-		log.Printf("[%d] Flush: %v", c.id, err)
-		return
+	return nil
+}
+
+// GetRevision opens a revision of the file at filePath, telling a missing file from a missing revision. An empty revID opens the latest revision, which is missing if the file was deleted.
+func (c *Conn) getRevision(filePath, revID string) (fs.File, error) {
+	file, err := c.s.store.GetRevision(filePath, revID)
+	if err == nil {
+		return file, nil
 	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("GetRevision: %w", err)
+	}
+	if revID == "" {
+		return nil, errNoSuchFile
+	}
+	if _, err := c.s.store.Log(filePath); err != nil {
+		return nil, errNoSuchFile
+	}
+	return nil, errNoSuchRevision
 }
 
-func (c *Conn) handleList(line []byte) {
+func (c *Conn) handleList(line []byte) error {
 	var req RequestList
 	if err := req.unmarshal(line); err != nil {
-		log.Printf("[%d] unmarshal: %v", c.id, err)
-		return
+		return err
+	}
+	if !validPath(req.path) {
+		return errIllegalDirName
 	}
 
-	entries, err := c.s.store.ListEntries(req.path)
-	if err != nil {
-		log.Printf("[%d] ListEntries: %v", c.id, err)
-		return
+	// A directory only exists while it holds files, so listing any other is an empty list.
+	entries, err := c.s.store.ListEntries(strings.TrimSuffix(req.path, "/") + "/")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("ListEntries: %w", err)
 	}
-	ackLine := fmt.Sprintf("OK %d\n", len(entries))
-	if _, err := c.w.WriteString(ackLine); err != nil {
-		log.Printf("[%d] WriteString: %s %v", c.id, ackLine, err)
-		return
+	if _, err := fmt.Fprintf(c.w, "OK %d\n", len(entries)); err != nil {
+		return err
 	}
 	for _, e := range entries {
 		if _, err := c.w.WriteString(e + "\n"); err != nil {
-			log.Printf("[%d] WriteString: %v", c.id, err)
-			return
+			return err
 		}
 	}
+	return nil
 }
 
-func (c *Conn) handleHelp() {
-	methods := strings.Join(
-		[]string{ReqTypePut, ReqTypeGet, ReqTypeList, ReqTypeHelp},
-		"|")
-	_, err := c.conn.Write([]byte(fmt.Sprintf("OK usage: %s\n", methods)))
+func (r *RequestUsage) unmarshal(line []byte) error {
+	fields := bytes.Fields(line)
+	if len(fields) != 2 {
+		return errUsageUse
+	}
+	r.method = string(fields[0])
+	r.filePath = string(fields[1])
+	return nil
+}
+
+// HandleUsage responds with the bytes stored for every revision of a file, then the total size of the revisions.
+// Ex:
+//
+//	"USAGE /test.txt" -> "OK 120 1400"
+func (c *Conn) handleUsage(line []byte) error {
+	var req RequestUsage
+	if err := req.unmarshal(line); err != nil {
+		return err
+	}
+	if !validFileName(req.filePath) {
+		return errIllegalFileName
+	}
+
+	stored, size, err := c.s.store.Usage(req.filePath)
 	if err != nil {
-		log.Printf("[%d] write: %v", c.id, err)
-		return
+		if errors.Is(err, fs.ErrNotExist) {
+			return errNoSuchFile
+		}
+		return fmt.Errorf("Usage: %w", err)
+	}
+	_, err = fmt.Fprintf(c.w, "OK %d %d\n", stored, size)
+	return err
+}
+
+func (r *RequestLog) unmarshal(line []byte) error {
+	fields := bytes.Fields(line)
+	if len(fields) != 2 {
+		return errUsageLog
+	}
+	r.method = string(fields[0])
+	r.filePath = string(fields[1])
+	return nil
+}
+
+// HandleLog responds with the number of revisions of a file, then a line for each, oldest first, with its size and when it was created. A tombstone left by DELETE is marked "DELETED".
+// Ex:
+//
+//	"LOG /test.txt" -> "OK 2", "r1 14 2023-01-19T12:00:00Z", "r2 0 2023-01-19T12:05:00Z DELETED"
+func (c *Conn) handleLog(line []byte) error {
+	var req RequestLog
+	if err := req.unmarshal(line); err != nil {
+		return err
+	}
+	if !validFileName(req.filePath) {
+		return errIllegalFileName
+	}
+
+	revs, err := c.s.store.Log(req.filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return errNoSuchFile
+		}
+		return fmt.Errorf("Log: %w", err)
+	}
+	if _, err := fmt.Fprintf(c.w, "OK %d\n", len(revs)); err != nil {
+		return err
+	}
+	for _, r := range revs {
+		deleted := ""
+		if r.Deleted {
+			deleted = " DELETED"
+		}
+		if _, err := fmt.Fprintf(c.w, "%s %d %s%s\n", r.ID, r.Size, r.Created.UTC().Format(time.RFC3339), deleted); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (r *RequestDiff) unmarshal(line []byte) error {
+	fields := bytes.Fields(line)
+	if len(fields) != 4 {
+		return errUsageDiff
+	}
+	r.method = string(fields[0])
+	r.filePath = string(fields[1])
+	r.revA = string(fields[2])
+	r.revB = string(fields[3])
+	return nil
+}
+
+// HandleDiff responds with the length of the unified diff between two revisions of a file, then the diff. The diff is empty if the revisions are the same.
+// Ex:
+//
+//	"DIFF /test.txt r1 r2" -> "OK 59", "--- /test.txt r1", "+++ /test.txt r2", "@@ -1 +1 @@", "-hello", "+hola"
+func (c *Conn) handleDiff(line []byte) error {
+	var req RequestDiff
+	if err := req.unmarshal(line); err != nil {
+		return err
+	}
+	if !validFileName(req.filePath) {
+		return errIllegalFileName
+	}
+
+	var texts [2]string
+	var ids [2]string
+	for i, rev := range []string{req.revA, req.revB} {
+		id, err := parseRevision(rev)
+		if err != nil {
+			return err
+		}
+		file, err := c.getRevision(req.filePath, id)
+		if err != nil {
+			return err
+		}
+		contents, err := io.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", id, err)
+		}
+		texts[i], ids[i] = string(contents), id
+	}
+
+	d := diff.Unified(
+		req.filePath+" "+ids[0], req.filePath+" "+ids[1],
+		diff.SplitLines(texts[0]), diff.SplitLines(texts[1]))
+	_, err := fmt.Fprintf(c.w, "OK %d\n%s", len(d), d)
+	return err
+}
+
+func (r *RequestDelete) unmarshal(line []byte) error {
+	fields := bytes.Fields(line)
+	if len(fields) != 2 {
+		return errUsageDel
+	}
+	r.method = string(fields[0])
+	r.filePath = string(fields[1])
+	return nil
+}
+
+// HandleDelete deletes a file, leaving a tombstone revision, and responds with its revision ID. The file's history can still be read with LOG, GET and DIFF, and a PUT brings the file back.
+// Ex:
+//
+//	"DELETE /test.txt" -> "OK r3"
+func (c *Conn) handleDelete(line []byte) error {
+	var req RequestDelete
+	if err := req.unmarshal(line); err != nil {
+		return err
+	}
+	if !validFileName(req.filePath) {
+		return errIllegalFileName
+	}
+
+	rev, err := c.s.store.Delete(req.filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return errNoSuchFile
+		}
+		return fmt.Errorf("Delete: %w", err)
+	}
+	_, err = fmt.Fprintf(c.w, "OK %s\n", rev)
+	return err
+}
+
+func (r *RequestMove) unmarshal(line []byte) error {
+	fields := bytes.Fields(line)
+	if len(fields) != 3 {
+		return errUsageMove
+	}
+	r.method = string(fields[0])
+	r.src = string(fields[1])
+	r.dst = string(fields[2])
+	return nil
+}
+
+// HandleMove moves a file, with all its revisions, to a path that has never held a file, and responds with its latest revision ID.
+// Ex:
+//
+//	"MOVE /test.txt /old/test.txt" -> "OK r2"
+func (c *Conn) handleMove(line []byte) error {
+	var req RequestMove
+	if err := req.unmarshal(line); err != nil {
+		return err
+	}
+	if !validFileName(req.src) || !validFileName(req.dst) {
+		return errIllegalFileName
+	}
+
+	rev, err := c.s.store.Move(req.src, req.dst)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return errNoSuchFile
+		case errors.Is(err, fs.ErrExist):
+			return errFileExists
+		}
+		return fmt.Errorf("Move: %w", err)
+	}
+	_, err = fmt.Fprintf(c.w, "OK %s\n", rev)
+	return err
+}
+
+func (c *Conn) handleHelp() error {
+	methods := strings.Join(
+		[]string{
+			ReqTypePut, ReqTypeGet, ReqTypeList, ReqTypeUsage,
+			ReqTypeLog, ReqTypeDiff, ReqTypeDelete, ReqTypeMove, ReqTypeHelp,
+		},
+		"|")
+	_, err := fmt.Fprintf(c.w, "OK usage: %s\n", methods)
+	return err
 }
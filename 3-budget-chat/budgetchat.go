@@ -1,6 +1,7 @@
 package budgetchat
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -11,20 +12,26 @@ import (
 	"net/textproto"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 type (
 	Server struct {
 		listener net.Listener
 		hub      *hub
+		hubOnce  sync.Once
 	}
 
 	client struct {
 		joined bool
 		name   string
 		conn   net.Conn
-		send   chan []byte
-		hub    *hub
+		// Reads lines from conn. Shared by every read so buffered input isn't lost.
+		lines *textproto.Reader
+		send  chan []byte
+		hub   *hub
+		// Closed once the client has left.
+		done chan struct{}
 	}
 
 	ctxKey string
@@ -37,13 +44,15 @@ var (
 
 const CONNECTION_ID ctxKey = "CONNECTION_ID"
 
+// HandleConnection joins the client to the chat once it has a valid name, and returns when the client leaves. The connection is closed by then.
 func (s *Server) HandleConnection(ctx context.Context, conn net.Conn) error {
+	s.runHub()
 	if _, err := conn.Write([]byte("New chat server. Who dis?\n")); err != nil {
 		return err
 	}
 
-	c := textproto.NewConn(conn)
-	rawName, err := c.ReadLineBytes()
+	lines := textproto.NewReader(bufio.NewReader(conn))
+	rawName, err := lines.ReadLineBytes()
 	if err != nil {
 		return err
 	}
@@ -64,8 +73,8 @@ func (s *Server) HandleConnection(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
-	newClient(string(rawName), conn, s.hub)
-
+	c := newClient(string(rawName), conn, lines, s.hub)
+	<-c.done
 	return nil
 }
 
@@ -81,13 +90,15 @@ func ValidateName(name []byte) error {
 	return nil
 }
 
-func newClient(name string, conn net.Conn, hub *hub) *client {
+func newClient(name string, conn net.Conn, lines *textproto.Reader, hub *hub) *client {
 	c := &client{
 		name:   name,
 		joined: true,
 		conn:   conn,
+		lines:  lines,
 		send:   make(chan []byte, 1024),
 		hub:    hub,
+		done:   make(chan struct{}),
 	}
 
 	c.hub.join <- c
@@ -103,11 +114,11 @@ func (c *client) readPump() {
 	defer func() {
 		c.hub.leave <- c
 		c.conn.Close()
+		close(c.done)
 	}()
 
 	for {
-		conn := textproto.NewConn(c.conn)
-		msg, err := conn.ReadLineBytes()
+		msg, err := c.lines.ReadLineBytes()
 		if err != nil {
 			if err == io.EOF {
 				log.Printf("*** EOF ***")
@@ -147,8 +158,13 @@ func NewServer() *Server {
 	}
 }
 
+// RunHub starts the chat room, the first time it is called.
+func (s *Server) runHub() {
+	s.hubOnce.Do(func() { go s.hub.run() })
+}
+
 func (s *Server) Start(port string) error {
-	go s.hub.run()
+	s.runHub()
 
 	l, err := net.Listen("tcp", ":"+port)
 	s.listener = l
@@ -0,0 +1,113 @@
+package udb
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// DefaultControlPrefix marks a packet as a control query. A NUL byte is used so control queries do not clash with keys sent by regular clients.
+const DefaultControlPrefix = "\x00"
+
+// All requests and responses must be shorter than 1000 bytes.
+const maxMessageLen = 999
+
+// Control query commands. A control query is the control prefix followed by one of the commands below.
+//
+//	list [<offset> [<key prefix>]]	-> "<query>=<next offset>\n<key>\n<key>..."
+//	del <key>			-> "<query>=1" if the key existed, "<query>=0" otherwise
+//	stats				-> "<query>=keys=<count> bytes=<total bytes>"
+//
+// List responses are paginated to fit in a single packet. The next offset is empty on the last page. Keys too long to fit in a response are skipped. A list query that leaves no room for keys gets an error, cut short to fit.
+const (
+	cmdList  = "list"
+	cmdDel   = "del"
+	cmdStats = "stats"
+)
+
+func (s *server) isControl(msg []byte) bool {
+	return len(s.controlPrefix) > 0 && bytes.HasPrefix(msg, s.controlPrefix)
+}
+
+// handleControl runs the control query in msg and returns the response. A nil response means nothing should be sent back.
+func (s *server) handleControl(msg []byte) []byte {
+	// Inserts to keys with the control prefix are ignored, like attempts to modify the version.
+	if IsInsert(msg) {
+		return nil
+	}
+	cmd, args, _ := bytes.Cut(msg[len(s.controlPrefix):], []byte(" "))
+	switch string(cmd) {
+	case cmdList:
+		offsetArg, prefix, _ := bytes.Cut(args, []byte(" "))
+		offset := 0
+		if len(offsetArg) > 0 {
+			n, err := strconv.Atoi(string(offsetArg))
+			if err != nil || n < 0 {
+				return s.reply(msg, []byte("error: invalid offset"))
+			}
+			offset = n
+		}
+		return s.handleList(msg, prefix, offset)
+	case cmdDel:
+		if s.store.Delete(args) {
+			return s.reply(msg, []byte("1"))
+		}
+		return s.reply(msg, []byte("0"))
+	case cmdStats:
+		stats := s.store.Stats()
+		return s.reply(msg, []byte("keys="+strconv.Itoa(stats.Keys)+" bytes="+strconv.Itoa(stats.Bytes)))
+	default:
+		return s.reply(msg, []byte("error: unknown command"))
+	}
+}
+
+// handleList responds with as many keys starting with prefix, beginning at offset, as fit in a single packet. Keys that could never fit are skipped, so a page may list none of them but still move the offset on.
+func (s *server) handleList(msg []byte, prefix []byte, offset int) []byte {
+	keys := s.store.Keys(prefix)
+	offset = min(offset, len(keys))
+
+	// Reserve room for the largest possible next offset so the header length is known before packing keys.
+	budget := s.maxResponseLen() - len(msg) - len("=") - len(strconv.Itoa(len(keys)))
+	if budget <= 0 {
+		return s.reply(msg, []byte("error: query too long"))
+	}
+
+	page := make([]byte, 0, budget)
+	next := offset
+	for _, k := range keys[offset:] {
+		if len("\n")+len(k) > budget {
+			// Sending part of the key would name a key that doesn't exist.
+			next++
+			continue
+		}
+		if len(page)+len("\n")+len(k) > budget {
+			break
+		}
+		page = append(page, '\n')
+		page = append(page, k...)
+		next++
+	}
+
+	var resp []byte
+	resp = append(resp, msg...)
+	resp = append(resp, '=')
+	if next < len(keys) {
+		resp = strconv.AppendInt(resp, int64(next), 10)
+	}
+	return append(resp, page...)
+}
+
+// reply builds a "<query>=<value>" response, truncated to the maximum response length.
+func (s *server) reply(query []byte, value []byte) []byte {
+	resp := make([]byte, 0, len(query)+1+len(value))
+	resp = append(resp, query...)
+	resp = append(resp, '=')
+	resp = append(resp, value...)
+	if limit := s.maxResponseLen(); len(resp) > limit {
+		return resp[:limit]
+	}
+	return resp
+}
+
+func (s *server) maxResponseLen() int {
+	return min(s.maxBufferSize, maxMessageLen)
+}
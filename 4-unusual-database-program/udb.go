@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"net"
 	"os"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,14 +23,39 @@ type (
 		maxBufferSize int
 		store         store
 		version       string
+		// Prefix that marks a packet as a control query instead of a regular insert or retrieve.
+		controlPrefix []byte
+		// Number of packet handling goroutines.
+		workers int
+		// Number of packets each worker can have waiting before reads block.
+		queueSize    int
+		packetErrors atomic.Int64
 	}
 
+	packet struct {
+		from net.Addr
+		msg  []byte
+	}
+
+	Option func(*server)
+
 	store interface {
 		Insert(key []byte, value []byte)
 		Retrieve(key []byte) (value []byte, ok bool)
+		// Delete removes the key from the store and reports whether it existed.
+		Delete(key []byte) (ok bool)
+		// Keys returns all keys starting with prefix, in ascending order.
+		Keys(prefix []byte) [][]byte
+		// Stats returns the number of keys and the total bytes of all keys and values.
+		Stats() storeStats
 		fmt.Stringer
 	}
 
+	storeStats struct {
+		Keys  int
+		Bytes int
+	}
+
 	storeSyncMap struct {
 		store sync.Map
 	}
@@ -37,18 +66,44 @@ type (
 	}
 )
 
-func NewServer(store store) *server {
+func NewServer(store store, opts ...Option) *server {
 	version := "alpha"
 	if UDB_VERSION := os.Getenv("UDB_VERSION"); UDB_VERSION != "" {
 		version = UDB_VERSION
 	}
 
-	return &server{
+	controlPrefix := DefaultControlPrefix
+	if UDB_CONTROL_PREFIX, ok := os.LookupEnv("UDB_CONTROL_PREFIX"); ok {
+		controlPrefix = UDB_CONTROL_PREFIX
+	}
+
+	s := &server{
 		readTimeout:   time.Second * 10,
 		writeTimeout:  time.Second * 10,
 		maxBufferSize: 1024,
 		store:         store,
 		version:       version,
+		controlPrefix: []byte(controlPrefix),
+		workers:       runtime.NumCPU(),
+		queueSize:     128,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// WithControlPrefix sets the prefix that marks a packet as a control query. An empty prefix disables control queries.
+func WithControlPrefix(prefix string) Option {
+	return func(s *server) {
+		s.controlPrefix = []byte(prefix)
+	}
+}
+
+// WithWorkers sets the number of goroutines handling packets.
+func WithWorkers(n int) Option {
+	return func(s *server) {
+		s.workers = max(n, 1)
 	}
 }
 
@@ -57,58 +112,108 @@ func (s *server) ServeUDP(ctx context.Context, address string) error {
 	if err != nil {
 		return fmt.Errorf("listenPacket: %w", err)
 	}
+	return s.Serve(ctx, pConn)
+}
+
+// Serve reads packets from pConn and handles them on a pool of workers until ctx is cancelled or reading fails. Packets are routed to workers by key, so requests for the same key are handled in arrival order. Errors handling a single packet are logged and counted, but do not stop the server.
+func (s *server) Serve(ctx context.Context, pConn net.PacketConn) error {
 	defer pConn.Close()
 
-	done := make(chan error, 1)
+	queues := make([]chan packet, s.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan packet, s.queueSize)
+		wg.Add(1)
+		go func(q <-chan packet) {
+			defer wg.Done()
+			for p := range q {
+				if err := s.handlePacket(pConn, p); err != nil {
+					s.packetErrors.Add(1)
+					log.Printf("handlePacket from %s: %v", p.from, err)
+				}
+			}
+		}(queues[i])
+	}
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+		wg.Wait()
+	}()
 
+	stop := make(chan struct{})
+	defer close(stop)
 	go func() {
-		buffer := make([]byte, s.maxBufferSize)
-		for {
-			n, fromAddr, err := pConn.ReadFrom(buffer)
-			if err != nil {
-				done <- fmt.Errorf("readFrom: %w", err)
-				return
+		select {
+		case <-ctx.Done():
+			// Unblock ReadFrom.
+			pConn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	buffer := make([]byte, s.maxBufferSize)
+	for {
+		n, fromAddr, err := pConn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("cancelled with err: %v", ctx.Err())
+				return nil
 			}
+			return fmt.Errorf("readFrom: %w", err)
+		}
 
-			// Copy contents to new slice so we don't reference the data in the ever-changing buffer.
-			msg := make([]byte, n)
-			copy(msg, buffer[:n])
+		// Copy contents to new slice so we don't reference the data in the ever-changing buffer.
+		msg := make([]byte, n)
+		copy(msg, buffer[:n])
 
-			if IsInsert(msg) {
-				// log.Printf("** INSERT **\nfrom: %s\ncontents: %s\n*************\n", fromAddr.String(), msg)
-				if err := s.handleInsert(msg); err != nil {
-					done <- fmt.Errorf("handleInsert: %w", err)
-					return
-				}
-				continue
-			}
-			// Assume it's a retrieve request
-			// log.Printf("** QUERY **\nfrom: %s\ncontents: %s\n*************\n", fromAddr.String(), msg)
-			resp := s.handleQuery(msg)
-
-			err = pConn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
-			if err != nil {
-				done <- err
-				return
-			}
+		h := fnv.New32a()
+		h.Write(s.packetKey(msg))
+		queues[h.Sum32()%uint32(len(queues))] <- packet{from: fromAddr, msg: msg}
+	}
+}
 
-			_, err = pConn.WriteTo(resp, fromAddr)
-			if err != nil {
-				done <- err
-				return
-			}
-			// log.Printf("STORE: %s\n", s.store.String())
-			// log.Printf("** RESPONSE SENT **\nto: %s\ncontents: %s\n*************\n", fromAddr.String(), resp)
+// PacketErrors returns the number of packets that could not be handled.
+func (s *server) PacketErrors() int64 {
+	return s.packetErrors.Load()
+}
+
+// packetKey returns the key a request operates on.
+func (s *server) packetKey(msg []byte) []byte {
+	if s.isControl(msg) {
+		// Deletes must be ordered with inserts to the same key.
+		if cmd, key, _ := bytes.Cut(msg[len(s.controlPrefix):], []byte(" ")); string(cmd) == cmdDel {
+			return key
 		}
-	}()
+		return msg
+	}
+	key, _, _ := bytes.Cut(msg, []byte("="))
+	return key
+}
 
-	select {
-	case <-ctx.Done():
-		log.Printf("cancelled with err: %v", ctx.Err())
-	case err = <-done:
-		log.Printf("err: %v", err)
+func (s *server) handlePacket(pConn net.PacketConn, p packet) error {
+	var resp []byte
+	switch {
+	case s.isControl(p.msg):
+		resp = s.handleControl(p.msg)
+	case IsInsert(p.msg):
+		if err := s.handleInsert(p.msg); err != nil {
+			return fmt.Errorf("handleInsert: %w", err)
+		}
+	default:
+		// Assume it's a retrieve request
+		resp = s.handleQuery(p.msg)
+	}
+	if resp == nil {
+		return nil
 	}
 
+	if err := pConn.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err != nil {
+		return fmt.Errorf("setWriteDeadline: %w", err)
+	}
+	if _, err := pConn.WriteTo(resp, p.from); err != nil {
+		return fmt.Errorf("writeTo: %w", err)
+	}
 	return nil
 }
 
@@ -165,6 +270,33 @@ func (s *storeSyncMap) Retrieve(k []byte) (value []byte, ok bool) {
 	return bv, true
 }
 
+func (s *storeSyncMap) Delete(k []byte) (ok bool) {
+	_, ok = s.store.LoadAndDelete(string(k))
+	return ok
+}
+
+func (s *storeSyncMap) Keys(prefix []byte) [][]byte {
+	keys := make([][]byte, 0)
+	s.store.Range(func(key, _ any) bool {
+		if k := key.(string); strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, []byte(k))
+		}
+		return true
+	})
+	slices.SortFunc(keys, bytes.Compare)
+	return keys
+}
+
+func (s *storeSyncMap) Stats() storeStats {
+	var stats storeStats
+	s.store.Range(func(key, value any) bool {
+		stats.Keys++
+		stats.Bytes += len(key.(string)) + len(value.([]byte))
+		return true
+	})
+	return stats
+}
+
 func (s *storeSyncMap) String() string {
 	var res strings.Builder
 	s.store.Range(func(key, value any) bool {
@@ -199,7 +331,40 @@ func (s *storeMap) Retrieve(k []byte) (value []byte, ok bool) {
 	return v, ok
 }
 
+func (s *storeMap) Delete(k []byte) (ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok = s.store[string(k)]
+	delete(s.store, string(k))
+	return ok
+}
+
+func (s *storeMap) Keys(prefix []byte) [][]byte {
+	s.mu.Lock()
+	keys := make([][]byte, 0)
+	for k := range s.store {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, []byte(k))
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(keys, bytes.Compare)
+	return keys
+}
+
+func (s *storeMap) Stats() storeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := storeStats{Keys: len(s.store)}
+	for k, v := range s.store {
+		stats.Bytes += len(k) + len(v)
+	}
+	return stats
+}
+
 func (s *storeMap) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var str strings.Builder
 	for k, v := range s.store {
 		str.WriteString(k + "=")
@@ -2,8 +2,10 @@ package udb_test
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -142,3 +144,181 @@ func TestIsInsert(t *testing.T) {
 		})
 	}
 }
+
+func TestControlQueries(t *testing.T) {
+	remoteAddr := "localhost:9012"
+	raddr, err := net.ResolveUDPAddr("udp", remoteAddr)
+	require.NoError(t, err)
+
+	store := udb.NewStoreSyncMap()
+	srv := udb.NewServer(store, udb.WithControlPrefix("!"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.ServeUDP(ctx, remoteAddr)
+	time.Sleep(time.Second / 2)
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	query := func(t *testing.T, q string) string {
+		_, err := conn.Write([]byte(q))
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		res := make([]byte, 1024)
+		n, err := conn.Read(res)
+		require.NoError(t, err)
+		require.Less(t, n, 1000)
+		return string(res[:n])
+	}
+
+	for i := 0; i < 200; i++ {
+		_, err := conn.Write([]byte(fmt.Sprintf("user.%03d=%s", i, strings.Repeat("x", 10))))
+		require.NoError(t, err)
+	}
+	_, err = conn.Write([]byte("other=value"))
+	require.NoError(t, err)
+	// Inserts with the control prefix are ignored.
+	_, err = conn.Write([]byte("!stats=bogus"))
+	require.NoError(t, err)
+	time.Sleep(time.Second / 4)
+
+	t.Run("stats", func(t *testing.T) {
+		// 200 * len("user.000" + "xxxxxxxxxx") + len("other" + "value")
+		require.Equal(t, "!stats=keys=201 bytes=3610", query(t, "!stats"))
+	})
+
+	t.Run("list paginates by prefix", func(t *testing.T) {
+		got := make([]string, 0, 200)
+		offset := ""
+		pages := 0
+		for {
+			q := "!list " + offset + " user."
+			resp := query(t, q)
+			require.True(t, strings.HasPrefix(resp, q+"="), resp)
+			lines := strings.Split(strings.TrimPrefix(resp, q+"="), "\n")
+			got = append(got, lines[1:]...)
+			pages++
+			if offset = lines[0]; offset == "" {
+				break
+			}
+		}
+		require.Greater(t, pages, 1)
+		require.Len(t, got, 200)
+		require.Equal(t, "user.000", got[0])
+		require.Equal(t, "user.199", got[199])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.Equal(t, "!del other=1", query(t, "!del other"))
+		require.Equal(t, "!del other=0", query(t, "!del other"))
+		require.Equal(t, "other=", query(t, "other"))
+		require.Equal(t, "!list 0 other=", query(t, "!list 0 other"))
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		require.Equal(t, "!nope=error: unknown command", query(t, "!nope"))
+	})
+
+	t.Run("list skips keys too long for a page", func(t *testing.T) {
+		long := "long." + strings.Repeat("x", 985)
+		for _, msg := range []string{long + "=v", "long.short=v"} {
+			_, err := conn.Write([]byte(msg))
+			require.NoError(t, err)
+		}
+		require.Eventually(t, func() bool { return query(t, "long.short") == "long.short=v" }, time.Second, 10*time.Millisecond)
+		require.Equal(t, long+"=v", query(t, long))
+
+		require.Equal(t, "!list 0 long.=\nlong.short", query(t, "!list 0 long."))
+	})
+
+	t.Run("list query too long for any keys", func(t *testing.T) {
+		// The error is cut short to fit in the packet.
+		q := "!list 0 " + strings.Repeat("p", 989)
+		require.Equal(t, q+"=e", query(t, q))
+	})
+}
+
+func TestServeKeyOrdering(t *testing.T) {
+	pConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := udb.NewServer(udb.NewStoreMap(), udb.WithWorkers(8))
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, pConn) }()
+
+	conn, err := net.Dial("udp", pConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Inserts to the same key are applied in order, interleaved with inserts to other keys.
+	for i := 0; i < 500; i++ {
+		if i%20 == 0 {
+			// Don't overflow the socket's receive buffer.
+			time.Sleep(time.Millisecond)
+		}
+		_, err := fmt.Fprintf(conn, "counter=%d", i)
+		require.NoError(t, err)
+		_, err = fmt.Fprintf(conn, "other.%d=%d", i, i)
+		require.NoError(t, err)
+	}
+	_, err = conn.Write([]byte("counter"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	res := make([]byte, 1000)
+	n, err := conn.Read(res)
+	require.NoError(t, err)
+	require.Equal(t, "counter=499", string(res[:n]))
+	require.Zero(t, srv.PacketErrors())
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
+
+// BenchmarkServe measures request/response throughput with concurrent clients. The single worker case matches handling every packet inline on the read goroutine.
+func BenchmarkServe(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			pConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(b, err)
+
+			store := udb.NewStoreMap()
+			for i := 0; i < 1000; i++ {
+				store.Insert([]byte(fmt.Sprintf("key.%d", i)), []byte(strings.Repeat("v", 100)))
+			}
+			srv := udb.NewServer(store, udb.WithWorkers(workers))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.Serve(ctx, pConn)
+
+			var i atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := net.Dial("udp", pConn.LocalAddr().String())
+				require.NoError(b, err)
+				defer conn.Close()
+				res := make([]byte, 1000)
+				for pb.Next() {
+					key := fmt.Sprintf("key.%d", i.Add(1)%1000)
+					if _, err := conn.Write([]byte(key)); err != nil {
+						b.Error(err)
+						return
+					}
+					conn.SetReadDeadline(time.Now().Add(time.Second))
+					if _, err := conn.Read(res); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "pkts/s")
+		})
+	}
+}
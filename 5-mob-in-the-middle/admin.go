@@ -0,0 +1,69 @@
+package mobprox
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// StartAdmin listens on port for admin connections. Each connection is sent a table of the active proxied sessions and then closed.
+func (s *Server) StartAdmin(port string) error {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := s.writeSessions(conn); err != nil {
+				log.Printf("admin: writeSessions: %v", err)
+			}
+		}(conn)
+	}
+}
+
+// writeSessions writes a table of the active sessions, ordered by client ID.
+// Ex:
+//
+//	ID  CLIENT           UPSTREAM         FROM_CLIENT  FROM_UPSTREAM  AGE
+//	1   127.0.0.1:50122  127.0.0.1:16963  120          2046           3m2s
+func (s *Server) writeSessions(w io.Writer) error {
+	s.mu.Lock()
+	sessions := make([]*client, 0, len(s.sessions))
+	for _, c := range s.sessions {
+		sessions = append(sessions, c)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(sessions, func(a, b *client) int {
+		ai, _ := strconv.Atoi(a.id)
+		bi, _ := strconv.Atoi(b.id)
+		return ai - bi
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCLIENT\tUPSTREAM\tFROM_CLIENT\tFROM_UPSTREAM\tAGE")
+	for _, c := range sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n",
+			c.id,
+			c.downAddr,
+			c.upAddr,
+			c.fromClient.Load(),
+			c.fromUpstream.Load(),
+			time.Since(c.connectedAt).Round(time.Second),
+		)
+	}
+	return tw.Flush()
+}
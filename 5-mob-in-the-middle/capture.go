@@ -0,0 +1,58 @@
+package mobprox
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// Capture is a single message received by the proxy.
+	Capture struct {
+		Time      time.Time `json:"time"`
+		ClientID  string    `json:"clientId"`
+		Direction direction `json:"direction"`
+		// Message as received, before any rewrite rules are applied.
+		Data string `json:"data"`
+	}
+
+	// capturer writes captures from concurrent sessions as JSON lines.
+	capturer struct {
+		mu  sync.Mutex
+		enc *json.Encoder
+	}
+)
+
+func newCapturer(w io.Writer) *capturer {
+	return &capturer{enc: json.NewEncoder(w)}
+}
+
+func (c *capturer) write(capture Capture) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(capture)
+}
+
+// record counts the bytes of a message received by the client session and captures it, if capturing is enabled.
+func (c *client) record(msg []byte, dir direction) {
+	if dir == FROM_CLIENT {
+		c.fromClient.Add(int64(len(msg)))
+	} else {
+		c.fromUpstream.Add(int64(len(msg)))
+	}
+
+	if c.capture == nil {
+		return
+	}
+	err := c.capture.write(Capture{
+		Time:      time.Now(),
+		ClientID:  c.id,
+		Direction: dir,
+		Data:      string(msg),
+	})
+	if err != nil {
+		log.Printf("[%s]capture: %v\n", c.id, err)
+	}
+}
@@ -18,7 +18,41 @@ func main() {
 		upstreamAddr = UPSTREAM
 	}
 	tonyBcoinAddress := "7YWHMfk9JZe0LM0g1ZauHuiSxhI"
-	srv := mobprox.NewServer(upstreamAddr, tonyBcoinAddress)
+
+	opts := []mobprox.Option{}
+	if RULES_FILE := os.Getenv("RULES_FILE"); RULES_FILE != "" {
+		rules, err := mobprox.LoadRules(RULES_FILE)
+		if err != nil {
+			log.Fatalf("load rules: %v", err)
+		}
+		opts = append(opts, mobprox.WithRules(rules))
+	}
+
+	if MODE := os.Getenv("MODE"); MODE == "passthrough" {
+		opts = append(opts, mobprox.WithPassthrough())
+	}
+	if CAPTURE_FILE := os.Getenv("CAPTURE_FILE"); CAPTURE_FILE != "" {
+		f, err := os.OpenFile(CAPTURE_FILE, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("open capture file: %v", err)
+		}
+		defer f.Close()
+		opts = append(opts, mobprox.WithCapture(f))
+	}
+
+	srv, err := mobprox.NewServer(upstreamAddr, tonyBcoinAddress, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if ADMIN_PORT := os.Getenv("ADMIN_PORT"); ADMIN_PORT != "" {
+		go func() {
+			log.Printf("Mob Proxy admin starting on port: %s", ADMIN_PORT)
+			if err := srv.StartAdmin(ADMIN_PORT); err != nil {
+				log.Printf("admin: %v", err)
+			}
+		}()
+	}
 
 	log.Printf("Mob Proxy starting on port: %s", port)
 
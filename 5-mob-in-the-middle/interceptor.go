@@ -1,67 +1,139 @@
 package mobprox
 
 import (
-	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
 	"regexp"
-	"slices"
+	"strings"
 )
 
 type (
-	bcoinReplacer struct {
-		// Boguscoin Address to replace with
-		bCoinAddr string
-		// Pattern to match a Boguscoin address.
-		bCoinPattern *regexp.Regexp
+	// Rule rewrites every token in a message that fully matches its pattern.
+	Rule struct {
+		// Name used in logs and errors.
+		Name string `json:"name"`
+		// Regular expression a token must match in its entirety. It cannot match across token boundaries.
+		Match string `json:"match"`
+		// Replacement for a matched token. $1 style references to submatches are expanded.
+		Replace string `json:"replace"`
+		// Traffic the rule applies to. Zero means both directions.
+		Direction direction `json:"direction,omitempty"`
+
+		pattern *regexp.Regexp
+	}
+
+	// rewriter applies a chain of rules to messages. Each rule sees the output of the rule before it.
+	rewriter struct {
+		rules []*Rule
 	}
 )
 
-func newbcoinReplacer(bCoinAddr string) *bcoinReplacer {
-	return &bcoinReplacer{
-		bCoinAddr: bCoinAddr,
-		// it starts with a "7"
-		// it consists of at least 26, and at most 35, alphanumeric characters
-		// it starts at the start of a chat message, or is preceded by a space
-		// it ends at the end of a chat message, or is followed by a space
-		bCoinPattern: regexp.MustCompile(`\s?(\b7\w{25,35}-?)\s?`),
+// tokenSeparators are the bytes that end a token. A rule only matches whole tokens, so "7abc" is not matched inside "x7abc" or "7abc-123", but is matched in "7abc." or "7abc\n".
+const tokenSeparators = " \t\r\n.,;:!?\"'()[]{}<>"
+
+// Boguscoin address:
+// it starts with a "7"
+// it consists of at least 26, and at most 35, alphanumeric characters
+// it starts at the start of a chat message, or is preceded by a space
+// it ends at the end of a chat message, or is followed by a space
+const boguscoinPattern = `7[[:alnum:]]{25,34}`
+
+// BoguscoinRule returns a rule that replaces Boguscoin addresses in both directions with bCoinAddr.
+func BoguscoinRule(bCoinAddr string) Rule {
+	return Rule{
+		Name:    "boguscoin",
+		Match:   boguscoinPattern,
+		Replace: bCoinAddr,
 	}
 }
 
-func (b *bcoinReplacer) intercept(in []byte) []byte {
-	matches := b.bCoinPattern.FindAllSubmatchIndex(in, -1)
-	if matches == nil {
-		return in
+// LoadRules reads a JSON array of rules from the file at path.
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	return ParseRules(f)
+}
+
+// ParseRules decodes a JSON array of rules.
+// Ex:
+//
+//	[{"name": "boguscoin", "match": "7[[:alnum:]]{25,34}", "replace": "7YWHMfk9JZe0LM0g1ZauHuiSxhI", "direction": "FROM_CLIENT"}]
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return rules, nil
+}
+
+func newRewriter(rules []Rule) (*rewriter, error) {
+	rw := &rewriter{rules: make([]*Rule, 0, len(rules))}
+	for i := range rules {
+		r := rules[i]
+		// Anchor the pattern so it must match the whole token.
+		pattern, err := regexp.Compile(`^(?:` + r.Match + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, r.Name, err)
+		}
+		r.pattern = pattern
+		rw.rules = append(rw.rules, &r)
 	}
+	return rw, nil
+}
 
-	out := slices.Clone(in)
-	offset := 0
-	for _, match := range matches {
-		// Submatch start and end indexes will be at match[2], match[3]
-		startI := match[2] + offset
-		endI := match[3] + offset
+func newbcoinReplacer(bCoinAddr string) *rewriter {
+	rw, err := newRewriter([]Rule{BoguscoinRule(bCoinAddr)})
+	if err != nil {
+		panic(err)
+	}
+	return rw
+}
 
-		endsWithNewline := out[len(out)-1] == '\n'
-		_in := bytes.TrimSpace(out)
-		// Remove the original
-		origBcoin := _in[startI:endI]
-		if bytes.Equal(origBcoin, []byte(b.bCoinAddr)) {
+func (rw *rewriter) intercept(in []byte, dir direction) []byte {
+	out := in
+	for _, r := range rw.rules {
+		if r.Direction != 0 && r.Direction&dir == 0 {
 			continue
 		}
-		// Hack to ignore invalid characters. Couldn't get the regexp to work yet.
-		if len(origBcoin) > 35 {
+		out = r.apply(out)
+	}
+	return out
+}
+
+// apply replaces each token in the message that matches the rule's pattern. The input is returned as-is if nothing is replaced.
+func (r *Rule) apply(in []byte) []byte {
+	var out []byte
+	replaced := false
+	// Index after the last replaced token.
+	last := 0
+	start := -1
+	for i := 0; i <= len(in); i++ {
+		if i < len(in) && strings.IndexByte(tokenSeparators, in[i]) < 0 {
+			if start < 0 {
+				start = i
+			}
 			continue
 		}
-		if bytes.ContainsAny(origBcoin[len(origBcoin)-1:], "-") {
+		if start < 0 {
 			continue
 		}
 
-		offset += len(b.bCoinAddr) - len(origBcoin)
-
-		_in = bytes.Replace(_in, origBcoin, []byte(""), 1)
-		replaced := slices.Insert(_in, startI, []byte(b.bCoinAddr)...)
-		if endsWithNewline {
-			replaced = append(replaced, '\n')
+		token := in[start:i]
+		if r.pattern.Match(token) {
+			out = append(out, in[last:start]...)
+			out = append(out, r.pattern.ReplaceAll(token, []byte(r.Replace))...)
+			last = i
+			replaced = true
 		}
-		out = replaced
+		start = -1
 	}
-	return out
+	if !replaced {
+		return in
+	}
+	return append(out, in[last:]...)
 }
@@ -1,6 +1,7 @@
 package mobprox
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -62,8 +63,72 @@ func TestBcoinReplacer(t *testing.T) {
 	i := newbcoinReplacer(tonyBcoin)
 	for _, tc := range testCases {
 		t.Run(tc.label, func(t *testing.T) {
-			got := i.intercept(tc.input)
-			require.Equal(t, string(tc.want), string(got))
+			for _, dir := range []direction{FROM_CLIENT, FROM_UPSTREAM} {
+				got := i.intercept(tc.input, dir)
+				require.Equal(t, string(tc.want), string(got))
+			}
 		})
 	}
 }
+
+func TestRewriter(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`[
+		{"name": "boguscoin", "match": "7[[:alnum:]]{25,34}", "replace": "7YWHMfk9JZe0LM0g1ZauHuiSxhI"},
+		{"name": "greeting", "match": "(?i)hi", "replace": "Bonjour", "direction": "FROM_CLIENT"},
+		{"name": "tag", "match": "Bonjour", "replace": "[fr]$0", "direction": "FROM_CLIENT"},
+		{"name": "swap", "match": "(\\w+)-(\\w+)", "replace": "${2}-${1}", "direction": "FROM_UPSTREAM"}
+	]`))
+	require.NoError(t, err)
+
+	rw, err := newRewriter(rules)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		label string
+		dir   direction
+		input string
+		want  string
+	}{
+		{
+			label: "applies rules for the direction in order",
+			dir:   FROM_CLIENT,
+			input: "Hi alice, pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n",
+			want:  "[fr]Bonjour alice, pay 7YWHMfk9JZe0LM0g1ZauHuiSxhI\n",
+		},
+		{
+			label: "skips rules for the other direction",
+			dir:   FROM_UPSTREAM,
+			input: "Hi alice\n",
+			want:  "Hi alice\n",
+		},
+		{
+			label: "only matches whole tokens",
+			dir:   FROM_CLIENT,
+			input: "this hint is his\n",
+			want:  "this hint is his\n",
+		},
+		{
+			label: "expands submatches",
+			dir:   FROM_UPSTREAM,
+			input: "hello-world, hi\n",
+			want:  "world-hello, hi\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.label, func(t *testing.T) {
+			got := rw.intercept([]byte(tc.input), tc.dir)
+			require.Equal(t, tc.want, string(got))
+		})
+	}
+
+	t.Run("rejects unknown directions", func(t *testing.T) {
+		_, err := ParseRules(strings.NewReader(`[{"match": "x", "direction": "SIDEWAYS"}]`))
+		require.Error(t, err)
+	})
+
+	t.Run("rejects invalid patterns", func(t *testing.T) {
+		_, err := newRewriter([]Rule{{Name: "bad", Match: "("}})
+		require.Error(t, err)
+	})
+}
@@ -3,10 +3,14 @@ package mobprox
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
@@ -14,32 +18,117 @@ type (
 		listener     net.Listener
 		upstreamAddr string
 		interceptor  interceptor
+		// Optional recorder for all proxied messages.
+		capture *capturer
+
+		mu sync.Mutex
+		// Active proxied sessions by client ID.
+		sessions map[string]*client
 	}
 
 	interceptor interface {
-		intercept([]byte) []byte
+		intercept(msg []byte, dir direction) []byte
 	}
 
 	client struct {
-		id string
+		id           string
+		downAddr     net.Addr
+		upAddr       net.Addr
+		connectedAt  time.Time
+		capture      *capturer
+		fromClient   atomic.Int64 // Bytes received from the client.
+		fromUpstream atomic.Int64 // Bytes received from upstream.
 	}
 
 	ctxKey string
 
+	closeWriter interface {
+		CloseWrite() error
+	}
+
 	direction int
+
+	Option func(*Server) error
 )
 
 const CLIENT_ID ctxKey = "CLIENT_ID"
+
+const dialTimeout = 10 * time.Second
 const (
-	FROM_CLIENT direction = iota
+	FROM_CLIENT direction = 1 << iota
 	FROM_UPSTREAM
 )
 
-func NewServer(upstreamAddr, boguscoinAddress string) *Server {
-	return &Server{
+// NewServer creates a proxy to upstreamAddr that rewrites Boguscoin addresses to boguscoinAddress, unless other rules are given with WithRules.
+func NewServer(upstreamAddr, boguscoinAddress string, opts ...Option) (*Server, error) {
+	s := &Server{
 		upstreamAddr: upstreamAddr,
 		interceptor:  newbcoinReplacer(boguscoinAddress),
+		sessions:     make(map[string]*client),
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// WithRules replaces the default Boguscoin rule with the given chain of rewrite rules.
+func WithRules(rules []Rule) Option {
+	return func(s *Server) error {
+		rw, err := newRewriter(rules)
+		if err != nil {
+			return fmt.Errorf("newRewriter: %w", err)
+		}
+		s.interceptor = rw
+		return nil
+	}
+}
+
+// WithPassthrough forwards messages unchanged, for using the proxy to debug any line-based service.
+func WithPassthrough() Option {
+	return func(s *Server) error {
+		s.interceptor = &rewriter{}
+		return nil
+	}
+}
+
+// WithCapture records every message received from either side of every session to w as JSON lines.
+func WithCapture(w io.Writer) Option {
+	return func(s *Server) error {
+		s.capture = newCapturer(w)
+		return nil
+	}
+}
+
+func (d direction) String() string {
+	switch d {
+	case FROM_CLIENT:
+		return "FROM_CLIENT"
+	case FROM_UPSTREAM:
+		return "FROM_UPSTREAM"
+	default:
+		return ""
+	}
+}
+
+func (d direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *direction) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "FROM_CLIENT":
+		*d = FROM_CLIENT
+	case "FROM_UPSTREAM":
+		*d = FROM_UPSTREAM
+	case "":
+		*d = 0
+	default:
+		return fmt.Errorf("unknown direction: %q", text)
 	}
+	return nil
 }
 
 func (s *Server) Start(port string) error {
@@ -47,6 +136,11 @@ func (s *Server) Start(port string) error {
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve accepts client connections on l and proxies each to a new upstream connection. It returns when l is closed.
+func (s *Server) Serve(l net.Listener) error {
 	defer l.Close()
 
 	s.listener = l
@@ -56,50 +150,102 @@ func (s *Server) Start(port string) error {
 		clientID++
 		conn, err := s.listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			log.Printf("accept: %v", err)
 			continue
 		}
 		ctx := context.WithValue(context.Background(), ctxKey(CLIENT_ID), fmt.Sprintf("%d", clientID))
 
-		go func(conn net.Conn, clientID int) {
+		go func(conn net.Conn) {
 			if err := s.handleConnection(ctx, conn); err != nil {
 				log.Printf("downstream error: %v", err)
-				if err := conn.Close(); err != nil {
-					log.Printf("close: %v", err)
-				}
 			}
-		}(conn, clientID)
+		}(conn)
 	}
 }
 
+// handleConnection proxies the session until both directions are finished. A read EOF in one direction is forwarded as a half-close, so the other direction keeps flowing until its side closes too. Any other error aborts the whole session.
 func (s *Server) handleConnection(ctx context.Context, down net.Conn) error {
-	up, err := net.Dial("tcp", s.upstreamAddr)
+	up, err := net.DialTimeout("tcp", s.upstreamAddr, dialTimeout)
 	if err != nil {
-		return err
+		closeClean(down)
+		return fmt.Errorf("dial upstream: %w", err)
 	}
 
 	clientID := ctx.Value(CLIENT_ID).(string)
-	client := newClient(clientID)
+	client := newClient(clientID, down, up, s.capture)
+	s.addSession(client)
+	defer s.removeSession(client.id)
 
-	go client.proxy(ctx, up, down, s.interceptor, FROM_CLIENT)
-	go client.proxy(ctx, down, up, s.interceptor, FROM_UPSTREAM)
+	errs := make(chan error, 2)
+	go func() {
+		errs <- client.proxy(ctx, up, down, s.interceptor, FROM_CLIENT)
+	}()
+	go func() {
+		errs <- client.proxy(ctx, down, up, s.interceptor, FROM_UPSTREAM)
+	}()
 
-	return nil
+	var sessionErr error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil && sessionErr == nil {
+			sessionErr = err
+			// Unblock the other direction.
+			down.Close()
+			up.Close()
+		}
+	}
+	down.Close()
+	up.Close()
+	return sessionErr
+}
+
+// closeClean closes conn without resetting it. Unread data would otherwise make the close send an RST instead of a FIN.
+func closeClean(conn net.Conn) {
+	defer conn.Close()
+	cw, ok := conn.(closeWriter)
+	if !ok {
+		return
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(io.Discard, conn)
 }
 
-func newClient(id string) *client {
-	client := &client{id: id}
+func newClient(id string, down, up net.Conn, capture *capturer) *client {
+	client := &client{
+		id:          id,
+		downAddr:    down.RemoteAddr(),
+		upAddr:      up.RemoteAddr(),
+		connectedAt: time.Now(),
+		capture:     capture,
+	}
 	return client
 }
 
-// proxy reads from the src connection,
+func (s *Server) addSession(c *client) {
+	s.mu.Lock()
+	s.sessions[c.id] = c
+	s.mu.Unlock()
+}
+
+func (s *Server) removeSession(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+// proxy forwards complete lines from src to dst until src is closed. Once src reaches EOF, any partial line is discarded and the write side of dst is closed.
 func (c *client) proxy(
 	ctx context.Context,
-	dst io.WriteCloser,
-	src io.ReadCloser,
+	dst io.Writer,
+	src io.Reader,
 	interceptor interceptor,
 	dir direction,
-) {
+) error {
 	dstName := "CLIENT"
 	srcName := "UPSTREAM"
 	if dir == FROM_CLIENT {
@@ -107,33 +253,40 @@ func (c *client) proxy(
 		srcName = "CLIENT"
 	}
 
-	// Read from the source connection
+	// A single reader for the life of the session, so bytes buffered after a line are not lost.
+	srcConn := bufio.NewReader(src)
 	for {
-		srcConn := bufio.NewReader(src)
 		// Using ReadBytes instead of textproto.Reader.ReadLineBytes because
 		// ReadLineBytes will return the last line of the message, even if it
 		// doesn't end in a newline. bufio.Reader.ReadBytes will return an error if
 		// the message doesn't end in a newline.
 		msg, err := srcConn.ReadBytes('\n')
 		if err != nil {
-			log.Printf("[%s|%s]read error: %v\n", c.id, srcName, err)
-			dst.Close()
-			src.Close()
-			return
+			if len(msg) > 0 {
+				log.Printf("[%s|%s]discarding partial line (%d B)\n", c.id, srcName, len(msg))
+			}
+			if !errors.Is(err, io.EOF) {
+				return fmt.Errorf("[%s|%s]read: %w", c.id, srcName, err)
+			}
+			log.Printf("[%s|%s]closed\n", c.id, srcName)
+			if cw, ok := dst.(closeWriter); ok {
+				if err := cw.CloseWrite(); err != nil {
+					return fmt.Errorf("[%s|%s]closeWrite: %w", c.id, dstName, err)
+				}
+			}
+			return nil
 		}
 
 		log.Printf("\n← [%s|%s] (%d B):\n%s\n", c.id, srcName, len(msg), msg)
+		c.record(msg, dir)
 
 		// Replace message contents
-		msg = interceptor.intercept(msg)
+		msg = interceptor.intercept(msg, dir)
 
 		// Proxy the message out to the destination
 		nWrote, err := dst.Write(msg)
 		if err != nil {
-			log.Printf("[%s|%s]write error: %v\n", c.id, dstName, err)
-			dst.Close()
-			src.Close()
-			return
+			return fmt.Errorf("[%s|%s]write: %w", c.id, dstName, err)
 		}
 
 		log.Printf("\n→ [%s|%s] (%d B):\n%s\n", c.id, dstName, nWrote, msg)
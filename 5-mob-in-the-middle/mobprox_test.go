@@ -1,7 +1,14 @@
 package mobprox_test
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -52,8 +59,9 @@ func TestServer(t *testing.T) {
 
 	time.Sleep(10 * time.Millisecond)
 	go func() {
-		srv := mobprox.NewServer(upstreamAddr, "tonyBcoinAddress")
-		err := srv.Start(port)
+		srv, err := mobprox.NewServer(upstreamAddr, "tonyBcoinAddress")
+		require.NoError(t, err)
+		err = srv.Start(port)
 		require.NoError(t, err)
 	}()
 
@@ -80,3 +88,218 @@ func TestServer(t *testing.T) {
 		require.Equal(t, 0, len(upstreamServer.recvMsgs))
 	})
 }
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startEchoServer starts an upstream that echoes each line back to the sender.
+func startEchoServer(t *testing.T) string {
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestPassthroughCapture(t *testing.T) {
+	port := "9877"
+	adminPort := "9878"
+	upstreamAddr := startEchoServer(t)
+
+	capture := &lockedBuffer{}
+	srv, err := mobprox.NewServer(upstreamAddr, "tonyBcoinAddress", mobprox.WithPassthrough(), mobprox.WithCapture(capture))
+	require.NoError(t, err)
+	go srv.Start(port)
+	go srv.StartAdmin(adminPort)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", ":"+port)
+	require.NoError(t, err)
+
+	msg := "pay 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n"
+	_, err = conn.Write([]byte(msg))
+	require.NoError(t, err)
+
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, msg, got, "passthrough should not rewrite messages")
+
+	t.Run("admin lists active sessions", func(t *testing.T) {
+		admin, err := net.Dial("tcp", ":"+adminPort)
+		require.NoError(t, err)
+		defer admin.Close()
+
+		table, err := io.ReadAll(admin)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(table)), "\n")
+		require.Len(t, lines, 2)
+		fields := strings.Fields(lines[1])
+		require.Equal(t, "1", fields[0])
+		require.Equal(t, conn.LocalAddr().String(), fields[1])
+		require.Equal(t, upstreamAddr, fields[2])
+		require.Equal(t, fmt.Sprint(len(msg)), fields[3])
+		require.Equal(t, fmt.Sprint(len(msg)), fields[4])
+	})
+
+	t.Run("captures both directions", func(t *testing.T) {
+		dec := json.NewDecoder(strings.NewReader(capture.String()))
+		var captures []mobprox.Capture
+		for dec.More() {
+			var c mobprox.Capture
+			require.NoError(t, dec.Decode(&c))
+			captures = append(captures, c)
+		}
+		require.Len(t, captures, 2)
+		require.Equal(t, mobprox.FROM_CLIENT, captures[0].Direction)
+		require.Equal(t, mobprox.FROM_UPSTREAM, captures[1].Direction)
+		for _, c := range captures {
+			require.Equal(t, "1", c.ClientID)
+			require.Equal(t, msg, c.Data)
+			require.WithinDuration(t, time.Now(), c.Time, time.Second)
+		}
+	})
+
+	t.Run("closed sessions are removed", func(t *testing.T) {
+		require.NoError(t, conn.Close())
+		time.Sleep(50 * time.Millisecond)
+
+		admin, err := net.Dial("tcp", ":"+adminPort)
+		require.NoError(t, err)
+		defer admin.Close()
+
+		table, err := io.ReadAll(admin)
+		require.NoError(t, err)
+		require.Len(t, strings.Split(strings.TrimSpace(string(table)), "\n"), 1)
+	})
+}
+
+// startProxy serves a passthrough proxy to upstreamAddr on a local listener and returns its address.
+func startProxy(t *testing.T, upstreamAddr string) string {
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	srv, err := mobprox.NewServer(upstreamAddr, "tonyBcoinAddress", mobprox.WithPassthrough())
+	require.NoError(t, err)
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+func TestHalfClose(t *testing.T) {
+	t.Run("forwards fragmented lines and discards the partial line at EOF", func(t *testing.T) {
+		l, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		defer l.Close()
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for _, frag := range []string{"Wel", "come to", " chat\nsecond", " line\nthird line\npart", "ial"} {
+				conn.Write([]byte(frag))
+				time.Sleep(20 * time.Millisecond)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+			io.Copy(io.Discard, conn)
+		}()
+
+		conn, err := net.Dial("tcp", startProxy(t, l.Addr().String()))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, "Welcome to chat\nsecond line\nthird line\n", string(got))
+	})
+
+	t.Run("keeps upstream to client open after the client half-closes", func(t *testing.T) {
+		l, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		defer l.Close()
+
+		upstreamGot := make(chan string, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			data, _ := io.ReadAll(conn)
+			upstreamGot <- string(data)
+			// Reply only after the client has finished sending.
+			time.Sleep(50 * time.Millisecond)
+			conn.Write([]byte("goodbye\n"))
+		}()
+
+		conn, err := net.Dial("tcp", startProxy(t, l.Addr().String()))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello\n"))
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("unfinished"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		select {
+		case got := <-upstreamGot:
+			require.Equal(t, "hello\n", got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("upstream did not see the half-close")
+		}
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, "goodbye\n", string(got))
+	})
+
+	t.Run("closes the client cleanly if upstream is unreachable", func(t *testing.T) {
+		l, err := nettest.NewLocalListener("tcp")
+		require.NoError(t, err)
+		unreachable := l.Addr().String()
+		l.Close()
+
+		conn, err := net.Dial("tcp", startProxy(t, unreachable))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello\n"))
+		require.NoError(t, err)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, err := conn.Read(make([]byte, 16))
+		require.Zero(t, n)
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
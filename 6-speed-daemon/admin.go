@@ -0,0 +1,198 @@
+package spdaemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+)
+
+type (
+	// RoadStatus is the live state of a road served by the admin endpoints.
+	roadStatus struct {
+		Road        uint16         `json:"road"`
+		Limit       uint16         `json:"limit"`
+		Cameras     []cameraStatus `json:"cameras"`
+		Dispatchers []string       `json:"dispatchers"` // Remote addresses
+		Outbox      int            `json:"outbox"`      // Tickets waiting for a dispatcher
+	}
+
+	cameraStatus struct {
+		Mile  uint16 `json:"mile"`
+		Limit uint16 `json:"limit"`
+	}
+
+	pendingTicket struct {
+		Plate      string           `json:"plate"`
+		Road       uint16           `json:"road"`
+		Mile1      uint16           `json:"mile1"`
+		Timestamp1 message.UnixTime `json:"timestamp1"`
+		Mile2      uint16           `json:"mile2"`
+		Timestamp2 message.UnixTime `json:"timestamp2"`
+		Speed      uint16           `json:"speed"`
+		Limit      uint16           `json:"limit"`
+	}
+)
+
+func newPendingTicket(t *message.Ticket) pendingTicket {
+	return pendingTicket{
+		Plate:      t.Plate,
+		Road:       t.Road,
+		Mile1:      t.Mile1,
+		Timestamp1: t.Timestamp1,
+		Mile2:      t.Mile2,
+		Timestamp2: t.Timestamp2,
+		Speed:      t.Speed,
+		Limit:      t.Limit,
+	}
+}
+
+// AdminHandler serves the admin endpoints. It should be served on a separate listener from the binary protocol.
+//
+//	GET    /metrics					-> Prometheus text-format metrics
+//	GET    /roads					-> cameras and dispatchers connected to each road
+//	GET    /tickets/pending				-> tickets waiting for a dispatcher
+//	DELETE /tickets/pending?road=<id>[&plate=<plate>]	-> drop the road's held tickets
+//	GET    /history?plate=<plate>			-> tickets issued to the plate
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/roads", s.handleRoads)
+	mux.HandleFunc("/tickets/pending", s.handlePending)
+	mux.HandleFunc("/history", s.handleHistory)
+	return mux
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := s.writeMetrics(w); err != nil {
+		log.Printf("admin: writeMetrics: %v", err)
+	}
+}
+
+// writeMetrics writes the metrics in the Prometheus text exposition format.
+// Ex:
+//
+//	# HELP spdaemon_tickets_issued_total Tickets issued.
+//	# TYPE spdaemon_tickets_issued_total counter
+//	spdaemon_tickets_issued_total 3
+//	# HELP spdaemon_ticket_outbox Tickets waiting for a dispatcher, by road.
+//	# TYPE spdaemon_ticket_outbox gauge
+//	spdaemon_ticket_outbox{road="123"} 1
+func (s *Server) writeMetrics(w io.Writer) error {
+	m := &s.metrics
+	metrics := []struct {
+		name, help, typ string
+		value           int64
+	}{
+		{"spdaemon_cameras", "Connected cameras.", "gauge", m.Clients.Cameras.Load()},
+		{"spdaemon_dispatchers", "Connected ticket dispatchers.", "gauge", m.Clients.Dispatchers.Load()},
+		{"spdaemon_plates_total", "Plate observations received.", "counter", m.Plates.Total.Load()},
+		{"spdaemon_plates_unique_total", "Plates seen for the first time on a road.", "counter", m.Plates.Unique.Load()},
+		{"spdaemon_tickets_issued_total", "Tickets issued.", "counter", m.Tickets.Issued.Load()},
+		{"spdaemon_tickets_suppressed_total", "Violations not ticketed because the car already had a ticket that day.", "counter", m.Tickets.Suppressed.Load()},
+		{"spdaemon_tickets_delivered_total", "Tickets written to a dispatcher.", "counter", m.Tickets.Delivered.Load()},
+		{"spdaemon_tickets_dropped_total", "Tickets discarded by an operator.", "counter", m.Tickets.Dropped.Load()},
+		{"spdaemon_tickets_warned_total", "Violations logged but not ticketed on warn-only roads.", "counter", m.Tickets.Warned.Load()},
+	}
+	for _, mt := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", mt.name, mt.help, mt.name, mt.typ, mt.name, mt.value); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprint(w, "# HELP spdaemon_ticket_outbox Tickets waiting for a dispatcher, by road.\n# TYPE spdaemon_ticket_outbox gauge\n"); err != nil {
+		return err
+	}
+	for _, r := range s.sortedRoads() {
+		if depth := r.outboxDepth(); depth > 0 {
+			if _, err := fmt.Fprintf(w, "spdaemon_ticket_outbox{road=\"%d\"} %d\n", r.id, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleRoads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	roads := s.sortedRoads()
+	statuses := make([]roadStatus, 0, len(roads))
+	for _, rd := range roads {
+		statuses = append(statuses, rd.status())
+	}
+	writeJSON(w, statuses)
+}
+
+func (s *Server) handlePending(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tickets := make([]pendingTicket, 0)
+		for _, rd := range s.sortedRoads() {
+			tickets = append(tickets, rd.pending()...)
+		}
+		writeJSON(w, tickets)
+	case http.MethodDelete:
+		roadID, err := strconv.ParseUint(r.URL.Query().Get("road"), 10, 16)
+		if err != nil {
+			http.Error(w, "invalid road: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := s.DropTickets(uint16(roadID), r.URL.Query().Get("plate"))
+		if err != nil {
+			log.Printf("admin: DropTickets: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]int{"dropped": n})
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	plate := r.URL.Query().Get("plate")
+	if plate == "" {
+		http.Error(w, "missing plate", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"plate":   plate,
+		"history": s.ih.PrintHistory(plate),
+	})
+}
+
+// SortedRoads returns the road shards ordered by road ID.
+func (s *Server) sortedRoads() []*road {
+	s.mu.RLock()
+	roads := make([]*road, 0, len(s.roads))
+	for _, r := range s.roads {
+		roads = append(roads, r)
+	}
+	s.mu.RUnlock()
+	sort.Slice(roads, func(i, j int) bool { return roads[i].id < roads[j].id })
+	return roads
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin: encode response: %v", err)
+	}
+}
@@ -1,9 +1,5 @@
 package spdaemon
 
-import (
-	"encoding/binary"
-)
-
 type (
 	// Each camera is on a specific road, at a specific location, and has a specific speed limit.
 	Camera struct {
@@ -12,13 +8,3 @@ type (
 		Limit uint16
 	}
 )
-
-func (c *Camera) UnmarshalBinary(msg []byte) {
-	// Fields are ORDERED in data
-	// road: u16
-	c.Road = binary.BigEndian.Uint16(msg[1:3])
-	// mile: u16
-	c.Mile = binary.BigEndian.Uint16(msg[3:5])
-	// limit: u16 (miles per hour)
-	c.Limit = binary.BigEndian.Uint16(msg[5:])
-}
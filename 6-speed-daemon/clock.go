@@ -0,0 +1,29 @@
+package spdaemon
+
+import "time"
+
+type (
+	// Clock makes tickers, so tests can control time.
+	clock interface {
+		NewTicker(d time.Duration) ticker
+	}
+
+	ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	realClock struct{}
+
+	realTicker struct {
+		*time.Ticker
+	}
+)
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
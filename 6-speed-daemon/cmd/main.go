@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 
 	spdaemon "github.com/harveysanders/protohackers/6-speed-daemon"
+	"github.com/harveysanders/protohackers/6-speed-daemon/sqlite"
 )
 
 func main() {
@@ -14,7 +16,40 @@ func main() {
 		port = PORT
 	}
 
-	srv := spdaemon.NewServer()
+	opts := []spdaemon.Option{}
+	// Persist tickets and observations across restarts if a database is set.
+	if DSN := os.Getenv("DSN"); DSN != "" {
+		db := sqlite.NewDB(DSN)
+		if err := db.Open(); err != nil {
+			log.Fatalf("db.Open: %v", err)
+		}
+		defer db.Close()
+		opts = append(opts,
+			spdaemon.WithIssueHistory(sqlite.NewTicketLedger(db)),
+			spdaemon.WithObservationStore(sqlite.NewObservationStore(db)),
+		)
+	}
+
+	// Load per-road enforcement policies if a config file is set.
+	if POLICY_FILE := os.Getenv("POLICY_FILE"); POLICY_FILE != "" {
+		policies, err := spdaemon.LoadPolicies(POLICY_FILE)
+		if err != nil {
+			log.Fatalf("LoadPolicies: %v", err)
+		}
+		opts = append(opts, spdaemon.WithPolicies(policies))
+	}
+
+	srv := spdaemon.NewServer(opts...)
+
+	// Serve metrics and admin endpoints on a separate port if one is set.
+	if ADMIN_PORT := os.Getenv("ADMIN_PORT"); ADMIN_PORT != "" {
+		go func() {
+			log.Printf("admin listening @ :%s", ADMIN_PORT)
+			if err := http.ListenAndServe(":"+ADMIN_PORT, srv.AdminHandler()); err != nil {
+				log.Printf("admin: %v", err)
+			}
+		}()
+	}
 	if err := srv.Start(context.Background(), port); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,195 @@
+// Simulate drives camera and dispatcher traffic from a scenario file against a speed daemon, then checks every ticket it receives against the violations it expected.
+//
+// Usage:
+//
+//	ADDR=localhost:9999 RATE=1000 SETTLE=2s go run ./cmd/simulate scenario.json
+//
+// RATE limits the plates sent per second across all cameras. Zero or unset sends as fast as possible. SETTLE is how long to keep listening for tickets after the last plate is sent.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: %s <scenario.json>", os.Args[0])
+	}
+	sc, err := loadScenario(os.Args[1])
+	if err != nil {
+		log.Fatalf("loadScenario: %v", err)
+	}
+
+	addr := "localhost:9999"
+	if ADDR := os.Getenv("ADDR"); ADDR != "" {
+		addr = ADDR
+	}
+	rate := 0
+	if RATE := os.Getenv("RATE"); RATE != "" {
+		if rate, err = strconv.Atoi(RATE); err != nil {
+			log.Fatalf("RATE: %v", err)
+		}
+	}
+	settle := 2 * time.Second
+	if SETTLE := os.Getenv("SETTLE"); SETTLE != "" {
+		if settle, err = time.ParseDuration(SETTLE); err != nil {
+			log.Fatalf("SETTLE: %v", err)
+		}
+	}
+
+	rep, err := run(sc, addr, rate, settle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rep.print(os.Stdout)
+	if !rep.ok() {
+		os.Exit(1)
+	}
+}
+
+// Run connects the scenario's dispatchers, sends every camera's plates over its own connection, and checks the tickets that arrive before the settle period ends.
+func run(sc *Scenario, addr string, rate int, settle time.Duration) (report, error) {
+	seen := sc.generate()
+	o := newOracle(seen)
+
+	var mu sync.Mutex
+	var tickets []*message.Ticket
+	var serverErrs []string
+
+	var readers sync.WaitGroup
+	dispatchers := make([]net.Conn, 0, len(sc.Dispatchers))
+	defer func() {
+		for _, conn := range dispatchers {
+			conn.Close()
+		}
+	}()
+	for _, roads := range sc.Dispatchers {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return report{}, fmt.Errorf("dial dispatcher: %w", err)
+		}
+		dispatchers = append(dispatchers, conn)
+		id := message.IAmDispatcher{Roads: roads}
+		if _, err := conn.Write(id.MarshalBinary()); err != nil {
+			return report{}, fmt.Errorf("write IAmDispatcher: %w", err)
+		}
+
+		readers.Add(1)
+		go func(conn net.Conn) {
+			defer readers.Done()
+			err := readMessages(conn, func(t *message.Ticket) {
+				mu.Lock()
+				tickets = append(tickets, t)
+				mu.Unlock()
+			})
+			if err != nil {
+				mu.Lock()
+				serverErrs = append(serverErrs, err.Error())
+				mu.Unlock()
+			}
+		}(conn)
+	}
+
+	// Limits the plates sent per second across all cameras.
+	var limiter <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	var cameras sync.WaitGroup
+	camErrs := make(chan error, len(seen))
+	for cam, obs := range seen {
+		cameras.Add(1)
+		go func(cam camera, obs []observation) {
+			defer cameras.Done()
+			if err := sendPlates(addr, cam, obs, limiter); err != nil {
+				camErrs <- fmt.Errorf("camera road %d mile %d: %w", cam.road, cam.mile, err)
+			}
+		}(cam, obs)
+	}
+	cameras.Wait()
+	close(camErrs)
+	for err := range camErrs {
+		return report{}, err
+	}
+
+	time.Sleep(settle)
+	for _, conn := range dispatchers {
+		conn.Close()
+	}
+	readers.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	rep := o.check(tickets)
+	rep.Errors = serverErrs
+	return rep, nil
+}
+
+func sendPlates(addr string, cam camera, obs []observation, limiter <-chan time.Time) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	id := message.IAmCamera{Road: cam.road, Mile: cam.mile, Limit: cam.limit}
+	if _, err := conn.Write(id.MarshalBinary()); err != nil {
+		return fmt.Errorf("write IAmCamera: %w", err)
+	}
+	w := bufio.NewWriter(conn)
+	for _, ob := range obs {
+		if limiter != nil {
+			<-limiter
+			// Send each plate as it is seen, rather than in batches.
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("flush: %w", err)
+			}
+		}
+		p := message.Plate{Plate: ob.plate, Timestamp: time.Unix(int64(ob.timestamp), 0)}
+		if _, err := w.Write(p.MarshalBinary()); err != nil {
+			return fmt.Errorf("write Plate: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	// Give the server a moment to read the plates before hanging up.
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// ReadMessages reads tickets from a dispatcher connection until it is closed. It returns an error if the server sends an Error or an unexpected message.
+func readMessages(conn net.Conn, onTicket func(*message.Ticket)) error {
+	dec := message.NewDecoder(conn)
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			if errors.Is(err, message.ErrUnknownType) {
+				return err
+			}
+			// The connection is closed by run once the settle period is over.
+			return nil
+		}
+		switch m := msg.(type) {
+		case *message.Ticket:
+			onTicket(m)
+		case *message.Error:
+			return fmt.Errorf("server error: %s", m.Msg)
+		default:
+			return fmt.Errorf("unexpected %s message", m.Type())
+		}
+	}
+}
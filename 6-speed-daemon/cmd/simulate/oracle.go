@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+)
+
+type (
+	// Oracle knows every observation sent to the server, and so every ticket the server must, may and must not send.
+	oracle struct {
+		// [plate][road]
+		seen map[string]map[uint16][]observation
+		// Violations of at least 0.5 mph over the limit. The server is required to ticket these, one per car per day.
+		required []violation
+	}
+
+	violation struct {
+		plate       string
+		road        uint16
+		first, last observation
+		speed       float64 // MPH
+		day1, day2  uint32
+	}
+
+	report struct {
+		Observations int
+		Violations   int
+		Received     int
+		// Violations the server could have ticketed without breaking the one-ticket-per-day rule, but didn't.
+		Missed []violation
+		// Tickets for a car on a day it was already ticketed for.
+		Duplicate []*message.Ticket
+		// Tickets that don't match a pair of observations, are for a car under the limit, or carry the wrong speed.
+		Wrong []wrongTicket
+		// Error messages sent by the server.
+		Errors []string
+	}
+
+	wrongTicket struct {
+		ticket *message.Ticket
+		reason string
+	}
+)
+
+func newOracle(seen map[camera][]observation) *oracle {
+	o := &oracle{seen: make(map[string]map[uint16][]observation)}
+	for _, obs := range seen {
+		for _, ob := range obs {
+			if o.seen[ob.plate] == nil {
+				o.seen[ob.plate] = make(map[uint16][]observation)
+			}
+			o.seen[ob.plate][ob.road] = append(o.seen[ob.plate][ob.road], ob)
+		}
+	}
+
+	// Every pair of observations on the same road counts, not just adjacent cameras.
+	for plate, roads := range o.seen {
+		for road, obs := range roads {
+			for i := range obs {
+				for j := i + 1; j < len(obs); j++ {
+					v, ok := newViolation(plate, road, obs[i], obs[j])
+					// Speeds over 655.35 mph overflow the ticket's speed field, so the server doesn't have to handle them.
+					if ok && v.speed >= float64(v.first.limit)+0.5 && v.speed*100 <= math.MaxUint16 {
+						o.required = append(o.required, v)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(o.required, func(i, j int) bool {
+		a, b := o.required[i], o.required[j]
+		if a.plate != b.plate {
+			return a.plate < b.plate
+		}
+		return a.first.timestamp < b.first.timestamp
+	})
+	return o
+}
+
+// NewViolation returns the average speed between two observations of a car. It reports false if the speed can't be calculated.
+func newViolation(plate string, road uint16, a, b observation) (violation, bool) {
+	if a.timestamp == b.timestamp {
+		return violation{}, false
+	}
+	if a.timestamp > b.timestamp {
+		a, b = b, a
+	}
+	miles := math.Abs(float64(a.mile) - float64(b.mile))
+	hours := float64(b.timestamp-a.timestamp) / 3600
+	return violation{
+		plate: plate,
+		road:  road,
+		first: a,
+		last:  b,
+		speed: miles / hours,
+		day1:  a.timestamp / 86400,
+		day2:  b.timestamp / 86400,
+	}, true
+}
+
+// Check compares the tickets received from the server with the expected violations.
+func (o *oracle) check(tickets []*message.Ticket) report {
+	rep := report{
+		Violations: len(o.required),
+		Received:   len(tickets),
+	}
+	for _, roads := range o.seen {
+		for _, obs := range roads {
+			rep.Observations += len(obs)
+		}
+	}
+
+	// [plate][day]
+	ticketed := make(map[string]map[uint32]bool)
+	for _, t := range tickets {
+		if reason := o.verify(t); reason != "" {
+			rep.Wrong = append(rep.Wrong, wrongTicket{ticket: t, reason: reason})
+			continue
+		}
+		days := ticketed[t.Plate]
+		if days == nil {
+			days = make(map[uint32]bool)
+			ticketed[t.Plate] = days
+		}
+		day1, day2 := uint32(t.Timestamp1)/86400, uint32(t.Timestamp2)/86400
+		duplicate := false
+		for d := day1; d <= day2; d++ {
+			duplicate = duplicate || days[d]
+			days[d] = true
+		}
+		if duplicate {
+			rep.Duplicate = append(rep.Duplicate, t)
+		}
+	}
+
+	// A violation is missed if none of its days were ticketed, since the server was free to send it. Count each missed violation as ticketed, so overlapping ones are only reported once.
+	for _, v := range o.required {
+		days := ticketed[v.plate]
+		if days == nil {
+			days = make(map[uint32]bool)
+			ticketed[v.plate] = days
+		}
+		covered := false
+		for d := v.day1; d <= v.day2; d++ {
+			covered = covered || days[d]
+		}
+		if covered {
+			continue
+		}
+		rep.Missed = append(rep.Missed, v)
+		for d := v.day1; d <= v.day2; d++ {
+			days[d] = true
+		}
+	}
+	return rep
+}
+
+// Verify returns why the ticket should not have been sent, or "" if it is a valid ticket.
+func (o *oracle) verify(t *message.Ticket) string {
+	if t.Timestamp1 > t.Timestamp2 {
+		return "timestamp1 is after timestamp2"
+	}
+	obs := o.seen[t.Plate][t.Road]
+	first, ok1 := find(obs, t.Mile1, uint32(t.Timestamp1))
+	last, ok2 := find(obs, t.Mile2, uint32(t.Timestamp2))
+	if !ok1 || !ok2 {
+		return "no matching observations"
+	}
+	v, ok := newViolation(t.Plate, t.Road, first, last)
+	if !ok {
+		return "observations at the same time"
+	}
+	if v.speed <= float64(first.limit) {
+		return fmt.Sprintf("average speed %.2f is not over the limit %d", v.speed, first.limit)
+	}
+	// The server may round the speed either way.
+	if math.Abs(float64(t.Speed)-v.speed*100) > 1 {
+		return fmt.Sprintf("speed %d, want %.0f", t.Speed, v.speed*100)
+	}
+	return ""
+}
+
+func find(obs []observation, mile uint16, timestamp uint32) (observation, bool) {
+	for _, ob := range obs {
+		if ob.mile == mile && ob.timestamp == timestamp {
+			return ob, true
+		}
+	}
+	return observation{}, false
+}
+
+func (r report) ok() bool {
+	return len(r.Missed) == 0 && len(r.Duplicate) == 0 && len(r.Wrong) == 0 && len(r.Errors) == 0
+}
+
+func (r report) print(w io.Writer) {
+	fmt.Fprintf(w, "observations: %d\nviolations:   %d\nreceived:     %d\nmissed:       %d\nduplicate:    %d\nwrong:        %d\nerrors:       %d\n",
+		r.Observations, r.Violations, r.Received, len(r.Missed), len(r.Duplicate), len(r.Wrong), len(r.Errors))
+	for _, v := range r.Missed {
+		fmt.Fprintf(w, "missed: plate %s road %d mile %d@%d -> mile %d@%d (%.2f mph)\n",
+			v.plate, v.road, v.first.mile, v.first.timestamp, v.last.mile, v.last.timestamp, v.speed)
+	}
+	for _, t := range r.Duplicate {
+		fmt.Fprintf(w, "duplicate: %+v\n", *t)
+	}
+	for _, wt := range r.Wrong {
+		fmt.Fprintf(w, "wrong: %+v: %s\n", *wt.ticket, wt.reason)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOracleCheck(t *testing.T) {
+	// UN1X drives 1 mile in 45 seconds (80 mph) on day 0 and again on day 1. RE4L stays under the limit.
+	seen := map[camera][]observation{
+		{road: 123, mile: 8, limit: 60}: {
+			{plate: "UN1X", road: 123, mile: 8, limit: 60, timestamp: 0},
+			{plate: "RE4L", road: 123, mile: 8, limit: 60, timestamp: 100},
+			{plate: "UN1X", road: 123, mile: 8, limit: 60, timestamp: 86400},
+		},
+		{road: 123, mile: 9, limit: 60}: {
+			{plate: "UN1X", road: 123, mile: 9, limit: 60, timestamp: 45},
+			{plate: "RE4L", road: 123, mile: 9, limit: 60, timestamp: 200},
+			{plate: "UN1X", road: 123, mile: 9, limit: 60, timestamp: 86445},
+		},
+	}
+	day0 := &message.Ticket{Plate: "UN1X", Road: 123, Mile1: 8, Timestamp1: 0, Mile2: 9, Timestamp2: 45, Speed: 8000}
+	day1 := &message.Ticket{Plate: "UN1X", Road: 123, Mile1: 8, Timestamp1: 86400, Mile2: 9, Timestamp2: 86445, Speed: 8000}
+	// Pairs the first trip's last camera with the second trip's first camera.
+	bothDays := &message.Ticket{Plate: "UN1X", Road: 123, Mile1: 9, Timestamp1: 45, Mile2: 8, Timestamp2: 86400, Speed: 0}
+
+	testCases := []struct {
+		name          string
+		tickets       []*message.Ticket
+		wantMissed    int
+		wantDuplicate int
+		wantWrong     int
+	}{
+		{name: "every violation ticketed", tickets: []*message.Ticket{day0, day1}},
+		{name: "missed day", tickets: []*message.Ticket{day0}, wantMissed: 1},
+		{name: "duplicate", tickets: []*message.Ticket{day0, day1, day0}, wantDuplicate: 1},
+		{
+			name: "under the limit",
+			tickets: []*message.Ticket{
+				day0, day1,
+				{Plate: "RE4L", Road: 123, Mile1: 8, Timestamp1: 100, Mile2: 9, Timestamp2: 200, Speed: 3600},
+			},
+			wantWrong: 1,
+		},
+		{
+			name:      "wrong speed",
+			tickets:   []*message.Ticket{{Plate: "UN1X", Road: 123, Mile1: 8, Timestamp1: 0, Mile2: 9, Timestamp2: 45, Speed: 7000}, day1},
+			wantWrong: 1,
+			// The wrong ticket doesn't count, so day 0 is missed too.
+			wantMissed: 1,
+		},
+		{
+			name:      "no such observations",
+			tickets:   []*message.Ticket{day0, day1, {Plate: "UN1X", Road: 124, Mile1: 8, Timestamp1: 0, Mile2: 9, Timestamp2: 45, Speed: 8000}},
+			wantWrong: 1,
+		},
+		{
+			// The car was under the limit between its two trips.
+			name:      "ticket between trips",
+			tickets:   []*message.Ticket{bothDays},
+			wantWrong: 1,
+			// Neither day is covered by a valid ticket.
+			wantMissed: 2,
+		},
+	}
+
+	o := newOracle(seen)
+	require.Len(t, o.required, 2)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rep := o.check(tc.tickets)
+			require.Len(t, rep.Missed, tc.wantMissed, "missed")
+			require.Len(t, rep.Duplicate, tc.wantDuplicate, "duplicate")
+			require.Len(t, rep.Wrong, tc.wantWrong, "wrong")
+			require.Equal(t, 6, rep.Observations)
+		})
+	}
+}
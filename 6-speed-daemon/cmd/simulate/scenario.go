@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+type (
+	// Scenario describes the road network and the traffic to drive over it.
+	Scenario struct {
+		Roads []RoadSpec `json:"roads"`
+		// Roads covered by each dispatcher. One connection is made per dispatcher.
+		Dispatchers [][]uint16 `json:"dispatchers"`
+		Cars        int        `json:"cars"`
+		TripsPerCar int        `json:"tripsPerCar"`
+		// Fraction of trips driven over the speed limit, from 0 to 1.
+		SpeedingRate float64 `json:"speedingRate"`
+		// Chance a camera fails to spot a passing car, from 0 to 1.
+		MissRate float64 `json:"missRate"`
+		// Timestamp of the earliest trip start.
+		Start uint32 `json:"start"`
+		// Each car starts its first trip at a random time within this many seconds of Start. Later trips follow at least an hour after the previous one ends.
+		Duration uint32 `json:"duration"`
+		// Seed for the random traffic. The same seed always produces the same observations.
+		Seed int64 `json:"seed"`
+	}
+
+	RoadSpec struct {
+		ID    uint16 `json:"id"`
+		Limit uint16 `json:"limit"` // Speed limit (MPH)
+		// Camera positions in miles from the start of the road.
+		Cameras []uint16 `json:"cameras"`
+	}
+
+	// Observation is a plate spotted by a camera.
+	observation struct {
+		plate     string
+		road      uint16
+		mile      uint16
+		limit     uint16
+		timestamp uint32
+	}
+
+	camera struct {
+		road, mile, limit uint16
+	}
+)
+
+func loadScenario(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	var sc Scenario
+	if err := json.NewDecoder(f).Decode(&sc); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if err := sc.validate(); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+func (sc *Scenario) validate() error {
+	if len(sc.Roads) == 0 {
+		return fmt.Errorf("scenario has no roads")
+	}
+	for _, r := range sc.Roads {
+		if len(r.Cameras) < 2 {
+			return fmt.Errorf("road %d: need at least 2 cameras", r.ID)
+		}
+		if r.Limit == 0 {
+			return fmt.Errorf("road %d: missing limit", r.ID)
+		}
+	}
+	if sc.SpeedingRate < 0 || sc.SpeedingRate > 1 {
+		return fmt.Errorf("speedingRate must be between 0 and 1")
+	}
+	if sc.MissRate < 0 || sc.MissRate > 1 {
+		return fmt.Errorf("missRate must be between 0 and 1")
+	}
+	return nil
+}
+
+// Generate drives every car over the roads and returns what each camera sees, ordered by timestamp.
+func (sc *Scenario) generate() map[camera][]observation {
+	rng := rand.New(rand.NewSource(sc.Seed))
+	seen := make(map[camera][]observation)
+
+	for i := 0; i < sc.Cars; i++ {
+		// Plates are uppercase alphanumeric. The index keeps them unique.
+		plate := fmt.Sprintf("%c%c%05d", 'A'+rng.Intn(26), 'A'+rng.Intn(26), i)
+		start := sc.Start
+		if sc.Duration > 0 {
+			start += uint32(rng.Int63n(int64(sc.Duration)))
+		}
+		for trip := 0; trip < sc.TripsPerCar; trip++ {
+			road := sc.Roads[rng.Intn(len(sc.Roads))]
+			limit := float64(road.Limit)
+			// Keep clear of the limit so rounding timestamps to whole seconds doesn't change which trips are speeding.
+			speed := limit * (0.5 + rng.Float64()*0.45)
+			if rng.Float64() < sc.SpeedingRate {
+				speed = limit*(1.05+rng.Float64()*0.5) + 1
+			}
+
+			miles := append([]uint16(nil), road.Cameras...)
+			sort.Slice(miles, func(i, j int) bool { return miles[i] < miles[j] })
+			if rng.Intn(2) == 0 {
+				// Drive the road in the other direction.
+				for l, r := 0, len(miles)-1; l < r; l, r = l+1, r-1 {
+					miles[l], miles[r] = miles[r], miles[l]
+				}
+			}
+
+			var ts uint32
+			for _, mile := range miles {
+				dist := math.Abs(float64(mile) - float64(miles[0]))
+				ts = start + uint32(math.Round(dist/speed*3600))
+				if rng.Float64() < sc.MissRate {
+					continue
+				}
+				cam := camera{road: road.ID, mile: mile, limit: road.Limit}
+				seen[cam] = append(seen[cam], observation{
+					plate:     plate,
+					road:      road.ID,
+					mile:      mile,
+					limit:     road.Limit,
+					timestamp: ts,
+				})
+			}
+			// Cars can't be in two places at once, so the next trip starts after this one ends.
+			start = ts + 3600 + uint32(rng.Intn(6*3600))
+		}
+	}
+
+	for _, obs := range seen {
+		sort.SliceStable(obs, func(i, j int) bool { return obs[i].timestamp < obs[j].timestamp })
+	}
+	return seen
+}
@@ -2,6 +2,7 @@ package spdaemon
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -12,53 +13,76 @@ type (
 	history struct {
 		mu sync.Mutex
 		// {[plate]: {
-		//		[( floor(ticket.Timestamp) / 264 )]: Ticket }
+		//		[floor(timestamp / 86400)]: Ticket }
 		// }
-		issued map[string]map[float64]*message.Ticket
+		// A ticket is stored under every day it covers.
+		issued map[string]map[uint32]*message.Ticket
+		// Issued tickets neither delivered nor dropped.
+		undelivered map[*message.Ticket]bool
 	}
 )
 
 func newHistory() *history {
 	return &history{
-		issued: make(map[string]map[float64]*message.Ticket),
+		issued:      make(map[string]map[uint32]*message.Ticket),
+		undelivered: make(map[*message.Ticket]bool),
 	}
 }
 
-func (h *history) add(t *message.Ticket) {
-	day1 := t.Timestamp1.Day()
-	day2 := t.Timestamp2.Day()
-
+func (h *history) Add(t *message.Ticket) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if _, ok := h.issued[t.Plate]; !ok {
-		h.issued[t.Plate] = make(map[float64]*message.Ticket)
+		h.issued[t.Plate] = make(map[uint32]*message.Ticket)
 	}
 
-	h.issued[t.Plate][day1] = t
-	if day1 != day2 {
-		h.issued[t.Plate][day2] = t
+	for day := t.Timestamp1.Day(); day <= t.Timestamp2.Day(); day++ {
+		h.issued[t.Plate][day] = t
 	}
+	h.undelivered[t] = true
+	return nil
 }
 
-func (h *history) lookupForDate(plate string, timestamp1, timestamp2 message.UnixTime) *message.Ticket {
+func (h *history) MarkDelivered(t *message.Ticket) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	day1 := timestamp1.Day()
-	day2 := timestamp2.Day()
-	for i := day1; i <= day2; i++ {
-		issuedDays, ok := h.issued[plate]
-		if !ok {
-			return nil
-		}
-		ticket, ok := issuedDays[i]
-		if ok {
-			return ticket
+	delete(h.undelivered, t)
+	return nil
+}
+
+func (h *history) MarkDropped(t *message.Ticket) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.undelivered, t)
+	return nil
+}
+
+func (h *history) Undelivered() ([]*message.Ticket, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tickets := make([]*message.Ticket, 0, len(h.undelivered))
+	for t := range h.undelivered {
+		tickets = append(tickets, t)
+	}
+	return tickets, nil
+}
+
+func (h *history) LookupForDate(plate string, timestamp1, timestamp2 message.UnixTime) (*message.Ticket, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	issuedDays, ok := h.issued[plate]
+	if !ok {
+		return nil, nil
+	}
+	for day := timestamp1.Day(); day <= timestamp2.Day(); day++ {
+		if ticket, ok := issuedDays[day]; ok {
+			return ticket, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
 
-func (h *history) printHistory(plate string) string {
+func (h *history) PrintHistory(plate string) string {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -68,8 +92,13 @@ func (h *history) printHistory(plate string) string {
 	}
 	var out strings.Builder
 	out.WriteString(fmt.Sprintf("** [%s] START **\n", plate))
-	for day, t := range tickets {
-		out.WriteString(fmt.Sprintf("Day: %f: %+v\n", day, t))
+	days := make([]uint32, 0, len(tickets))
+	for day := range tickets {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	for _, day := range days {
+		out.WriteString(fmt.Sprintf("Day: %d: %+v\n", day, tickets[day]))
 	}
 	out.WriteString(fmt.Sprintf("** [%s] END **\n", plate))
 	return out.String()
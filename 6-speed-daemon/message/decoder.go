@@ -0,0 +1,59 @@
+package message
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Decoder reads a stream of messages.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// PeekType returns the type of the next message without reading it.
+func (d *Decoder) PeekType() (MsgType, error) {
+	typ, err := d.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return ParseType(typ[0])
+}
+
+// Decode reads the next message from the stream. It returns io.EOF if the stream ends cleanly between messages, an error wrapping both ErrTruncated and io.ErrUnexpectedEOF if the stream ends part way through a message, and an error wrapping ErrUnknownType if the message type is not in the protocol. An unknown type byte is left unread.
+func (d *Decoder) Decode() (Message, error) {
+	t, err := d.PeekType()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := d.r.Peek(t.HeaderLen())
+	if err != nil {
+		return nil, d.truncated(t, err)
+	}
+	frame := make([]byte, t.Len(hdr))
+	if _, err := io.ReadFull(d.r, frame); err != nil {
+		return nil, d.truncated(t, err)
+	}
+
+	msg, err := New(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.UnmarshalBinary(frame); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (d *Decoder) truncated(t MsgType, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%s: %w: %w", t, ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("%s: %w", t, err)
+}
@@ -0,0 +1,81 @@
+package message_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/stretchr/testify/require"
+)
+
+// FuzzRoundTrip builds a message of every type from the fuzzed fields and checks that decoding its encoding returns the original message.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("UN1X", uint16(66), uint16(100), uint16(110), uint32(123456), uint32(123816), []byte{0x00, 0x42, 0x01, 0x70})
+	f.Add("", uint16(0), uint16(0), uint16(0), uint32(0), uint32(0), []byte{})
+	f.Add("RE05BKG", uint16(65535), uint16(65535), uint16(65535), uint32(4294967295), uint32(4294967295), []byte{0xff})
+
+	f.Fuzz(func(t *testing.T, str string, a, b, c uint16, ts1, ts2 uint32, roadBytes []byte) {
+		if len(str) > 255 {
+			str = str[:255]
+		}
+		roads := make([]uint16, 0, len(roadBytes)/2)
+		for i := 0; i+1 < len(roadBytes) && len(roads) < 255; i += 2 {
+			roads = append(roads, uint16(roadBytes[i])<<8|uint16(roadBytes[i+1]))
+		}
+
+		msgs := []message.Message{
+			&message.Error{Msg: str},
+			&message.Plate{Plate: str, Timestamp: time.Unix(int64(ts1), 0)},
+			&message.Ticket{Plate: str, Road: a, Mile1: b, Timestamp1: message.UnixTime(ts1), Mile2: c, Timestamp2: message.UnixTime(ts2), Speed: a ^ c},
+			&message.WantHeartbeat{Interval: time.Duration(ts1) * message.HeartbeatUnit},
+			&message.Heartbeat{},
+			&message.IAmCamera{Road: a, Mile: b, Limit: c},
+			&message.IAmDispatcher{Roads: roads},
+		}
+		for _, want := range msgs {
+			data := want.MarshalBinary()
+			require.Equal(t, byte(want.Type()), data[0])
+
+			got, err := message.New(want.Type())
+			require.NoError(t, err)
+			require.NoError(t, got.UnmarshalBinary(data), "%s: %x", want.Type(), data)
+			require.Equal(t, want, got)
+
+			dec := message.NewDecoder(bytes.NewReader(data))
+			got, err = dec.Decode()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		}
+	})
+}
+
+// FuzzDecode feeds arbitrary bytes to the Decoder. It must never panic, and every message it decodes must encode back to the bytes it was read from.
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c, 0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x03, 0xe8})
+	f.Add([]byte{0x81, 0x03, 0x00, 0x42, 0x01, 0x70, 0x13, 0x88, 0x40, 0x00, 0x00, 0x00, 0x0a})
+	f.Add([]byte{0x21, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x42, 0x00, 0x64, 0x00, 0x01, 0xe2, 0x40, 0x00, 0x6e, 0x00, 0x01, 0xe3, 0xa8, 0x27, 0x10})
+	f.Add([]byte{0x10, 0x03, 0x62, 0x61, 0x64, 0x41})
+	f.Add([]byte{0x81, 0xff})
+	f.Add([]byte{0x99})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := message.NewDecoder(bytes.NewReader(data))
+		var encoded []byte
+		for {
+			msg, err := dec.Decode()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					require.True(t, bytes.Equal(data, encoded), "got %x, want %x", encoded, data)
+				} else {
+					require.True(t, errors.Is(err, message.ErrTruncated) || errors.Is(err, message.ErrUnknownType), "unexpected error: %v", err)
+					require.True(t, bytes.HasPrefix(data, encoded))
+				}
+				return
+			}
+			encoded = append(encoded, msg.MarshalBinary()...)
+		}
+	})
+}
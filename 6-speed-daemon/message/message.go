@@ -2,6 +2,7 @@ package message
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -12,6 +13,13 @@ type (
 
 	UnixTime uint32
 
+	// Message is implemented by every message in the protocol. MarshalBinary and UnmarshalBinary work on complete frames, including the message type byte.
+	Message interface {
+		Type() MsgType
+		MarshalBinary() []byte
+		UnmarshalBinary(data []byte) error
+	}
+
 	Error struct {
 		Msg string
 	}
@@ -29,7 +37,7 @@ type (
 		Timestamp1 UnixTime // Earliest UNIX timestamp of the two observations
 		Timestamp2 UnixTime // Latest UNIX timestamp of the two observations
 		Speed      uint16   // Average speed of the car multiplied by 100
-		retries    int      // Ticket dispatch attempts
+		Limit      uint16   // Speed limit the car was ticketed against. Not sent on the wire.
 	}
 
 	WantHeartbeat struct {
@@ -45,8 +53,7 @@ type (
 	}
 
 	IAmDispatcher struct {
-		NumRoads uint8
-		Roads    []uint16
+		Roads []uint16
 	}
 )
 
@@ -58,23 +65,40 @@ const (
 	TypeHeartbeat     MsgType = 0x41 // (Server->Client)
 	TypeIAmCamera     MsgType = 0x80 // (Client->Server)
 	TypeIAmDispatcher MsgType = 0x81 // (Client->Server)
-	TypeWantMetrics   MsgType = 0x6D // "m"
+)
+
+// HeartbeatUnit is the unit of WantHeartbeat intervals on the wire.
+const HeartbeatUnit = 100 * time.Millisecond
+
+var (
+	// ErrUnknownType is returned for a message type byte that is not in the protocol.
+	ErrUnknownType = errors.New("unknown message type")
+	// ErrTypeMismatch is returned when a frame is decoded into a message of a different type.
+	ErrTypeMismatch = errors.New("message type mismatch")
+	// ErrTruncated is returned when a frame ends before all of its fields.
+	ErrTruncated = errors.New("truncated message")
+	// ErrOversized is returned when a frame has bytes left over after its last field.
+	ErrOversized = errors.New("oversized message")
 )
 
 // Len returns the expected length of the message of the given type. This includes 1 byte for the message type uint8 itself.
+// buf must hold at least HeaderLen bytes of the message.
 func (t MsgType) Len(buf []byte) int {
 	// Message type is the first byte of all messages
 	headerLen := 1
 	switch t {
+	case TypeError:
+		// msg: str +1 for str header
+		return headerLen + 1 + int(buf[headerLen])
 	case TypePlate:
 		// Read "plate" str len +1 for str header
-		plateLen := uint8(buf[headerLen]) + 1
+		plateLen := int(buf[headerLen]) + 1
 		timestampLen := 4
-		return headerLen + int(plateLen) + timestampLen
+		return headerLen + plateLen + timestampLen
 	case TypeTicket:
 		// plate: str +1 for str header
-		plateLen := uint8(buf[headerLen]) + 1
-		return headerLen + int(plateLen) +
+		plateLen := int(buf[headerLen]) + 1
+		return headerLen + plateLen +
 			// road: u16
 			2 +
 			// mile1: u16
@@ -107,6 +131,48 @@ func (t MsgType) Len(buf []byte) int {
 	return 0
 }
 
+// HeaderLen returns the number of bytes at the start of a message needed to calculate its length with Len.
+func (t MsgType) HeaderLen() int {
+	switch t {
+	case TypeError, TypePlate, TypeTicket, TypeIAmDispatcher:
+		// Type byte + str length or numroads
+		return 2
+	default:
+		return 1
+	}
+}
+
+// FromClient reports whether clients are allowed to send messages of this type. It is an error for a client to send a Server->Client message.
+func (t MsgType) FromClient() bool {
+	switch t {
+	case TypePlate, TypeWantHeartbeat, TypeIAmCamera, TypeIAmDispatcher:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t MsgType) String() string {
+	switch t {
+	case TypeError:
+		return "Error"
+	case TypePlate:
+		return "Plate"
+	case TypeTicket:
+		return "Ticket"
+	case TypeWantHeartbeat:
+		return "WantHeartbeat"
+	case TypeHeartbeat:
+		return "Heartbeat"
+	case TypeIAmCamera:
+		return "IAmCamera"
+	case TypeIAmDispatcher:
+		return "IAmDispatcher"
+	default:
+		return fmt.Sprintf("MsgType(0x%02x)", byte(t))
+	}
+}
+
 func ParseType(raw byte) (MsgType, error) {
 	switch raw {
 	case byte(TypeError):
@@ -123,18 +189,31 @@ func ParseType(raw byte) (MsgType, error) {
 		return TypeIAmCamera, nil
 	case byte(TypeIAmDispatcher):
 		return TypeIAmDispatcher, nil
-	case byte(TypeWantMetrics):
-		return TypeWantMetrics, nil
 	default:
-		return TypeError, fmt.Errorf("invalid message type: %x", raw)
+		return TypeError, fmt.Errorf("%w: %x", ErrUnknownType, raw)
 	}
 }
 
-// ParseTimestamp interprets a byte slice of of a uint32 as a UNIX timestamp.
-func parseTimestamp(data []byte) time.Time {
-	// Timestamps are exactly the same as Unix timestamps (counting seconds since 1st of January 1970), except that they are unsigned.
-	ts := binary.BigEndian.Uint32(data)
-	return time.Unix(int64(ts), 0)
+// New returns an empty message of the given type, ready to be unmarshalled into.
+func New(t MsgType) (Message, error) {
+	switch t {
+	case TypeError:
+		return &Error{}, nil
+	case TypePlate:
+		return &Plate{}, nil
+	case TypeTicket:
+		return &Ticket{}, nil
+	case TypeWantHeartbeat:
+		return &WantHeartbeat{}, nil
+	case TypeHeartbeat:
+		return &Heartbeat{}, nil
+	case TypeIAmCamera:
+		return &IAmCamera{}, nil
+	case TypeIAmDispatcher:
+		return &IAmDispatcher{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %x", ErrUnknownType, byte(t))
+	}
 }
 
 func (u UnixTime) Time() time.Time {
@@ -142,23 +221,51 @@ func (u UnixTime) Time() time.Time {
 }
 
 // Day converts the unix time to days since Jan 1, 1970 as defined by floor(timestamp / 86400).
-func (u UnixTime) Day() float64 {
-	return math.Floor(float64(u) / 86400)
+func (u UnixTime) Day() uint32 {
+	return uint32(u) / 86400
 }
 
-func (p *Plate) UnmarshalBinary(msg []byte) {
-	offset := 2 // msg type + data type (str) headers
-	plateLen := uint8(msg[1])
-	p.Plate = string(msg[offset : plateLen+uint8(offset)])
-	p.Timestamp = parseTimestamp(msg[2+plateLen:])
+func (e *Error) Type() MsgType         { return TypeError }
+func (p *Plate) Type() MsgType         { return TypePlate }
+func (t *Ticket) Type() MsgType        { return TypeTicket }
+func (w *WantHeartbeat) Type() MsgType { return TypeWantHeartbeat }
+func (h *Heartbeat) Type() MsgType     { return TypeHeartbeat }
+func (c *IAmCamera) Type() MsgType     { return TypeIAmCamera }
+func (d *IAmDispatcher) Type() MsgType { return TypeIAmDispatcher }
+
+func (e *Error) MarshalBinary() []byte {
+	data := make([]byte, 0, 2+len(e.Msg))
+	data = append(data, byte(TypeError))
+	return appendStr(data, e.Msg)
+}
+
+func (e *Error) UnmarshalBinary(data []byte) error {
+	r := newFieldReader(data, TypeError)
+	e.Msg = r.str()
+	return r.done()
+}
+
+func (p *Plate) MarshalBinary() []byte {
+	data := make([]byte, 0, 2+len(p.Plate)+4)
+	data = append(data, byte(TypePlate))
+	data = appendStr(data, p.Plate)
+	data = binary.BigEndian.AppendUint32(data, uint32(p.Timestamp.Unix()))
+	return data
+}
+
+func (p *Plate) UnmarshalBinary(data []byte) error {
+	r := newFieldReader(data, TypePlate)
+	p.Plate = r.str()
+	// Timestamps are exactly the same as Unix timestamps (counting seconds since 1st of January 1970), except that they are unsigned.
+	p.Timestamp = UnixTime(r.u32()).Time()
+	return r.done()
 }
 
 func (t *Ticket) MarshalBinary() []byte {
-	data := make([]byte, 0)
+	data := make([]byte, 0, 2+len(t.Plate)+16)
 	data = append(data, byte(TypeTicket))
 	// Plate
-	data = append(data, byte(len(t.Plate)))
-	data = append(data, []byte(t.Plate)...)
+	data = appendStr(data, t.Plate)
 
 	data = binary.BigEndian.AppendUint16(data, t.Road)
 	data = binary.BigEndian.AppendUint16(data, t.Mile1)
@@ -170,12 +277,163 @@ func (t *Ticket) MarshalBinary() []byte {
 	return data
 }
 
-// IncAttempts increments the ticket's retry counter.
-func (t *Ticket) IncAttempts() {
-	t.retries++
+func (t *Ticket) UnmarshalBinary(data []byte) error {
+	r := newFieldReader(data, TypeTicket)
+	t.Plate = r.str()
+	t.Road = r.u16()
+	t.Mile1 = r.u16()
+	t.Timestamp1 = UnixTime(r.u32())
+	t.Mile2 = r.u16()
+	t.Timestamp2 = UnixTime(r.u32())
+	t.Speed = r.u16()
+	return r.done()
+}
+
+// MarshalBinary encodes the interval in deciseconds, rounding down.
+func (w *WantHeartbeat) MarshalBinary() []byte {
+	data := make([]byte, 0, 5)
+	data = append(data, byte(TypeWantHeartbeat))
+	return binary.BigEndian.AppendUint32(data, uint32(w.Interval/HeartbeatUnit))
+}
+
+func (w *WantHeartbeat) UnmarshalBinary(data []byte) error {
+	r := newFieldReader(data, TypeWantHeartbeat)
+	w.Interval = time.Duration(r.u32()) * HeartbeatUnit
+	return r.done()
+}
+
+func (h *Heartbeat) MarshalBinary() []byte {
+	return []byte{byte(TypeHeartbeat)}
+}
+
+func (h *Heartbeat) UnmarshalBinary(data []byte) error {
+	return newFieldReader(data, TypeHeartbeat).done()
+}
+
+func (c *IAmCamera) MarshalBinary() []byte {
+	data := make([]byte, 0, 7)
+	data = append(data, byte(TypeIAmCamera))
+	data = binary.BigEndian.AppendUint16(data, c.Road)
+	data = binary.BigEndian.AppendUint16(data, c.Mile)
+	data = binary.BigEndian.AppendUint16(data, c.Limit)
+	return data
+}
+
+func (c *IAmCamera) UnmarshalBinary(data []byte) error {
+	r := newFieldReader(data, TypeIAmCamera)
+	// Fields are ORDERED in data
+	c.Road = r.u16()
+	c.Mile = r.u16()
+	// limit: u16 (miles per hour)
+	c.Limit = r.u16()
+	return r.done()
+}
+
+// MarshalBinary encodes the dispatcher's roads. Only the first 255 roads fit in a message.
+func (d *IAmDispatcher) MarshalBinary() []byte {
+	roads := d.Roads
+	if len(roads) > math.MaxUint8 {
+		roads = roads[:math.MaxUint8]
+	}
+	data := make([]byte, 0, 2+2*len(roads))
+	data = append(data, byte(TypeIAmDispatcher))
+	data = append(data, byte(len(roads)))
+	for _, road := range roads {
+		data = binary.BigEndian.AppendUint16(data, road)
+	}
+	return data
+}
+
+func (d *IAmDispatcher) UnmarshalBinary(data []byte) error {
+	r := newFieldReader(data, TypeIAmDispatcher)
+	numRoads := int(r.u8())
+	d.Roads = make([]uint16, 0, numRoads)
+	for i := 0; i < numRoads && r.err == nil; i++ {
+		d.Roads = append(d.Roads, r.u16())
+	}
+	return r.done()
+}
+
+// AppendStr appends s as a length-prefixed str. str fields are limited to 255 bytes, so longer strings are truncated.
+func appendStr(data []byte, s string) []byte {
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	data = append(data, byte(len(s)))
+	return append(data, s...)
+}
+
+// FieldReader reads the fields of a single frame in order. After the first error every read returns zero, so fields can be read without checking each one; done reports the error.
+type fieldReader struct {
+	data []byte
+	t    MsgType
+	off  int
+	err  error
+}
+
+func newFieldReader(data []byte, t MsgType) *fieldReader {
+	r := &fieldReader{data: data, t: t}
+	switch {
+	case len(data) == 0:
+		r.err = fmt.Errorf("%s: %w", t, ErrTruncated)
+	case MsgType(data[0]) != t:
+		r.err = fmt.Errorf("%s: %w: got %s", t, ErrTypeMismatch, MsgType(data[0]))
+	default:
+		r.off = 1
+	}
+	return r
+}
+
+// Next returns the next n bytes, or nil if there are not enough left.
+func (r *fieldReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data)-r.off < n {
+		r.err = fmt.Errorf("%s: %w: need %d bytes, have %d", r.t, ErrTruncated, r.off+n, len(r.data))
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *fieldReader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *fieldReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *fieldReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *fieldReader) str() string {
+	n := r.u8()
+	if b := r.next(int(n)); b != nil {
+		return string(b)
+	}
+	return ""
 }
 
-// Retries returns the ticket's retry count.
-func (t *Ticket) Retries() int {
-	return t.retries
+// Done returns the first error encountered, or ErrOversized if the frame has unread bytes.
+func (r *fieldReader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.off < len(r.data) {
+		return fmt.Errorf("%s: %w: %d bytes, want %d", r.t, ErrOversized, len(r.data), r.off)
+	}
+	return nil
 }
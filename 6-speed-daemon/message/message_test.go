@@ -1,7 +1,10 @@
 package message_test
 
 import (
+	"bytes"
+	"io"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/harveysanders/protohackers/6-speed-daemon/message"
@@ -45,7 +48,7 @@ func TestPlateUnmarshalBinary(t *testing.T) {
 
 	for _, tc := range testCases {
 		var got message.Plate
-		got.UnmarshalBinary(tc.data)
+		require.NoError(t, got.UnmarshalBinary(tc.data))
 		require.Equal(t, tc.want.Plate, got.Plate)
 		require.Equal(t, tc.want.Timestamp, got.Timestamp)
 
@@ -86,6 +89,186 @@ func TestTicketMarshalBinary(t *testing.T) {
 	for _, tc := range testCases {
 		got := tc.ticket.MarshalBinary()
 		require.Equal(t, tc.want, got)
+
+		var decoded message.Ticket
+		require.NoError(t, decoded.UnmarshalBinary(got))
+		require.Equal(t, tc.ticket, decoded)
+	}
+
+}
+
+func TestIAmCameraUnmarshalBinary(t *testing.T) {
+	testCases := []struct {
+		data []byte
+		want message.IAmCamera
+	}{
+		{
+			data: []byte{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c},
+			want: message.IAmCamera{Road: 66, Mile: 100, Limit: 60},
+		},
+		{
+			data: []byte{0x80, 0x01, 0x70, 0x04, 0xd2, 0x00, 0x28},
+			want: message.IAmCamera{Road: 368, Mile: 1234, Limit: 40},
+		},
+		{
+			data: []byte{0x80, 0x09, 0x4b, 0x23, 0x11, 0x00, 0x64},
+			want: message.IAmCamera{Road: 2379, Mile: 8977, Limit: 100},
+		},
+		{
+			data: []byte{0x80, 0x09, 0x4b, 0x23, 0x1b, 0x00, 0x64},
+			want: message.IAmCamera{Road: 2379, Mile: 8987, Limit: 100},
+		},
+	}
+
+	for _, tc := range testCases {
+		var got message.IAmCamera
+		require.NoError(t, got.UnmarshalBinary(tc.data))
+		require.Equal(t, tc.want, got)
+	}
+}
+
+func TestIAmDispatcherUnmarshalBinary(t *testing.T) {
+	testCases := []struct {
+		data []byte
+		want message.IAmDispatcher
+	}{
+		{
+			data: []byte{0x81, 0x01, 0x00, 0x42},
+			want: message.IAmDispatcher{Roads: []uint16{66}},
+		},
+		{
+			data: []byte{0x81, 0x03, 0x00, 0x42, 0x01, 0x70, 0x13, 0x88},
+			want: message.IAmDispatcher{Roads: []uint16{66, 368, 5000}},
+		},
+	}
+
+	for _, tc := range testCases {
+		var got message.IAmDispatcher
+		require.NoError(t, got.UnmarshalBinary(tc.data))
+		require.Equal(t, tc.want, got)
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		msg     message.Message
+		data    []byte
+		wantErr error
+	}{
+		{name: "empty", msg: &message.Heartbeat{}, data: []byte{}, wantErr: message.ErrTruncated},
+		{name: "wrong type", msg: &message.Plate{}, data: []byte{0x21, 0x00}, wantErr: message.ErrTypeMismatch},
+		{name: "plate shorter than its length", msg: &message.Plate{}, data: []byte{0x20, 0x04, 0x55, 0x4e}, wantErr: message.ErrTruncated},
+		{name: "plate missing timestamp", msg: &message.Plate{}, data: []byte{0x20, 0x01, 0x55, 0x00, 0x00}, wantErr: message.ErrTruncated},
+		{name: "dispatcher missing roads", msg: &message.IAmDispatcher{}, data: []byte{0x81, 0x02, 0x00, 0x42}, wantErr: message.ErrTruncated},
+		{name: "camera with trailing byte", msg: &message.IAmCamera{}, data: []byte{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c, 0x00}, wantErr: message.ErrOversized},
+		{name: "heartbeat with payload", msg: &message.Heartbeat{}, data: []byte{0x41, 0x00}, wantErr: message.ErrOversized},
+		{name: "want heartbeat short", msg: &message.WantHeartbeat{}, data: []byte{0x40, 0x00, 0x00, 0x0a}, wantErr: message.ErrTruncated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.ErrorIs(t, tc.msg.UnmarshalBinary(tc.data), tc.wantErr)
+		})
 	}
+}
+
+func TestDecoder(t *testing.T) {
+	t.Run("decodes a stream of messages", func(t *testing.T) {
+		want := []message.Message{
+			&message.IAmCamera{Road: 66, Mile: 100, Limit: 60},
+			&message.WantHeartbeat{Interval: 2500 * time.Millisecond},
+			&message.Plate{Plate: "UN1X", Timestamp: time.Unix(1000, 0)},
+			&message.IAmDispatcher{Roads: []uint16{66, 368}},
+			&message.Ticket{Plate: "UN1X", Road: 66, Mile1: 100, Timestamp1: 123456, Mile2: 110, Timestamp2: 123816, Speed: 10_000},
+			&message.Heartbeat{},
+			&message.Error{Msg: "illegal msg"},
+		}
+		var stream []byte
+		for _, m := range want {
+			stream = append(stream, m.MarshalBinary()...)
+		}
+
+		// Deliver the stream a byte at a time to check messages split across reads.
+		dec := message.NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+		for _, w := range want {
+			got, err := dec.Decode()
+			require.NoError(t, err)
+			require.Equal(t, w, got)
+		}
+		_, err := dec.Decode()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("stream ends part way through a message", func(t *testing.T) {
+		dec := message.NewDecoder(bytes.NewReader([]byte{0x20, 0x04, 0x55, 0x4e}))
+		_, err := dec.Decode()
+		require.ErrorIs(t, err, message.ErrTruncated)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("stream ends after the type byte", func(t *testing.T) {
+		dec := message.NewDecoder(bytes.NewReader([]byte{0x81}))
+		_, err := dec.Decode()
+		require.ErrorIs(t, err, message.ErrTruncated)
+	})
 
+	t.Run("unknown message type", func(t *testing.T) {
+		dec := message.NewDecoder(bytes.NewReader([]byte{0x99}))
+		_, err := dec.PeekType()
+		require.ErrorIs(t, err, message.ErrUnknownType)
+		_, err = dec.Decode()
+		require.ErrorIs(t, err, message.ErrUnknownType)
+	})
+}
+
+func TestClientMarshalBinary(t *testing.T) {
+	testCases := []struct {
+		name string
+		msg  interface{ MarshalBinary() []byte }
+		want []byte
+	}{
+		{
+			name: "Plate",
+			msg:  &message.Plate{Plate: "UN1X", Timestamp: time.Unix(1000, 0)},
+			want: []byte{0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x03, 0xe8},
+		},
+		{
+			name: "IAmCamera",
+			msg:  &message.IAmCamera{Road: 66, Mile: 100, Limit: 60},
+			want: []byte{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c},
+		},
+		{
+			name: "IAmDispatcher",
+			msg:  &message.IAmDispatcher{Roads: []uint16{66, 368, 5000}},
+			want: []byte{0x81, 0x03, 0x00, 0x42, 0x01, 0x70, 0x13, 0x88},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.msg.MarshalBinary())
+		})
+	}
+}
+
+func TestErrorMarshalBinary(t *testing.T) {
+	testCases := []struct {
+		err  message.Error
+		want []byte
+	}{
+		{
+			err:  message.Error{Msg: "bad"},
+			want: []byte{0x10, 0x03, 0x62, 0x61, 0x64},
+		},
+		{
+			err:  message.Error{Msg: "illegal msg"},
+			want: []byte{0x10, 0x0b, 0x69, 0x6c, 0x6c, 0x65, 0x67, 0x61, 0x6c, 0x20, 0x6d, 0x73, 0x67},
+		},
+	}
+
+	for _, tc := range testCases {
+		got := tc.err.MarshalBinary()
+		require.Equal(t, tc.want, got)
+	}
 }
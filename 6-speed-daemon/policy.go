@@ -0,0 +1,145 @@
+package spdaemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+type (
+	// Policies are the enforcement policies for the road network, loaded from a config file at startup.
+	// Ex:
+	//
+	//	{
+	//	  "default": {"tolerance": 5},
+	//	  "roads": {
+	//	    "123": {
+	//	      "minGap": 30,
+	//	      "schoolZones": [{"start": "07:30", "end": "09:00", "limit": 20}]
+	//	    },
+	//	    "456": {"warnOnly": true}
+	//	  }
+	//	}
+	Policies struct {
+		// Default applies to roads without their own policy.
+		Default Policy            `json:"default"`
+		Roads   map[uint16]Policy `json:"roads"` // [road ID]
+	}
+
+	// Policy is how the speed limit is enforced on a road. The zero Policy tickets every car averaging 0.5 mph or more over the camera's limit.
+	Policy struct {
+		// Tolerance is how far over the limit a car may average, as a percentage of the limit, before it is ticketed. A car 0.5 mph or more over the limit is always ticketed if that is more than the tolerance allows.
+		Tolerance float64 `json:"tolerance"`
+		// MinGap is the fewest seconds between two observations for them to be used to calculate a speed. Closer observations are too sensitive to camera clock drift.
+		MinGap uint32 `json:"minGap"`
+		// SchoolZones are daily windows with a lower speed limit.
+		SchoolZones []SchoolZone `json:"schoolZones"`
+		// WarnOnly roads log violations without ticketing them.
+		WarnOnly bool `json:"warnOnly"`
+	}
+
+	// SchoolZone lowers the speed limit between Start and End every day. A car is held to the lower limit if it was seen by either camera during the window.
+	SchoolZone struct {
+		Start TimeOfDay `json:"start"`
+		End   TimeOfDay `json:"end"`
+		Limit uint16    `json:"limit"`
+	}
+
+	// TimeOfDay is the number of seconds since midnight UTC. It is written as "HH:MM" in config files.
+	TimeOfDay uint32
+)
+
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// LoadPolicies reads and validates the enforcement policies in the JSON file at path.
+func LoadPolicies(path string) (Policies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Policies{}, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	var p Policies
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Policies{}, fmt.Errorf("decode: %w", err)
+	}
+	if err := p.Default.validate(); err != nil {
+		return Policies{}, fmt.Errorf("default: %w", err)
+	}
+	for id, rp := range p.Roads {
+		if err := rp.validate(); err != nil {
+			return Policies{}, fmt.Errorf("road %d: %w", id, err)
+		}
+	}
+	return p, nil
+}
+
+// For returns the policy for the road.
+func (p Policies) For(roadID uint16) Policy {
+	if rp, ok := p.Roads[roadID]; ok {
+		return rp
+	}
+	return p.Default
+}
+
+func (p Policy) validate() error {
+	if p.Tolerance < 0 || math.IsNaN(p.Tolerance) {
+		return fmt.Errorf("%w: negative tolerance %v", ErrInvalidPolicy, p.Tolerance)
+	}
+	for i, z := range p.SchoolZones {
+		if z.End <= z.Start {
+			return fmt.Errorf("%w: school zone %d ends at %s, before it starts at %s", ErrInvalidPolicy, i, z.End, z.Start)
+		}
+		if z.Limit == 0 {
+			return fmt.Errorf("%w: school zone %d has no limit", ErrInvalidPolicy, i)
+		}
+	}
+	return nil
+}
+
+// Limit returns the speed limit for a car seen at t1 and t2, given the camera's limit. It is the lowest limit of any school zone either observation falls in.
+func (p Policy) limit(t1, t2 time.Time, camLimit uint16) uint16 {
+	limit := camLimit
+	for _, z := range p.SchoolZones {
+		if (z.contains(t1) || z.contains(t2)) && z.Limit < limit {
+			limit = z.Limit
+		}
+	}
+	return limit
+}
+
+// Threshold returns the lowest average speed ticketed under the limit.
+func (p Policy) threshold(limit uint16) float64 {
+	return math.Max(float64(limit)+0.5, float64(limit)*(1+p.Tolerance/100))
+}
+
+func (z SchoolZone) contains(t time.Time) bool {
+	tod := TimeOfDay(t.Unix() % 86400)
+	return z.Start <= tod && tod < z.End
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t/3600, t%3600/60)
+}
+
+func (t TimeOfDay) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *TimeOfDay) UnmarshalText(text []byte) error {
+	var h, m uint32
+	if _, err := fmt.Sscanf(string(text), "%02d:%02d", &h, &m); err != nil {
+		return fmt.Errorf("time of day %q: %w", text, err)
+	}
+	// 24:00 is allowed, to end a window at midnight.
+	if m > 59 || h > 24 || (h == 24 && m > 0) {
+		return fmt.Errorf("time of day %q: out of range", text)
+	}
+	*t = TimeOfDay(h*3600 + m*60)
+	return nil
+}
@@ -0,0 +1,170 @@
+package spdaemon
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicies(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  string
+		want    Policies
+		wantErr bool
+	}{
+		{
+			name: "default and per road",
+			config: `{
+  "default": {"tolerance": 5},
+  "roads": {
+    "123": {"minGap": 30, "schoolZones": [{"start": "07:30", "end": "09:00", "limit": 20}]},
+    "456": {"warnOnly": true}
+  }
+}`,
+			want: Policies{
+				Default: Policy{Tolerance: 5},
+				Roads: map[uint16]Policy{
+					123: {MinGap: 30, SchoolZones: []SchoolZone{{Start: 7*3600 + 30*60, End: 9 * 3600, Limit: 20}}},
+					456: {WarnOnly: true},
+				},
+			},
+		},
+		{name: "zone ends at midnight", config: `{"default": {"schoolZones": [{"start": "23:00", "end": "24:00", "limit": 20}]}}`,
+			want: Policies{Default: Policy{SchoolZones: []SchoolZone{{Start: 23 * 3600, End: 24 * 3600, Limit: 20}}}}},
+		{name: "negative tolerance", config: `{"roads": {"1": {"tolerance": -1}}}`, wantErr: true},
+		{name: "zone ends before it starts", config: `{"default": {"schoolZones": [{"start": "09:00", "end": "07:30", "limit": 20}]}}`, wantErr: true},
+		{name: "zone without a limit", config: `{"default": {"schoolZones": [{"start": "07:30", "end": "09:00"}]}}`, wantErr: true},
+		{name: "bad time of day", config: `{"default": {"schoolZones": [{"start": "07:60", "end": "09:00", "limit": 20}]}}`, wantErr: true},
+		{name: "unknown field", config: `{"default": {"tolerence": 5}}`, wantErr: true},
+		{name: "bad road ID", config: `{"roads": {"70000": {}}}`, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policies.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.config), 0o644))
+
+			got, err := LoadPolicies(path)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Morning school run, 07:30 to 09:00 UTC.
+	school := SchoolZone{Start: 7*3600 + 30*60, End: 9 * 3600, Limit: 20}
+	day := int64(86400 * 10)
+	// Seen at mile 8 at t1, then mile 9 at t2. 1 mile in 45 seconds is 80 mph.
+	testCases := []struct {
+		name      string
+		policy    Policy
+		t1, t2    int64
+		wantLimit uint16 // 0 for no ticket
+		wantSpeed uint16
+	}{
+		{name: "no policy", t1: day, t2: day + 45, wantLimit: 60, wantSpeed: 8000},
+		{name: "within tolerance", policy: Policy{Tolerance: 50}, t1: day, t2: day + 45},
+		{name: "over tolerance", policy: Policy{Tolerance: 25}, t1: day, t2: day + 45, wantLimit: 60, wantSpeed: 8000},
+		// 1 mile in 59 seconds is 61.02 mph, just over a 1% tolerance.
+		{name: "small tolerance", policy: Policy{Tolerance: 1}, t1: day, t2: day + 59, wantLimit: 60, wantSpeed: 6101},
+		{name: "under minimum gap", policy: Policy{MinGap: 60}, t1: day, t2: day + 45},
+		{name: "over minimum gap", policy: Policy{MinGap: 30}, t1: day, t2: day + 45, wantLimit: 60, wantSpeed: 8000},
+		// 1 mile in 3 minutes is 20 mph. Within the school zone, 1 mile in 2 minutes (30 mph) is ticketed.
+		{name: "school zone", policy: Policy{SchoolZones: []SchoolZone{school}}, t1: day + 8*3600, t2: day + 8*3600 + 120, wantLimit: 20, wantSpeed: 3000},
+		{name: "under school zone limit", policy: Policy{SchoolZones: []SchoolZone{school}}, t1: day + 8*3600, t2: day + 8*3600 + 180},
+		{name: "enters school zone", policy: Policy{SchoolZones: []SchoolZone{school}}, t1: day + 7*3600 + 29*60, t2: day + 7*3600 + 31*60, wantLimit: 20, wantSpeed: 3000},
+		{name: "outside school zone", policy: Policy{SchoolZones: []SchoolZone{school}}, t1: day + 9*3600, t2: day + 9*3600 + 120},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o1 := observation{plate: "UN1X", mile: 8, timestamp: time.Unix(tc.t1, 0)}
+			o2 := observation{plate: "UN1X", mile: 9, timestamp: time.Unix(tc.t2, 0)}
+			got := checkPair(o1, o2, 60, tc.policy)
+			if tc.wantLimit == 0 {
+				require.Nil(t, got)
+				return
+			}
+			require.Equal(t, &message.Ticket{
+				Plate:      "UN1X",
+				Mile1:      8,
+				Timestamp1: message.UnixTime(tc.t1),
+				Mile2:      9,
+				Timestamp2: message.UnixTime(tc.t2),
+				Speed:      tc.wantSpeed,
+				Limit:      tc.wantLimit,
+			}, got)
+		})
+	}
+
+	t.Run("pairs with the nearest observation past the minimum gap", func(t *testing.T) {
+		// A second camera at mile 8 sees the car 10 seconds later, too close to compare with mile 9.
+		obs := []*observation{
+			{plate: "UN1X", mile: 8, timestamp: time.Unix(day, 0)},
+			{plate: "UN1X", mile: 8, timestamp: time.Unix(day+35, 0)},
+			{plate: "UN1X", mile: 9, timestamp: time.Unix(day+45, 0)},
+		}
+		got := checkViolations(obs, 2, 60, Policy{MinGap: 30})
+		require.Len(t, got, 1)
+		require.Equal(t, message.UnixTime(day), got[0].Timestamp1)
+	})
+
+	t.Run("pairs past the nearest observation outside the minimum gap", func(t *testing.T) {
+		// The camera at mile 9 sees the car again 35 seconds later, but it went a mile in the 45 seconds since mile 8.
+		obs := []*observation{
+			{plate: "UN1X", mile: 8, timestamp: time.Unix(day, 0)},
+			{plate: "UN1X", mile: 9, timestamp: time.Unix(day+10, 0)},
+			{plate: "UN1X", mile: 9, timestamp: time.Unix(day+45, 0)},
+		}
+		got := checkViolations(obs, 2, 60, Policy{MinGap: 30})
+		require.Len(t, got, 1)
+		require.Equal(t, message.UnixTime(day), got[0].Timestamp1)
+		require.EqualValues(t, 8000, got[0].Speed)
+	})
+
+	t.Run("school zone limit applies past the nearest observation", func(t *testing.T) {
+		// 25 miles in 90 minutes, then 25 miles in 30 minutes, are both under their limits. From mile 0, in the school zone, that is 50 miles in 2 hours.
+		obs := []*observation{
+			{plate: "UN1X", mile: 0, timestamp: time.Unix(day+8*3600+30*60, 0)},
+			{plate: "UN1X", mile: 25, timestamp: time.Unix(day+10*3600, 0)},
+			{plate: "UN1X", mile: 50, timestamp: time.Unix(day+10*3600+30*60, 0)},
+		}
+		got := checkViolations(obs, 2, 60, Policy{SchoolZones: []SchoolZone{school}})
+		require.Len(t, got, 1)
+		require.Equal(t, message.UnixTime(day+8*3600+30*60), got[0].Timestamp1)
+		require.EqualValues(t, 2500, got[0].Speed)
+		require.EqualValues(t, 20, got[0].Limit)
+	})
+
+	t.Run("warn only roads do not ticket", func(t *testing.T) {
+		s := NewServer(WithPolicies(Policies{Roads: map[uint16]Policy{1: {WarnOnly: true}}}))
+		ctx := context.WithValue(context.Background(), ctxKey(CONNECTION_ID), "test")
+		for _, cam := range []Camera{{Road: 1, Limit: 60}, {Road: 2, Limit: 60}} {
+			cam.Mile = 8
+			s.handlePlate(ctx, &message.Plate{Plate: "UN1X", Timestamp: time.Unix(day, 0)}, cam)
+			cam.Mile = 9
+			s.handlePlate(ctx, &message.Plate{Plate: "UN1X", Timestamp: time.Unix(day+45, 0)}, cam)
+		}
+
+		require.Empty(t, s.road(1).outbox)
+		require.Len(t, s.road(2).outbox, 1)
+		require.EqualValues(t, 1, s.metrics.Tickets.Warned.Load())
+		require.EqualValues(t, 1, s.metrics.Tickets.Issued.Load())
+	})
+}
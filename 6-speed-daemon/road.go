@@ -0,0 +1,205 @@
+package spdaemon
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+)
+
+type (
+	// Road holds the state for a single road. Each road has its own lock, so traffic on one road never waits on another.
+	road struct {
+		id    uint16
+		mu    sync.Mutex
+		limit uint16
+		// How the limit is enforced on the road.
+		policy Policy
+		// Observations of each plate, ordered by timestamp.
+		plates      map[string][]*observation // [plate]
+		cameras     map[*Camera]bool
+		dispatchers map[*TicketDispatcher]bool
+		// Tickets waiting to be sent to a dispatcher, oldest first. There is no size or age limit; a ticket leaves the outbox only when it is written to a dispatcher or an operator drops it.
+		outbox []*message.Ticket
+		// Called once a ticket is written to a dispatcher.
+		delivered func(*message.Ticket)
+	}
+)
+
+func newRoad(id uint16, policy Policy, delivered func(*message.Ticket)) *road {
+	return &road{
+		id:          id,
+		policy:      policy,
+		plates:      make(map[string][]*observation),
+		cameras:     make(map[*Camera]bool),
+		dispatchers: make(map[*TicketDispatcher]bool),
+		delivered:   delivered,
+	}
+}
+
+// Restore records a previously stored observation without checking it for violations.
+func (r *road) restore(obs observation, limit uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limit = limit
+	r.insert(obs)
+}
+
+// Observe records the observation and checks it against the plate's other observations on the road, as checkViolations describes. Observations can arrive out of order, so a new one may fall between two earlier ones. It returns a candidate ticket for each pair the car was speeding between, ordered by time, and whether this is the first time the plate was seen on the road.
+func (r *road) observe(obs observation, limit uint16) (candidates []*message.Ticket, firstSighting bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limit = limit
+	_, seen := r.plates[obs.plate]
+	i := r.insert(obs)
+
+	candidates = checkViolations(r.plates[obs.plate], i, r.limit, r.policy)
+	for _, t := range candidates {
+		t.Road = r.id
+	}
+	return candidates, !seen
+}
+
+// Insert adds the observation to the plate's observations, keeping them ordered by timestamp, and returns its index. The caller must hold r.mu.
+func (r *road) insert(obs observation) int {
+	past := r.plates[obs.plate]
+	i := sort.Search(len(past), func(j int) bool { return past[j].timestamp.After(obs.timestamp) })
+	r.plates[obs.plate] = slices.Insert(past, i, &obs)
+	return i
+}
+
+// Dispatch puts the ticket in the road's outbox and sends it to a dispatcher, if the road has one.
+func (r *road) dispatch(t *message.Ticket) {
+	r.hold(t)
+	r.flush()
+}
+
+// Flush sends the outbox to the road's dispatchers, oldest ticket first. A ticket written without error is delivered, and is never sent again. If a write fails, the dispatcher is dropped from the road and the ticket goes back to the front of the outbox, for the next one. Tickets stay in the outbox once there are no dispatchers left.
+func (r *road) flush() {
+	for {
+		r.mu.Lock()
+		td := r.anyDispatcher()
+		if td == nil || len(r.outbox) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		t := r.outbox[0]
+		r.outbox = r.outbox[1:]
+		r.mu.Unlock()
+
+		// Don't hold the lock during network writes.
+		if err := td.send(t); err != nil {
+			log.Printf("[road %d] send ticket: %v", r.id, err)
+			r.mu.Lock()
+			delete(r.dispatchers, td)
+			r.outbox = append([]*message.Ticket{t}, r.outbox...)
+			r.mu.Unlock()
+			continue
+		}
+		r.delivered(t)
+	}
+}
+
+func (r *road) addCamera(c *Camera) {
+	r.mu.Lock()
+	r.cameras[c] = true
+	r.mu.Unlock()
+}
+
+func (r *road) removeCamera(c *Camera) {
+	r.mu.Lock()
+	delete(r.cameras, c)
+	r.mu.Unlock()
+}
+
+// AddDispatcher registers the dispatcher for the road and sends it any held tickets.
+func (r *road) addDispatcher(td *TicketDispatcher) {
+	r.mu.Lock()
+	r.dispatchers[td] = true
+	r.mu.Unlock()
+	r.flush()
+}
+
+// RemoveDispatcher unregisters the dispatcher.
+func (r *road) removeDispatcher(td *TicketDispatcher) {
+	r.mu.Lock()
+	delete(r.dispatchers, td)
+	r.mu.Unlock()
+}
+
+// Hold puts the tickets in the outbox without sending them.
+func (r *road) hold(tickets ...*message.Ticket) {
+	r.mu.Lock()
+	r.outbox = append(r.outbox, tickets...)
+	r.mu.Unlock()
+}
+
+// Drop removes the plate's tickets from the outbox and returns them. An empty plate drops every held ticket.
+func (r *road) drop(plate string) []*message.Ticket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dropped []*message.Ticket
+	kept := r.outbox[:0]
+	for _, t := range r.outbox {
+		if plate == "" || t.Plate == plate {
+			dropped = append(dropped, t)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	r.outbox = kept
+	return dropped
+}
+
+// OutboxDepth returns the number of tickets waiting for a dispatcher.
+func (r *road) outboxDepth() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.outbox)
+}
+
+// Status returns a snapshot of the road's connected clients and undelivered tickets.
+func (r *road) status() roadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st := roadStatus{
+		Road:        r.id,
+		Limit:       r.limit,
+		Cameras:     make([]cameraStatus, 0, len(r.cameras)),
+		Dispatchers: make([]string, 0, len(r.dispatchers)),
+		Outbox:      len(r.outbox),
+	}
+	for c := range r.cameras {
+		st.Cameras = append(st.Cameras, cameraStatus{Mile: c.Mile, Limit: c.Limit})
+	}
+	sort.Slice(st.Cameras, func(i, j int) bool { return st.Cameras[i].Mile < st.Cameras[j].Mile })
+	for td := range r.dispatchers {
+		st.Dispatchers = append(st.Dispatchers, td.w.conn.RemoteAddr().String())
+	}
+	sort.Strings(st.Dispatchers)
+	return st
+}
+
+// Pending returns the tickets waiting for a dispatcher, oldest first.
+func (r *road) pending() []pendingTicket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tickets := make([]pendingTicket, 0, len(r.outbox))
+	for _, t := range r.outbox {
+		tickets = append(tickets, newPendingTicket(t))
+	}
+	return tickets
+}
+
+// AnyDispatcher returns one of the road's dispatchers, or nil if there are none. The caller must hold r.mu.
+func (r *road) anyDispatcher() *TicketDispatcher {
+	for td := range r.dispatchers {
+		return td
+	}
+	return nil
+}
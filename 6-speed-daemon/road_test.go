@@ -0,0 +1,183 @@
+package spdaemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/stretchr/testify/require"
+)
+
+// BenchmarkHandlePlate reports plates from 10k cameras spread across 1k roads, each with a dispatcher.
+func BenchmarkHandlePlate(b *testing.B) {
+	const (
+		numRoads   = 1_000
+		numCameras = 10_000
+		numPlates  = 5_000
+	)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewServer()
+	ctx := context.WithValue(context.Background(), ctxKey(CONNECTION_ID), "bench")
+
+	for rid := uint16(0); rid < numRoads; rid++ {
+		server, client := net.Pipe()
+		go io.Copy(io.Discard, client)
+		defer server.Close()
+
+		td := &TicketDispatcher{Roads: []uint16{rid}, w: newConnWriter(server, realClock{}, 0)}
+		s.registerDispatcher(ctx, td)
+	}
+
+	cameras := make([]Camera, numCameras)
+	for i := range cameras {
+		cameras[i] = Camera{Road: uint16(i % numRoads), Mile: uint16(i / numRoads * 10), Limit: 60}
+	}
+	plates := make([]string, numPlates)
+	for i := range plates {
+		plates[i] = fmt.Sprintf("PL%05d", i)
+	}
+
+	var n atomic.Uint32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			// Cars take about 10 minutes between cameras.
+			p := &message.Plate{Plate: plates[i%numPlates], Timestamp: time.Unix(int64(i/numPlates*600), 0)}
+			s.handlePlate(ctx, p, cameras[i%numCameras])
+		}
+	})
+}
+
+func TestRoadOutbox(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ticket := func(plate string, ts message.UnixTime) *message.Ticket {
+		return &message.Ticket{Plate: plate, Road: 1, Mile1: 8, Timestamp1: ts, Mile2: 9, Timestamp2: ts + 45, Speed: 8000}
+	}
+
+	// dispatcher returns a dispatcher and the client end of its connection.
+	dispatcher := func(t *testing.T) (*TicketDispatcher, net.Conn) {
+		server, client := net.Pipe()
+		t.Cleanup(func() {
+			server.Close()
+			client.Close()
+		})
+		return &TicketDispatcher{Roads: []uint16{1}, w: newConnWriter(server, realClock{}, 0)}, client
+	}
+
+	// requireTickets reads the tickets from the dispatcher's connection.
+	requireTickets := func(t *testing.T, conn net.Conn, want ...*message.Ticket) {
+		var wantBytes []byte
+		for _, tk := range want {
+			wantBytes = append(wantBytes, tk.MarshalBinary()...)
+		}
+		got := make([]byte, len(wantBytes))
+		_, err := io.ReadFull(conn, got)
+		require.NoError(t, err)
+		require.Equal(t, wantBytes, got)
+	}
+
+	t.Run("holds tickets until a dispatcher registers", func(t *testing.T) {
+		var delivered []*message.Ticket
+		r := newRoad(1, Policy{}, func(t *message.Ticket) { delivered = append(delivered, t) })
+		t1, t2 := ticket("UN1X", 0), ticket("RE4L", 100)
+		r.dispatch(t1)
+		r.dispatch(t2)
+		require.Equal(t, 2, r.outboxDepth())
+
+		td, conn := dispatcher(t)
+		done := make(chan struct{})
+		go func() {
+			r.addDispatcher(td)
+			close(done)
+		}()
+		requireTickets(t, conn, t1, t2)
+		<-done
+		require.Equal(t, []*message.Ticket{t1, t2}, delivered)
+		require.Zero(t, r.outboxDepth())
+	})
+
+	t.Run("redelivers to the next dispatcher when a write fails", func(t *testing.T) {
+		r := newRoad(1, Policy{}, func(*message.Ticket) {})
+		broken, brokenConn := dispatcher(t)
+		brokenConn.Close()
+		r.addDispatcher(broken)
+
+		// The only dispatcher is broken, so the ticket is held.
+		t1 := ticket("UN1X", 0)
+		r.dispatch(t1)
+		require.Equal(t, 1, r.outboxDepth())
+
+		td, conn := dispatcher(t)
+		go r.addDispatcher(td)
+		requireTickets(t, conn, t1)
+		require.Eventually(t, func() bool { return r.outboxDepth() == 0 }, time.Second, 10*time.Millisecond)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		require.Equal(t, map[*TicketDispatcher]bool{td: true}, r.dispatchers)
+	})
+
+	t.Run("does not resend a ticket written to a dispatcher that disconnects", func(t *testing.T) {
+		delivered := make(chan *message.Ticket, 2)
+		r := newRoad(1, Policy{}, func(t *message.Ticket) { delivered <- t })
+		first, firstConn := dispatcher(t)
+		r.addDispatcher(first)
+
+		t1 := ticket("UN1X", 0)
+		go r.dispatch(t1)
+		requireTickets(t, firstConn, t1)
+		select {
+		case got := <-delivered:
+			require.Equal(t, t1, got)
+		case <-time.After(time.Second):
+			t.Fatal("ticket not delivered")
+		}
+		r.removeDispatcher(first)
+		require.Zero(t, r.outboxDepth())
+
+		// The next dispatcher only gets the new ticket.
+		second, secondConn := dispatcher(t)
+		r.addDispatcher(second)
+		t2 := ticket("RE4L", 100)
+		go r.dispatch(t2)
+		requireTickets(t, secondConn, t2)
+	})
+
+	t.Run("requeues a ticket when the dispatcher stops reading", func(t *testing.T) {
+		r := newRoad(1, Policy{}, func(*message.Ticket) {})
+		server, client := net.Pipe()
+		defer client.Close()
+		// Nothing reads from client, so the write blocks until it times out.
+		r.addDispatcher(&TicketDispatcher{Roads: []uint16{1}, w: newConnWriter(server, realClock{}, 50*time.Millisecond)})
+
+		t1 := ticket("UN1X", 0)
+		r.dispatch(t1)
+		require.Equal(t, []*message.Ticket{t1}, r.outbox)
+		require.Empty(t, r.dispatchers)
+	})
+
+	t.Run("drops tickets only on request", func(t *testing.T) {
+		r := newRoad(1, Policy{}, func(*message.Ticket) {})
+		t1, t2, t3 := ticket("UN1X", 0), ticket("RE4L", 100), ticket("UN1X", 86400)
+		r.dispatch(t1)
+		r.dispatch(t2)
+		r.dispatch(t3)
+
+		require.Equal(t, []*message.Ticket{t1, t3}, r.drop("UN1X"))
+		require.Equal(t, 1, r.outboxDepth())
+		require.Equal(t, []*message.Ticket{t2}, r.drop(""))
+		require.Zero(t, r.outboxDepth())
+	})
+}
@@ -1,16 +1,14 @@
 package spdaemon
 
 import (
-	"bufio"
 	"context"
-	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/harveysanders/protohackers/6-speed-daemon/message"
@@ -18,36 +16,78 @@ import (
 
 type (
 	Server struct {
-		listener    net.Listener
-		mu          sync.Mutex
-		dispatchers map[uint16]map[*TicketDispatcher]bool // [road ID]:dispatcher
-		plates      map[uint16]map[string][]*observation  // [road ID][plate]
-		ticketQueue ticketQueue
-		ih          issueHistory
-		metrics     metrics
+		mu    sync.RWMutex
+		roads map[uint16]*road // [road ID]
+		// Serialises the one-ticket-per-day check with recording the ticket, since a car can speed on several roads at once.
+		issueMu sync.Mutex
+		ih      issueHistory
+		// Optional durable record of plate observations.
+		obs observationStore
+		// Enforcement policy for each road.
+		policies Policies
+		clock    clock
+		// How long a write to a client may block.
+		writeTimeout time.Duration
+		metrics      metrics
 	}
 
+	Option func(*Server)
+
+	// Metrics are counters served by the admin listener. They are updated from many connections at once, so every field is atomic.
 	metrics struct {
+		Clients struct {
+			Cameras     atomic.Int64
+			Dispatchers atomic.Int64
+		}
 		Plates struct {
-			Total  int
-			Unique int
+			Total  atomic.Int64
+			Unique atomic.Int64
 		}
 		Tickets struct {
-			Issued   int
-			Queued   int
-			Failed   int
-			Attempts int
-			Requeued int
-			Dropped  int
+			Issued atomic.Int64
+			// Violations not ticketed because the car already had a ticket for the day.
+			Suppressed atomic.Int64
+			Delivered  atomic.Int64
+			// Tickets discarded by an operator.
+			Dropped atomic.Int64
+			// Violations logged but not ticketed because the road's policy is warn only.
+			Warned atomic.Int64
 		}
 	}
 
+	// IssueHistory is the ledger of issued tickets. It enforces the one-ticket-per-day rule and tracks which tickets still need to be sent to a dispatcher.
 	issueHistory interface {
-		add(t *message.Ticket)
+		Add(t *message.Ticket) error
+
+		LookupForDate(plate string, timestamp1, timestamp2 message.UnixTime) (*message.Ticket, error)
+
+		// MarkDelivered records that the ticket was sent to a dispatcher.
+		MarkDelivered(t *message.Ticket) error
+
+		// MarkDropped records that an operator gave up on delivering the ticket.
+		MarkDropped(t *message.Ticket) error
+
+		// Undelivered returns the issued tickets that have been neither delivered nor dropped.
+		Undelivered() ([]*message.Ticket, error)
+
+		PrintHistory(plate string) string
+	}
 
-		lookupForDate(plate string, timestamp1, timestamp2 message.UnixTime) *message.Ticket
+	// ObservationStore persists plate observations so cars can be tracked across server restarts.
+	observationStore interface {
+		AddObservation(o Observation) error
 
-		printHistory(plate string) string
+		// Observations returns every stored observation.
+		Observations() ([]Observation, error)
+	}
+
+	// Observation is a stored plate observation, along with the camera that made it.
+	Observation struct {
+		Road      uint16
+		Mile      uint16
+		Limit     uint16
+		Plate     string
+		Timestamp time.Time
 	}
 
 	// Observation represents an event when a car's plate was captured on a certain road at a specific time and location.
@@ -64,33 +104,122 @@ type (
 	}
 )
 
+var (
+	ErrIllegalMsg            = errors.New("illegal msg")
+	ErrAlreadyIdentified     = errors.New("client already identified")
+	ErrNotCamera             = errors.New("plate sent by client not identified as a camera")
+	ErrHeartbeatAlreadyAsked = errors.New("wantHeartbeat already sent")
+)
+
 const CONNECTION_ID ctxKey = "CONNECTION_ID"
 
-func NewServer() *Server {
-	return &Server{
-		dispatchers: make(map[uint16]map[*TicketDispatcher]bool, 0),
-		plates:      make(map[uint16]map[string][]*observation, 0),
-		ticketQueue: make(ticketQueue, 8192),
-		ih:          newHistory(),
+// DefaultWriteTimeout is how long a write to a client may block, unless set with WithWriteTimeout.
+const DefaultWriteTimeout = 10 * time.Second
+
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		roads: make(map[uint16]*road),
+		ih:    newHistory(),
+		clock: realClock{},
+
+		writeTimeout: DefaultWriteTimeout,
+	}
+	for _, o := range opts {
+		o(s)
 	}
+	return s
 }
 
+// WithIssueHistory replaces the default in-memory ticket ledger.
+func WithIssueHistory(ih issueHistory) Option {
+	return func(s *Server) {
+		s.ih = ih
+	}
+}
+
+// WithObservationStore persists plate observations to store.
+func WithObservationStore(store observationStore) Option {
+	return func(s *Server) {
+		s.obs = store
+	}
+}
+
+// WithPolicies sets how speed limits are enforced on each road.
+func WithPolicies(p Policies) Option {
+	return func(s *Server) {
+		s.policies = p
+	}
+}
+
+// WithWriteTimeout sets how long a write to a client may block before the client is disconnected. A ticket that times out goes back to its road's outbox. Zero means writes never time out.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// Restore loads stored observations into the road shards and holds undelivered tickets for their road's dispatchers.
+func (s *Server) restore() error {
+	if s.obs != nil {
+		observations, err := s.obs.Observations()
+		if err != nil {
+			return fmt.Errorf("observations: %w", err)
+		}
+		for _, o := range observations {
+			s.road(o.Road).restore(observation{plate: o.Plate, mile: o.Mile, timestamp: o.Timestamp}, o.Limit)
+		}
+		log.Printf("restored %d observations", len(observations))
+	}
+
+	tickets, err := s.ih.Undelivered()
+	if err != nil {
+		return fmt.Errorf("undelivered: %w", err)
+	}
+	for _, t := range tickets {
+		s.road(t.Road).hold(t)
+	}
+	log.Printf("requeued %d undelivered tickets", len(tickets))
+	return nil
+}
+
+// Start listens on port and serves clients until ctx is cancelled.
 func (s *Server) Start(ctx context.Context, port string) error {
 	l, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
+	return s.Serve(ctx, l)
+}
 
-	log.Printf("Speed Daemon listening @ %s", l.Addr().String())
+// Serve restores stored state, then serves clients connecting to l until ctx is cancelled, which closes l. It returns ctx's error once cancelled.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	defer l.Close()
 
-	s.listener = l
+	if err := s.restore(); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	log.Printf("Speed Daemon listening @ %s", l.Addr().String())
 
-	go s.ticketListen(ctx)
+	// Accept only returns once the listener is closed, so closing it is how cancelling stops the server.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
 
 	clientID := 0
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("cancelled with err: %v", ctx.Err())
+				return ctx.Err()
+			}
 			return fmt.Errorf("accept: %w", err)
 		}
 
@@ -106,131 +235,134 @@ func (s *Server) Start(ctx context.Context, port string) error {
 			}
 
 		}(conn, clientID)
-
-		select {
-		case <-ctx.Done():
-			log.Printf("cancelled with err: %v", ctx.Err())
-			l.Close()
-		default:
-			continue
-		}
 	}
 }
 
 func (s *Server) HandleConnection(ctx context.Context, conn net.Conn) error {
 	// Identify the client
 	clientID := ctx.Value(CONNECTION_ID)
-	err := s.addClient(ctx, conn)
+	w := newConnWriter(conn, s.clock, s.writeTimeout)
+	err := s.addClient(ctx, conn, w)
 	if err != nil {
 		var clientErr *ClientError
 		switch {
 		case errors.As(err, &clientErr):
-			// TODO: Marshall message.Error and send back to client
+			log.Printf("[%s] Client ERR: %v", clientID, clientErr)
+			if err := w.write(&message.Error{Msg: clientErr.Error()}); err != nil {
+				log.Printf("[%s] write error message: %v", clientID, err)
+			}
 		default: // Server Error
 			if !errors.Is(err, io.EOF) {
 				log.Printf("[%s] Conn ERR: %v", clientID, err)
 			}
 		}
-		return conn.Close()
+		// Close the connection first to unblock a heartbeat stuck writing to it.
+		err := conn.Close()
+		w.close()
+		return err
 	}
+	w.close()
 	return nil
 }
 
-// AddClient identifies a client from it's message type and add them to the appropriate client bucket (cams or dispatchers).
-func (s *Server) addClient(ctx context.Context, conn net.Conn) error {
+// AddClient identifies a client from it's message type and add them to the appropriate client bucket (cams or dispatchers). Every write to the client goes through w.
+func (s *Server) addClient(ctx context.Context, conn net.Conn, w *connWriter) error {
 	clientID := ctx.Value(CONNECTION_ID)
 	// Client will be a cam or a dispatcher
 	var meCam Camera
 	var dispatcher TicketDispatcher
-	var heartbeatTicker *time.Ticker
+	// A client can identify itself only once, as either a camera or a dispatcher.
+	var isCamera, isDispatcher bool
+	var wantsHeartbeat bool
 	defer func() {
-		if heartbeatTicker != nil {
-			heartbeatTicker.Stop()
+		if isCamera {
+			s.road(meCam.Road).removeCamera(&meCam)
+			s.metrics.Clients.Cameras.Add(-1)
+		}
+		if isDispatcher {
+			s.unregisterDispatcher(ctx, &dispatcher)
+			s.metrics.Clients.Dispatchers.Add(-1)
 		}
-		s.unregisterDispatcher(ctx, &dispatcher)
 	}()
 
-	r := bufio.NewReader(conn)
+	dec := message.NewDecoder(conn)
 
 	for {
-		msgHdr, err := r.Peek(1)
-		if err != nil {
-			return fmt.Errorf("msg header peek: %w", err)
-		}
-		// Read the first byte to get the message type
-		msgType, err := message.ParseType(msgHdr[0])
+		// Reject Server->Client messages before waiting for the rest of the frame.
+		msgType, err := dec.PeekType()
 		if err != nil {
-			invalidMsg, err := r.Peek(10)
-			if err != nil {
-				log.Printf("problem peek invalid message: %v", err)
-			}
-			log.Printf("invalid message type: %v\n%x", err, invalidMsg)
-			return &ClientError{fmt.Errorf("invalid message type: %w", err)}
-		}
-
-		if msgType == message.TypeWantMetrics {
-			if err := json.NewEncoder(conn).Encode(s.metrics); err != nil {
-				return fmt.Errorf("error sending metrics response: %w", err)
-			}
-			if _, err := r.Discard(r.Buffered()); err != nil {
-				return fmt.Errorf("discard: %w", err)
+			if errors.Is(err, message.ErrUnknownType) {
+				log.Printf("[%s] %v", clientID, err)
+				return &ClientError{ErrIllegalMsg}
 			}
-			continue
+			return fmt.Errorf("peek type: %w", err)
 		}
-
-		// Calc the expected length of the message.
-		// The next 2 bytes contain enough info to calc the length of the complete message.
-		lenHdr, err := r.Peek(2)
-		if err != nil {
-			return fmt.Errorf("length header peek: %w", err)
+		if !msgType.FromClient() {
+			return &ClientError{ErrIllegalMsg}
 		}
 
-		// Read the message
-		msgLen := msgType.Len(lenHdr)
-		msg := make([]byte, msgLen)
-		n, err := io.ReadFull(r, msg)
+		msg, err := dec.Decode()
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				log.Printf("[%s]** expected to read %d bytes, but only recv'd: %d\nmsg: %x", clientID, msgLen, n, msg)
-			}
-			return fmt.Errorf("read: %w", err)
+			return fmt.Errorf("decode: %w", err)
 		}
 
 		// Handle message
-		switch msgType {
-		case message.TypeIAmCamera:
-			meCam.UnmarshalBinary(msg)
-			// log.Printf("[%s]TypeIAmCamera: %+v\nraw: %x", clientID, meCam, msg)
-		case message.TypeIAmDispatcher:
-			dispatcher.conn = conn
-			s.registerDispatcher(ctx, msg, &dispatcher)
-			// log.Printf("[%s]TypeIAmDispatcher: %+v\n%x", clientID, dispatcher, msg)
-		case message.TypePlate:
-			// log.Printf("[%s]TypePlate: %x", clientID, msg)
-			s.handlePlate(ctx, msg, meCam)
-		case message.TypeWantHeartbeat:
-			// log.Printf("[%s]TypeWantHeartbeat: %x", clientID, msg)
-			if heartbeatTicker != nil {
-				return &ClientError{errors.New("wantHeartbeat already sent")}
+		switch m := msg.(type) {
+		case *message.IAmCamera:
+			if isCamera || isDispatcher {
+				return &ClientError{ErrAlreadyIdentified}
 			}
-			if err := s.startHeartbeat(ctx, msg, conn, heartbeatTicker); err != nil {
-				return fmt.Errorf("startHeartbeat: %w", err)
+			isCamera = true
+			meCam = Camera{Road: m.Road, Mile: m.Mile, Limit: m.Limit}
+			s.road(meCam.Road).addCamera(&meCam)
+			s.metrics.Clients.Cameras.Add(1)
+		case *message.IAmDispatcher:
+			if isCamera || isDispatcher {
+				return &ClientError{ErrAlreadyIdentified}
 			}
+			isDispatcher = true
+			s.metrics.Clients.Dispatchers.Add(1)
+			dispatcher.w = w
+			dispatcher.Roads = m.Roads
+			s.registerDispatcher(ctx, &dispatcher)
+		case *message.Plate:
+			if !isCamera {
+				return &ClientError{ErrNotCamera}
+			}
+			s.handlePlate(ctx, m, meCam)
+		case *message.WantHeartbeat:
+			if wantsHeartbeat {
+				return &ClientError{ErrHeartbeatAlreadyAsked}
+			}
+			// Any client may ask for heartbeats, identified or not.
+			wantsHeartbeat = true
+			w.startHeartbeat(ctx, m.Interval)
 		}
 	}
 }
 
-func (s *Server) registerDispatcher(ctx context.Context, msg []byte, td *TicketDispatcher) error {
-	td.UnmarshalBinary(msg)
+// Road returns the shard for the road ID, creating it if needed.
+func (s *Server) road(id uint16) *road {
+	s.mu.RLock()
+	r, ok := s.roads[id]
+	s.mu.RUnlock()
+	if ok {
+		return r
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if r, ok := s.roads[id]; ok {
+		return r
+	}
+	r = newRoad(id, s.policies.For(id), s.markDelivered)
+	s.roads[id] = r
+	return r
+}
 
+func (s *Server) registerDispatcher(ctx context.Context, td *TicketDispatcher) error {
 	for _, rid := range td.Roads {
-		_, ok := s.dispatchers[rid]
-		if !ok {
-			s.dispatchers[rid] = make(map[*TicketDispatcher]bool, 0)
-		}
-		s.dispatchers[rid][td] = true
+		s.road(rid).addDispatcher(td)
 	}
 	return nil
 }
@@ -240,138 +372,104 @@ func (s *Server) unregisterDispatcher(ctx context.Context, td *TicketDispatcher)
 		return
 	}
 	for _, rid := range td.Roads {
-		delete(s.dispatchers[rid], td)
+		s.road(rid).removeDispatcher(td)
 	}
 }
 
-func (s *Server) handlePlate(ctx context.Context, msg []byte, cam Camera) {
-	p := message.Plate{}
-	p.UnmarshalBinary(msg)
-
+func (s *Server) handlePlate(ctx context.Context, p *message.Plate, cam Camera) {
 	clientID := ctx.Value(CONNECTION_ID)
 	log.Printf("[%s] Plate: %+v", clientID, p)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.plates[cam.Road]; !ok {
-		s.plates[cam.Road] = make(map[string][]*observation)
-	}
-
-	s.metrics.Plates.Total++
-
-	// Check if plate has been seen on the same road before
-	obs, ok := s.plates[cam.Road][p.Plate]
 	latest := observation{
 		plate:     p.Plate,
 		timestamp: p.Timestamp,
 		mile:      cam.Mile,
 	}
-	if !ok {
-		// If not, register the plate
-		s.plates[cam.Road][p.Plate] = []*observation{&latest}
-		s.metrics.Plates.Unique++
-		return
+	if s.obs != nil {
+		err := s.obs.AddObservation(Observation{
+			Road:      cam.Road,
+			Mile:      cam.Mile,
+			Limit:     cam.Limit,
+			Plate:     p.Plate,
+			Timestamp: p.Timestamp,
+		})
+		if err != nil {
+			log.Printf("[%s] store observation: %v", clientID, err)
+		}
 	}
-	// If seen before
-	// iterate over the records and calculate the average speed
-	if v := checkViolation(latest, obs, float64(cam.Limit)); v != nil {
-		v.Road = cam.Road
-		log.Print("____________________")
-		log.Printf("violation: %+v", v)
-		log.Print("____________________")
-		s.metrics.Tickets.Queued++
-		s.ticketQueue <- v
-	}
-	// Add observation
-	s.plates[cam.Road][p.Plate] = append(s.plates[cam.Road][p.Plate], &latest)
-}
-
-func (s *Server) ticketListen(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("context closed: %v\n", ctx.Err())
-			return
-		case ticket := <-s.ticketQueue:
-			time.Sleep(time.Millisecond)
-
-			ticket.IncAttempts()
-
-			s.metrics.Tickets.Attempts++
-
-			// Look up dispatcher for road
-			td, err := s.nextDispatcher(ticket.Road)
-			if err != nil {
-				// log.Printf("%v.\n", err)
-				if ticket.Retries() < 50 {
-					// log.Print("%Requeuing ticket..\n")
-					// Put the ticket back in the queue
-					s.metrics.Tickets.Requeued++
-					s.ticketQueue <- ticket
-				} else {
-					s.metrics.Tickets.Dropped++
-					log.Printf("Retried to find dispatcher %d times. Dropping ticket...\n", ticket.Retries())
-				}
-				continue
-			}
 
-			// Double check ticket not already issued for same day
-			if issued := s.ih.lookupForDate(ticket.Plate, ticket.Timestamp1, ticket.Timestamp2); issued != nil {
-				log.Printf("Ticket already issued: %+v", ticket)
-				s.metrics.Tickets.Dropped++
-				// Don't requeue and move on to next
-				continue
-			}
+	r := s.road(cam.Road)
+	candidates, firstSighting := r.observe(latest, cam.Limit)
 
-			// Send ticket
-			if err := td.send(ticket); err != nil {
-				s.metrics.Tickets.Failed++
-				log.Printf("Ticket dispatcher could not send ticket: %v\n", err)
-				// Try again later
-				s.ticketQueue <- ticket
-				continue
-			}
-			s.metrics.Tickets.Issued++
-			s.ih.add(ticket)
-			log.Printf("Ticket issued: %+v\n", ticket)
-			log.Printf("%d left in queue.\n", len(s.ticketQueue))
+	s.metrics.Plates.Total.Add(1)
+	if firstSighting {
+		s.metrics.Plates.Unique.Add(1)
+	}
+	for _, v := range candidates {
+		if r.policy.WarnOnly {
+			log.Printf("[%s] warning, not ticketed: %+v", clientID, v)
+			s.metrics.Tickets.Warned.Add(1)
+			continue
 		}
+		s.issue(ctx, r, v)
 	}
 }
 
-func (s *Server) nextDispatcher(roadID uint16) (*TicketDispatcher, error) {
-	dispatchers, ok := s.dispatchers[roadID]
-	if !ok {
-		return nil, fmt.Errorf("no dispatchers available for road %d", roadID)
+// Issue records and dispatches the ticket, unless the car already has a ticket for one of the days it covers.
+func (s *Server) issue(ctx context.Context, r *road, v *message.Ticket) {
+	clientID := ctx.Value(CONNECTION_ID)
+	log.Print("____________________")
+	log.Printf("violation: %+v", v)
+	log.Print("____________________")
+
+	// Only one ticket per car per day, across all roads.
+	s.issueMu.Lock()
+	issued, err := s.ih.LookupForDate(v.Plate, v.Timestamp1, v.Timestamp2)
+	if err == nil && issued == nil {
+		err = s.ih.Add(v)
+	}
+	s.issueMu.Unlock()
+	if err != nil {
+		log.Printf("[%s] record ticket: %v", clientID, err)
+		return
 	}
-	for dispatcher := range dispatchers {
-		return dispatcher, nil
+	if issued != nil {
+		log.Printf("Ticket already issued: %+v", issued)
+		s.metrics.Tickets.Suppressed.Add(1)
+		return
 	}
-	return nil, fmt.Errorf("no dispatchers available for road %d", roadID)
+
+	s.metrics.Tickets.Issued.Add(1)
+	r.dispatch(v)
 }
 
-func (s *Server) startHeartbeat(ctx context.Context, msg []byte, conn net.Conn, ticker *time.Ticker) error {
-	// in deciseconds
-	interval := binary.BigEndian.Uint32(msg[1:])
-	if interval < 1 {
-		return nil
+// DropTickets discards the undelivered tickets held for the road, so they are never sent. If plate is empty, every held ticket for the road is dropped. It returns the number of tickets dropped.
+func (s *Server) DropTickets(roadID uint16, plate string) (int, error) {
+	s.mu.RLock()
+	r, ok := s.roads[roadID]
+	s.mu.RUnlock()
+	if !ok {
+		return 0, nil
 	}
-	ticker = time.NewTicker(time.Millisecond * time.Duration(interval) * 100)
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				ticker.Stop()
-			case <-ticker.C:
-				hb := []byte{byte(message.TypeHeartbeat)}
-				if _, err := conn.Write(hb); err != nil {
-					ticker.Stop()
-				}
-			}
+	dropped := r.drop(plate)
+	for i, t := range dropped {
+		if err := s.ih.MarkDropped(t); err != nil {
+			// Put back the tickets the ledger still considers undelivered.
+			r.hold(dropped[i:]...)
+			return i, fmt.Errorf("mark ticket dropped: %w", err)
 		}
-	}()
-	return nil
+		s.metrics.Tickets.Dropped.Add(1)
+		log.Printf("[road %d] dropped ticket: %+v", roadID, t)
+	}
+	return len(dropped), nil
+}
+
+func (s *Server) markDelivered(t *message.Ticket) {
+	s.metrics.Tickets.Delivered.Add(1)
+	if err := s.ih.MarkDelivered(t); err != nil {
+		log.Printf("mark ticket delivered: %v", err)
+	}
 }
 
 func (e *ClientError) Error() string {
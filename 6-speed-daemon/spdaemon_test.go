@@ -3,9 +3,13 @@ package spdaemon_test
 import (
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -58,14 +62,16 @@ func TestHeartbeat(t *testing.T) {
 		timeout := time.Millisecond * 1100
 		wantCount := 2
 		timer := time.NewTimer(timeout)
-		respChan := make(chan []byte)
-		errChan := make(chan error)
+		// Buffered so the reader can exit after the test stops listening.
+		respChan := make(chan []byte, wantCount+1)
+		errChan := make(chan error, 1)
 
 		go func() {
 			b := make([]byte, 1)
 			for {
 				if _, err := conn.Read(b); err != nil {
 					errChan <- err
+					return
 				}
 				respChan <- b
 			}
@@ -75,6 +81,7 @@ func TestHeartbeat(t *testing.T) {
 			select {
 			case <-timer.C:
 				require.InDelta(t, 2, beatCount, 1)
+				return
 			case <-respChan:
 				beatCount++
 				if beatCount > wantCount {
@@ -318,8 +325,172 @@ func TestServer(t *testing.T) {
 	})
 }
 
-func TestMetrics(t *testing.T) {
-	t.Run("sends back JSON metrics", func(t *testing.T) {
-		net.Dial("tcp6", "[2a09:8280:1::f:5ec]:8080")
+func TestAdmin(t *testing.T) {
+	port := "45684"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := spdaemon.NewServer()
+	go srv.Start(ctx, port)
+	admin := httptest.NewServer(srv.AdminHandler())
+	defer admin.Close()
+	// wait for server to start
+	time.Sleep(time.Second / 2)
+
+	// Two cameras on road 123 see UN1X speeding. No dispatcher is connected, so the ticket is held.
+	cameras := [][][]byte{
+		// IAmCamera{road: 123, mile: 8, limit: 60}, Plate{plate: "UN1X", timestamp: 0}
+		{{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c}, {0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x00, 0x00}},
+		// IAmCamera{road: 123, mile: 9, limit: 60}, Plate{plate: "UN1X", timestamp: 45}
+		{{0x80, 0x00, 0x7b, 0x00, 0x09, 0x00, 0x3c}, {0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x00, 0x2d}},
+	}
+	for _, msgs := range cameras {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%s", port))
+		require.NoError(t, err)
+		defer conn.Close()
+		for _, msg := range msgs {
+			_, err := conn.Write(msg)
+			require.NoError(t, err)
+		}
+		time.Sleep(time.Second / 10)
+	}
+
+	get := func(t *testing.T, method, path string) string {
+		req, err := http.NewRequest(method, admin.URL+path, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode, string(body))
+		return string(body)
+	}
+
+	t.Run("serves Prometheus metrics", func(t *testing.T) {
+		body := get(t, http.MethodGet, "/metrics")
+		require.Contains(t, body, "# TYPE spdaemon_tickets_issued_total counter\nspdaemon_tickets_issued_total 1\n")
+		require.Contains(t, body, "spdaemon_cameras 2\n")
+		require.Contains(t, body, "spdaemon_plates_total 2\n")
+		require.Contains(t, body, `spdaemon_ticket_outbox{road="123"} 1`)
+	})
+
+	t.Run("lists cameras and dispatchers per road", func(t *testing.T) {
+		body := get(t, http.MethodGet, "/roads")
+		require.JSONEq(t, `[{"road": 123, "limit": 60, "cameras": [{"mile": 8, "limit": 60}, {"mile": 9, "limit": 60}], "dispatchers": [], "outbox": 1}]`, body)
+	})
+
+	t.Run("lists pending tickets", func(t *testing.T) {
+		body := get(t, http.MethodGet, "/tickets/pending")
+		require.JSONEq(t, `[{"plate": "UN1X", "road": 123, "mile1": 8, "timestamp1": 0, "mile2": 9, "timestamp2": 45, "speed": 8000, "limit": 60}]`, body)
+	})
+
+	t.Run("prints a plate's ticket history", func(t *testing.T) {
+		var got struct {
+			Plate   string `json:"plate"`
+			History string `json:"history"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(get(t, http.MethodGet, "/history?plate=UN1X")), &got))
+		require.Equal(t, "UN1X", got.Plate)
+		require.Contains(t, got.History, "Plate:UN1X Road:123")
+	})
+
+	t.Run("drops pending tickets", func(t *testing.T) {
+		require.JSONEq(t, `{"dropped": 1}`, get(t, http.MethodDelete, "/tickets/pending?road=123"))
+		require.JSONEq(t, `[]`, get(t, http.MethodGet, "/tickets/pending"))
+		require.Contains(t, get(t, http.MethodGet, "/metrics"), "spdaemon_tickets_dropped_total 1\n")
 	})
 }
+
+func TestClientErrors(t *testing.T) {
+	port := "45679"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go spdaemon.NewServer().Start(ctx, port)
+	addr := fmt.Sprintf("localhost:%s", port)
+	// wait for server to start
+	time.Sleep(time.Second / 2)
+
+	camera := []byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c}
+	dispatcher := []byte{0x81, 0x01, 0x00, 0x7b}
+	plate := []byte{0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x00, 0x00}
+	wantHeartbeat := []byte{0x40, 0x00, 0x00, 0x00, 0x00}
+
+	testCases := []struct {
+		name     string
+		messages [][]byte
+		wantErr  error
+	}{
+		{
+			name:     "illegal message type",
+			messages: [][]byte{{0x99}},
+			wantErr:  spdaemon.ErrIllegalMsg,
+		},
+		{
+			name:     "server to client message type",
+			messages: [][]byte{{byte(message.TypeHeartbeat)}},
+			wantErr:  spdaemon.ErrIllegalMsg,
+		},
+		{
+			name:     "former in-band metrics request",
+			messages: [][]byte{{0x6d}},
+			wantErr:  spdaemon.ErrIllegalMsg,
+		},
+		{
+			name:     "plate before identifying",
+			messages: [][]byte{plate},
+			wantErr:  spdaemon.ErrNotCamera,
+		},
+		{
+			name:     "plate from a dispatcher",
+			messages: [][]byte{dispatcher, plate},
+			wantErr:  spdaemon.ErrNotCamera,
+		},
+		{
+			name:     "second IAmCamera",
+			messages: [][]byte{camera, camera},
+			wantErr:  spdaemon.ErrAlreadyIdentified,
+		},
+		{
+			name:     "second IAmDispatcher",
+			messages: [][]byte{dispatcher, dispatcher},
+			wantErr:  spdaemon.ErrAlreadyIdentified,
+		},
+		{
+			name:     "camera then dispatcher",
+			messages: [][]byte{camera, dispatcher},
+			wantErr:  spdaemon.ErrAlreadyIdentified,
+		},
+		{
+			name:     "dispatcher then camera",
+			messages: [][]byte{dispatcher, camera},
+			wantErr:  spdaemon.ErrAlreadyIdentified,
+		},
+		{
+			name:     "second WantHeartbeat",
+			messages: [][]byte{wantHeartbeat, wantHeartbeat},
+			wantErr:  spdaemon.ErrHeartbeatAlreadyAsked,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			for _, msg := range tc.messages {
+				_, err := conn.Write(msg)
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			got, err := io.ReadAll(conn)
+			require.NoError(t, err, "server should close the connection after the error")
+
+			want := message.Error{Msg: tc.wantErr.Error()}
+			require.Equal(t, want.MarshalBinary(), got)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+)
+
+// TicketLedger is a durable record of issued tickets. It enforces the one-ticket-per-day rule across server restarts and remembers which tickets have not been sent to a dispatcher.
+type TicketLedger struct {
+	db *DB
+}
+
+func NewTicketLedger(db *DB) *TicketLedger {
+	return &TicketLedger{db: db}
+}
+
+// Add records the ticket as issued but undelivered, claiming every day it covers.
+func (l *TicketLedger) Add(t *message.Ticket) error {
+	tx, err := l.db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+INSERT INTO
+  tickets (created_at, plate, road, mile1, timestamp1, mile2, timestamp2, speed, speed_limit)
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		l.db.Now().Format(time.RFC3339), t.Plate, t.Road, t.Mile1, t.Timestamp1, t.Mile2, t.Timestamp2, t.Speed, t.Limit,
+	)
+	if err != nil {
+		return fmt.Errorf("insert ticket: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("lastInsertId: %w", err)
+	}
+
+	for day := t.Timestamp1.Day(); day <= t.Timestamp2.Day(); day++ {
+		_, err := tx.Exec(`
+INSERT INTO
+  ticket_days (plate, day, ticket_id)
+VALUES
+  (?, ?, ?);`,
+			t.Plate, int64(day), id,
+		)
+		if err != nil {
+			return fmt.Errorf("insert ticket day %d: %w", int64(day), err)
+		}
+	}
+	return tx.Commit()
+}
+
+// LookupForDate returns the ticket issued to the plate on any day between the timestamps, or nil if there is none.
+func (l *TicketLedger) LookupForDate(plate string, timestamp1, timestamp2 message.UnixTime) (*message.Ticket, error) {
+	row := l.db.DB.QueryRow(`
+SELECT
+  tickets.plate, tickets.road, tickets.mile1, tickets.timestamp1, tickets.mile2, tickets.timestamp2, tickets.speed, tickets.speed_limit
+FROM
+  ticket_days
+  JOIN tickets ON ticket_days.ticket_id = tickets.id
+WHERE
+  ticket_days.plate = ?
+  AND ticket_days.day BETWEEN ? AND ?
+LIMIT
+  1;`,
+		plate, int64(timestamp1.Day()), int64(timestamp2.Day()),
+	)
+	t, err := scanTicket(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("scanTicket: %w", err)
+	}
+	return t, nil
+}
+
+func (l *TicketLedger) MarkDelivered(t *message.Ticket) error {
+	_, err := l.db.DB.Exec(`
+UPDATE tickets
+SET
+  delivered_at = ?
+WHERE
+  plate = ?
+  AND road = ?
+  AND timestamp1 = ?
+  AND timestamp2 = ?;`,
+		l.db.Now().Format(time.RFC3339), t.Plate, t.Road, t.Timestamp1, t.Timestamp2,
+	)
+	if err != nil {
+		return fmt.Errorf("update ticket: %w", err)
+	}
+	return nil
+}
+
+// MarkDropped records that the ticket was discarded by an operator, so it is not requeued after a restart.
+func (l *TicketLedger) MarkDropped(t *message.Ticket) error {
+	_, err := l.db.DB.Exec(`
+UPDATE tickets
+SET
+  dropped_at = ?
+WHERE
+  plate = ?
+  AND road = ?
+  AND timestamp1 = ?
+  AND timestamp2 = ?;`,
+		l.db.Now().Format(time.RFC3339), t.Plate, t.Road, t.Timestamp1, t.Timestamp2,
+	)
+	if err != nil {
+		return fmt.Errorf("update ticket: %w", err)
+	}
+	return nil
+}
+
+// Undelivered returns the tickets neither delivered nor dropped, oldest first.
+func (l *TicketLedger) Undelivered() ([]*message.Ticket, error) {
+	rows, err := l.db.DB.Query(`
+SELECT
+  plate, road, mile1, timestamp1, mile2, timestamp2, speed, speed_limit
+FROM
+  tickets
+WHERE
+  delivered_at IS NULL
+  AND dropped_at IS NULL
+ORDER BY
+  id;`)
+	if err != nil {
+		return nil, fmt.Errorf("query tickets: %w", err)
+	}
+	defer rows.Close()
+	return scanTickets(rows)
+}
+
+func (l *TicketLedger) PrintHistory(plate string) string {
+	rows, err := l.db.DB.Query(`
+SELECT
+  plate, road, mile1, timestamp1, mile2, timestamp2, speed, speed_limit
+FROM
+  tickets
+WHERE
+  plate = ?
+ORDER BY
+  timestamp1;`, plate)
+	if err != nil {
+		return fmt.Sprintf("[%s]: query tickets: %v\n", plate, err)
+	}
+	defer rows.Close()
+	tickets, err := scanTickets(rows)
+	if err != nil {
+		return fmt.Sprintf("[%s]: scan tickets: %v\n", plate, err)
+	}
+	if len(tickets) == 0 {
+		return fmt.Sprintf("[%s]: No tickets yet\n", plate)
+	}
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("** [%s] START **\n", plate))
+	for _, t := range tickets {
+		out.WriteString(fmt.Sprintf("Day: %d: %+v\n", t.Timestamp1.Day(), t))
+	}
+	out.WriteString(fmt.Sprintf("** [%s] END **\n", plate))
+	return out.String()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTicket(row scanner) (*message.Ticket, error) {
+	var t message.Ticket
+	err := row.Scan(&t.Plate, &t.Road, &t.Mile1, &t.Timestamp1, &t.Mile2, &t.Timestamp2, &t.Speed, &t.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func scanTickets(rows *sql.Rows) ([]*message.Ticket, error) {
+	tickets := make([]*message.Ticket, 0)
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanTicket: %w", err)
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
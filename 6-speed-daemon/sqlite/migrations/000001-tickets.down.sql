@@ -0,0 +1,4 @@
+-- +goose Down
+DROP TABLE IF EXISTS ticket_days;
+
+DROP TABLE IF EXISTS tickets;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE tickets (
+  id integer PRIMARY KEY,
+  created_at text NOT NULL,
+  delivered_at text,
+  plate text NOT NULL,
+  road integer NOT NULL,
+  mile1 integer NOT NULL,
+  timestamp1 integer NOT NULL,
+  mile2 integer NOT NULL,
+  timestamp2 integer NOT NULL,
+  speed integer NOT NULL,
+  UNIQUE (plate, road, timestamp1, timestamp2)
+);
+
+-- Each day a ticket covers. Enforces one ticket per car per day.
+CREATE TABLE ticket_days (
+  plate text NOT NULL,
+  day integer NOT NULL,
+  ticket_id integer NOT NULL REFERENCES tickets (id),
+  PRIMARY KEY (plate, day)
+);
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE observations (
+  id integer PRIMARY KEY,
+  created_at text NOT NULL,
+  road integer NOT NULL,
+  mile integer NOT NULL,
+  speed_limit integer NOT NULL,
+  plate text NOT NULL,
+  timestamp integer NOT NULL
+);
@@ -0,0 +1,2 @@
+-- +goose Up
+ALTER TABLE tickets ADD COLUMN dropped_at text;
@@ -0,0 +1,2 @@
+-- +goose Down
+ALTER TABLE tickets DROP COLUMN speed_limit;
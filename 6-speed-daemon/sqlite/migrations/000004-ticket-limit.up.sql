@@ -0,0 +1,2 @@
+-- +goose Up
+ALTER TABLE tickets ADD COLUMN speed_limit integer NOT NULL DEFAULT 0;
@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	spdaemon "github.com/harveysanders/protohackers/6-speed-daemon"
+)
+
+// ObservationStore persists every plate observation, so a car's journey survives a server restart.
+type ObservationStore struct {
+	db *DB
+}
+
+func NewObservationStore(db *DB) *ObservationStore {
+	return &ObservationStore{db: db}
+}
+
+func (s *ObservationStore) AddObservation(o spdaemon.Observation) error {
+	_, err := s.db.DB.Exec(`
+INSERT INTO
+  observations (created_at, road, mile, speed_limit, plate, timestamp)
+VALUES
+  (?, ?, ?, ?, ?, ?);`,
+		s.db.Now().Format(time.RFC3339), o.Road, o.Mile, o.Limit, o.Plate, o.Timestamp.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert observation: %w", err)
+	}
+	return nil
+}
+
+// Observations returns every stored observation in the order they were received.
+func (s *ObservationStore) Observations() ([]spdaemon.Observation, error) {
+	rows, err := s.db.DB.Query(`
+SELECT
+  road, mile, speed_limit, plate, timestamp
+FROM
+  observations
+ORDER BY
+  id;`)
+	if err != nil {
+		return nil, fmt.Errorf("query observations: %w", err)
+	}
+	defer rows.Close()
+
+	observations := make([]spdaemon.Observation, 0)
+	for rows.Next() {
+		var o spdaemon.Observation
+		var timestamp int64
+		if err := rows.Scan(&o.Road, &o.Mile, &o.Limit, &o.Plate, &timestamp); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		o.Timestamp = time.Unix(timestamp, 0)
+		observations = append(observations, o)
+	}
+	return observations, rows.Err()
+}
@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maragudk/migrate"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// DB represents the database connection.
+// Based off Ben Johnson's WTF Dial example.
+// https://github.com/benbjohnson/wtf
+type DB struct {
+	DB *sql.DB
+
+	// Data source name.
+	DSN string
+
+	// Returns the current time. Defaults to time.Now().
+	// Can be mocked for tests.
+	Now func() time.Time
+}
+
+func NewDB(dsn string) *DB {
+	return &DB{
+		DSN: dsn,
+		Now: time.Now,
+	}
+}
+
+// Open opens the database connection and applies any pending migrations.
+func (db *DB) Open() (err error) {
+	// Ensure a DSN is set before attempting to open the database.
+	if db.DSN == "" {
+		return fmt.Errorf("dsn required")
+	}
+
+	// Make the parent directory unless using an in-memory db.
+	if db.DSN != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(db.DSN), 0700); err != nil {
+			return err
+		}
+	}
+
+	// Connect to the database.
+	if db.DB, err = sql.Open("sqlite3", db.DSN); err != nil {
+		return err
+	}
+	// An in-memory database only lives as long as its connection.
+	if db.DSN == ":memory:" {
+		db.DB.SetMaxOpenConns(1)
+	}
+
+	// Enable WAL. SQLite performs better with the WAL  because it allows
+	// multiple readers to operate while data is being written.
+	if _, err := db.DB.Exec(`PRAGMA journal_mode = wal;`); err != nil {
+		return fmt.Errorf("enable wal: %w", err)
+	}
+
+	if _, err := db.DB.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
+		return fmt.Errorf("foreign keys pragma: %w", err)
+	}
+
+	if err := db.migrateUp(); err != nil {
+		return fmt.Errorf("migrateUp: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) Close() error {
+	if db.DB == nil {
+		return nil
+	}
+	return db.DB.Close()
+}
+
+func (db *DB) migrateUp() error {
+	dirFS, err := fs.Sub(migrationsFS, "migrations")
+	if err != nil {
+		return fmt.Errorf("fs.Sub: %w", err)
+	}
+	if err := migrate.Up(context.Background(), db.DB, dirFS); err != nil {
+		return fmt.Errorf("migrate up: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	spdaemon "github.com/harveysanders/protohackers/6-speed-daemon"
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/harveysanders/protohackers/6-speed-daemon/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTicketLedger(t *testing.T) {
+	db := sqlite.NewDB(":memory:")
+	require.NoError(t, db.Open())
+	defer db.Close()
+
+	ledger := sqlite.NewTicketLedger(db)
+	ticket := &message.Ticket{Plate: "UN1X", Road: 123, Mile1: 8, Timestamp1: 0, Mile2: 9, Timestamp2: 86400 + 45, Speed: 8000, Limit: 60}
+	require.NoError(t, ledger.Add(ticket))
+
+	t.Run("looks up tickets for every day covered", func(t *testing.T) {
+		got, err := ledger.LookupForDate("UN1X", 86400*1+10, 86400*1+20)
+		require.NoError(t, err)
+		require.Equal(t, ticket, got)
+
+		got, err = ledger.LookupForDate("UN1X", 86400*2, 86400*2+20)
+		require.NoError(t, err)
+		require.Nil(t, got)
+
+		got, err = ledger.LookupForDate("OTHER", 0, 10)
+		require.NoError(t, err)
+		require.Nil(t, got)
+	})
+
+	t.Run("rejects a second ticket for the same day", func(t *testing.T) {
+		err := ledger.Add(&message.Ticket{Plate: "UN1X", Road: 124, Timestamp1: 86400 + 100, Timestamp2: 86400 + 200})
+		require.Error(t, err)
+	})
+
+	t.Run("tracks undelivered tickets", func(t *testing.T) {
+		undelivered, err := ledger.Undelivered()
+		require.NoError(t, err)
+		require.Equal(t, []*message.Ticket{ticket}, undelivered)
+
+		require.NoError(t, ledger.MarkDelivered(ticket))
+		undelivered, err = ledger.Undelivered()
+		require.NoError(t, err)
+		require.Empty(t, undelivered)
+	})
+
+	t.Run("does not requeue dropped tickets", func(t *testing.T) {
+		dropped := &message.Ticket{Plate: "DR0P", Road: 123, Mile1: 8, Timestamp1: 0, Mile2: 9, Timestamp2: 45, Speed: 8000}
+		require.NoError(t, ledger.Add(dropped))
+		require.NoError(t, ledger.MarkDropped(dropped))
+
+		undelivered, err := ledger.Undelivered()
+		require.NoError(t, err)
+		require.Empty(t, undelivered)
+	})
+}
+
+func TestRestart(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "spdaemon.db")
+
+	// startServer starts a server backed by the database on a random port. It returns the server's address, the database, and a function that stops the server and closes the database.
+	startServer := func(t *testing.T) (string, *sqlite.DB, func()) {
+		db := sqlite.NewDB(dsn)
+		require.NoError(t, db.Open())
+		srv := spdaemon.NewServer(
+			spdaemon.WithIssueHistory(sqlite.NewTicketLedger(db)),
+			spdaemon.WithObservationStore(sqlite.NewObservationStore(db)),
+		)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(ctx, l) }()
+		return l.Addr().String(), db, func() {
+			cancel()
+			require.ErrorIs(t, <-served, context.Canceled)
+			db.Close()
+		}
+	}
+
+	send := func(t *testing.T, addr string, msgs ...[]byte) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		for _, msg := range msgs {
+			_, err := conn.Write(msg)
+			require.NoError(t, err)
+		}
+		return conn
+	}
+
+	readTickets := func(t *testing.T, conn net.Conn) []byte {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		got := make([]byte, 0)
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			got = append(got, buf[:n]...)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return got
+			}
+			require.NoError(t, err)
+		}
+	}
+
+	// requireStored waits until the database holds n observations and the given number of undelivered tickets.
+	requireStored := func(t *testing.T, db *sqlite.DB, n, undelivered int) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			observations, err := sqlite.NewObservationStore(db).Observations()
+			require.NoError(t, err)
+			tickets, err := sqlite.NewTicketLedger(db).Undelivered()
+			require.NoError(t, err)
+			return len(observations) == n && len(tickets) == undelivered
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+
+	// Ticket{plate: "UN1X", road: 123, mile1: 8, timestamp1: 0, mile2: 9, timestamp2: 45, speed: 8000}
+	wantTicket := []byte{0x21, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x2d, 0x1f, 0x40}
+
+	// IAmCamera{road: 123, mile: 8, limit: 60}, Plate{plate: "UN1X", timestamp: 0}
+	addr, db, stop := startServer(t)
+	cam1 := send(t, addr,
+		[]byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c},
+		[]byte{0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x00, 0x00},
+	)
+	requireStored(t, db, 1, 0)
+	cam1.Close()
+	stop()
+
+	// The first observation survives the restart, so the second one produces a ticket.
+	// IAmCamera{road: 123, mile: 9, limit: 60}, Plate{plate: "UN1X", timestamp: 45}
+	addr, db, stop = startServer(t)
+	cam2 := send(t, addr,
+		[]byte{0x80, 0x00, 0x7b, 0x00, 0x09, 0x00, 0x3c},
+		[]byte{0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x00, 0x2d},
+	)
+	requireStored(t, db, 2, 1)
+	cam2.Close()
+	stop()
+
+	// The ticket was never delivered, so it is requeued after the next restart.
+	addr, db, stop = startServer(t)
+	dispatcher := send(t, addr, []byte{0x81, 0x01, 0x00, 0x7b})
+	require.Equal(t, wantTicket, readTickets(t, dispatcher))
+	requireStored(t, db, 2, 0)
+	dispatcher.Close()
+	stop()
+
+	// No new ticket for the same day after another restart, and the delivered ticket is not sent again.
+	// IAmCamera{road: 123, mile: 10, limit: 60}, Plate{plate: "UN1X", timestamp: 90}
+	addr, db, stop = startServer(t)
+	defer stop()
+	dispatcher = send(t, addr, []byte{0x81, 0x01, 0x00, 0x7b})
+	cam3 := send(t, addr,
+		[]byte{0x80, 0x00, 0x7b, 0x00, 0x0a, 0x00, 0x3c},
+		[]byte{0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x00, 0x5a},
+	)
+	defer cam3.Close()
+	requireStored(t, db, 3, 0)
+	require.Empty(t, readTickets(t, dispatcher))
+	dispatcher.Close()
+}
@@ -2,41 +2,64 @@ package spdaemon
 
 import (
 	"math"
+	"sort"
 	"time"
 
 	"github.com/harveysanders/protohackers/6-speed-daemon/message"
 )
 
-type (
-	// [Road]tickets
-	ticketQueue chan *message.Ticket
-)
-
-func checkViolation(o observation, past []*observation, limit float64) *message.Ticket {
-	for _, prev := range past {
-		// Check prev timestamp is within a day (86.4k secs)
-		if o.timestamp.Sub(prev.timestamp).Abs() > time.Second*86400 {
-			continue
+// CheckViolations checks the pairs formed by obs[i] and the plate's other observations. obs must be ordered by timestamp. It returns a ticket for each pair where the car's average speed was over the policy's threshold, ordered by the later observation.
+//
+// Without a MinGap or school zones, only obs[i]'s neighbours need checking: if a car averaged over the limit between two observations, it did so between some adjacent pair in between them, which covers no more days. A MinGap can rule out the adjacent pairs, and a school zone can give a wider pair a lower limit than any adjacent one, so roads with either are checked against every other observation.
+func checkViolations(obs []*observation, i int, limit uint16, p Policy) []*message.Ticket {
+	var tickets []*message.Ticket
+	check := func(j int) {
+		if j < 0 || j >= len(obs) || j == i {
+			return
+		}
+		if t := checkPair(*obs[j], *obs[i], limit, p); t != nil {
+			tickets = append(tickets, t)
 		}
+	}
+	if p.MinGap == 0 && len(p.SchoolZones) == 0 {
+		check(i - 1)
+		check(i + 1)
+	} else {
+		for j := range obs {
+			check(j)
+		}
+	}
+	// Issuing the candidate that ends first leaves the most days free for later tickets.
+	sort.SliceStable(tickets, func(a, b int) bool { return tickets[a].Timestamp2 < tickets[b].Timestamp2 })
+	return tickets
+}
 
-		// Calc speed
-		miles := math.Abs(float64(prev.mile) - float64(o.mile))
-		dur := prev.timestamp.Sub(o.timestamp).Abs()
-		speed := miles / dur.Hours()
+// CheckPair returns a ticket if the average speed between the observations was over the policy's threshold for the limit. Observations at the same time are ignored, since no speed can be calculated, as are observations closer together than the policy's MinGap.
+func checkPair(o1, o2 observation, camLimit uint16, p Policy) *message.Ticket {
+	first, second := orderObservations(o1, o2)
+	dur := second.timestamp.Sub(first.timestamp)
+	if dur <= 0 || dur < time.Duration(p.MinGap)*time.Second {
+		return nil
+	}
+	limit := p.limit(first.timestamp, second.timestamp, camLimit)
 
-		if speed > limit+0.5 {
-			first, second := orderObservations(o, *prev)
-			return &message.Ticket{
-				Plate:      o.plate,
-				Speed:      uint16(speed * 100),
-				Mile1:      first.mile,
-				Timestamp1: message.UnixTime(first.timestamp.Unix()),
-				Mile2:      second.mile,
-				Timestamp2: message.UnixTime(second.timestamp.Unix()),
-			}
-		}
+	// Calc speed
+	miles := math.Abs(float64(first.mile) - float64(second.mile))
+	speed := miles / dur.Hours()
+
+	// It is always required to ticket a car that is exceeding the speed limit by 0.5 mph or more, unless the road's policy allows more.
+	if speed < p.threshold(limit) {
+		return nil
+	}
+	return &message.Ticket{
+		Plate:      o1.plate,
+		Speed:      uint16(speed * 100),
+		Mile1:      first.mile,
+		Timestamp1: message.UnixTime(first.timestamp.Unix()),
+		Mile2:      second.mile,
+		Timestamp2: message.UnixTime(second.timestamp.Unix()),
+		Limit:      limit,
 	}
-	return nil
 }
 
 func orderObservations(obv1, obv2 observation) (earlier, later observation) {
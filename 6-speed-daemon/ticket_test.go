@@ -0,0 +1,204 @@
+package spdaemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/stretchr/testify/require"
+)
+
+// sighting is a plate observation along with the camera that made it.
+type sighting struct {
+	cam Camera
+	obs observation
+}
+
+// interval is the range of days a violation covers.
+type interval struct{ day1, day2 uint32 }
+
+func (iv interval) overlaps(o interval) bool {
+	return iv.day1 <= o.day2 && o.day1 <= iv.day2
+}
+
+// TestViolationEngine checks the tickets issued for random observations of a single car, under a random policy, against a brute-force search over every pair of observations.
+func TestViolationEngine(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for seed := int64(0); seed < 10000; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		sightings := randomSightings(rng)
+		policy := randomPolicy(rng)
+		inOrder := seed%2 == 0
+		if inOrder {
+			sort.Slice(sightings, func(i, j int) bool { return sightings[i].obs.timestamp.Before(sightings[j].obs.timestamp) })
+		}
+
+		t.Run(fmt.Sprintf("seed %d in order %t", seed, inOrder), func(t *testing.T) {
+			tickets := runEngine(sightings, policy)
+			violations := bruteForceViolations(sightings, policy)
+
+			// Every ticket is for a real violation.
+			for _, tk := range tickets {
+				require.Contains(t, violations, *tk, "ticket for a pair that isn't a violation")
+			}
+
+			// No more than one ticket per day.
+			for i := range tickets {
+				for j := i + 1; j < len(tickets); j++ {
+					require.False(t, ticketDays(tickets[i]).overlaps(ticketDays(tickets[j])), "tickets share a day: %+v %+v", tickets[i], tickets[j])
+				}
+			}
+
+			// Every violation shares a day with a ticket, or the server could have sent it.
+			for _, v := range violations {
+				covered := false
+				for _, tk := range tickets {
+					covered = covered || ticketDays(tk).overlaps(ticketDays(&v))
+				}
+				require.True(t, covered, "violation not ticketed: %+v", v)
+			}
+
+			// When observations arrive in order, the engine issues as many tickets as possible.
+			if inOrder {
+				intervals := make([]interval, 0, len(violations))
+				for i := range violations {
+					intervals = append(intervals, ticketDays(&violations[i]))
+				}
+				require.Equal(t, maxDisjoint(intervals, nil), len(tickets))
+			}
+		})
+	}
+}
+
+// randomSightings returns up to 4 observations of one car on each of 2 roads, spread over a few days with many close to midnight. Timestamps are distinct, since a car can't be in two places at once.
+func randomSightings(rng *rand.Rand) []sighting {
+	roads := []Camera{{Road: 1, Limit: 60}, {Road: 2, Limit: 40}}
+	used := make(map[int64]bool)
+	var sightings []sighting
+	for _, road := range roads {
+		n := rng.Intn(5)
+		for i := 0; i < n; i++ {
+			var ts int64
+			for ts == 0 || used[ts] {
+				if rng.Intn(2) == 0 {
+					ts = rng.Int63n(4 * 86400)
+				} else {
+					ts = int64(1+rng.Intn(3))*86400 - 3600 + rng.Int63n(7200)
+				}
+			}
+			used[ts] = true
+			cam := road
+			cam.Mile = uint16(rng.Intn(150))
+			sightings = append(sightings, sighting{
+				cam: cam,
+				obs: observation{plate: "UN1X", mile: cam.Mile, timestamp: time.Unix(ts, 0)},
+			})
+		}
+	}
+	rng.Shuffle(len(sightings), func(i, j int) { sightings[i], sightings[j] = sightings[j], sightings[i] })
+	return sightings
+}
+
+// randomPolicy returns a policy with a random tolerance, MinGap and school zones, each left unset half the time.
+func randomPolicy(rng *rand.Rand) Policy {
+	var p Policy
+	if rng.Intn(2) == 0 {
+		p.Tolerance = float64(rng.Intn(30))
+	}
+	if rng.Intn(2) == 0 {
+		p.MinGap = uint32(rng.Intn(1800))
+	}
+	if rng.Intn(2) == 0 {
+		for n := 1 + rng.Intn(2); n > 0; n-- {
+			// Most sightings are close to midnight, so most zones are too.
+			start := TimeOfDay(rng.Intn(24) * 3600)
+			if rng.Intn(2) == 0 {
+				start = TimeOfDay([]int{0, 22, 23}[rng.Intn(3)] * 3600)
+			}
+			end := min(start+TimeOfDay(1+rng.Intn(3))*3600, 86400)
+			p.SchoolZones = append(p.SchoolZones, SchoolZone{Start: start, End: end, Limit: uint16(5 + rng.Intn(30))})
+		}
+	}
+	return p
+}
+
+// runEngine sends the sightings through a server enforcing the policy on every road, in order, and returns the tickets it issued, ordered by time.
+func runEngine(sightings []sighting, p Policy) []*message.Ticket {
+	s := NewServer(WithPolicies(Policies{Default: p}))
+	ctx := context.WithValue(context.Background(), ctxKey(CONNECTION_ID), "test")
+	for _, sg := range sightings {
+		s.handlePlate(ctx, &message.Plate{Plate: sg.obs.plate, Timestamp: sg.obs.timestamp}, sg.cam)
+	}
+
+	var tickets []*message.Ticket
+	for _, r := range s.sortedRoads() {
+		tickets = append(tickets, r.outbox...)
+	}
+	sort.Slice(tickets, func(i, j int) bool { return tickets[i].Timestamp1 < tickets[j].Timestamp1 })
+	return tickets
+}
+
+// bruteForceViolations returns a ticket for every pair of observations on the same road, adjacent or not, where the car averaged over the policy's threshold.
+func bruteForceViolations(sightings []sighting, p Policy) []message.Ticket {
+	var violations []message.Ticket
+	for i, a := range sightings {
+		for _, b := range sightings[i+1:] {
+			if a.cam.Road != b.cam.Road {
+				continue
+			}
+			first, second := a, b
+			if second.obs.timestamp.Before(first.obs.timestamp) {
+				first, second = second, first
+			}
+			dur := second.obs.timestamp.Sub(first.obs.timestamp)
+			if dur < time.Duration(p.MinGap)*time.Second {
+				continue
+			}
+			limit := p.limit(first.obs.timestamp, second.obs.timestamp, a.cam.Limit)
+			speed := math.Abs(float64(first.obs.mile)-float64(second.obs.mile)) / dur.Hours()
+			if speed < p.threshold(limit) {
+				continue
+			}
+			violations = append(violations, message.Ticket{
+				Plate:      first.obs.plate,
+				Road:       a.cam.Road,
+				Mile1:      first.obs.mile,
+				Timestamp1: message.UnixTime(first.obs.timestamp.Unix()),
+				Mile2:      second.obs.mile,
+				Timestamp2: message.UnixTime(second.obs.timestamp.Unix()),
+				Speed:      uint16(speed * 100),
+				Limit:      limit,
+			})
+		}
+	}
+	return violations
+}
+
+func ticketDays(t *message.Ticket) interval {
+	return interval{t.Timestamp1.Day(), t.Timestamp2.Day()}
+}
+
+// maxDisjoint returns the size of the largest set of intervals that share no days, trying every subset.
+func maxDisjoint(intervals []interval, chosen []interval) int {
+	if len(intervals) == 0 {
+		return len(chosen)
+	}
+	next, rest := intervals[0], intervals[1:]
+	best := maxDisjoint(rest, chosen)
+	for _, c := range chosen {
+		if c.overlaps(next) {
+			return best
+		}
+	}
+	return max(best, maxDisjoint(rest, append(chosen, next)))
+}
@@ -0,0 +1,84 @@
+package spdaemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+)
+
+type (
+	// ConnWriter owns the write side of a client connection. Heartbeats, tickets and errors are written from different goroutines, so every write goes through the writer to keep frames from interleaving.
+	connWriter struct {
+		conn  net.Conn
+		clock clock
+		// How long a write may block before the client is given up on. Zero means no limit.
+		timeout time.Duration
+		mu      sync.Mutex
+		// Closed when the connection is done, to stop the heartbeat.
+		done      chan struct{}
+		closeOnce sync.Once
+		// Tracks the heartbeat goroutine, so close can wait for it.
+		heartbeat sync.WaitGroup
+	}
+)
+
+func newConnWriter(conn net.Conn, clk clock, timeout time.Duration) *connWriter {
+	return &connWriter{
+		conn:    conn,
+		clock:   clk,
+		timeout: timeout,
+		done:    make(chan struct{}),
+	}
+}
+
+// Write writes the whole message to the connection before any other message. A client that stops reading fails the write once the writer's timeout passes, rather than blocking every later write. A failed write may leave part of a frame on the wire, so it closes the connection.
+func (w *connWriter) write(m message.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timeout > 0 {
+		// Deadlines are wall clock times, whatever clock the writer ticks with.
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+			return fmt.Errorf("set write deadline: %w", err)
+		}
+	}
+	if _, err := w.conn.Write(m.MarshalBinary()); err != nil {
+		w.conn.Close()
+		return fmt.Errorf("write %s: %w", m.Type(), err)
+	}
+	return nil
+}
+
+// StartHeartbeat sends a Heartbeat every interval until the writer is closed, ctx is cancelled or a write fails. An interval of zero sends no heartbeats. The caller must only start one heartbeat per connection.
+func (w *connWriter) startHeartbeat(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := w.clock.NewTicker(interval)
+	w.heartbeat.Add(1)
+	go func() {
+		defer w.heartbeat.Done()
+		defer t.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C():
+				if err := w.write(&message.Heartbeat{}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat and waits for it to finish. It does not close the connection. Close the connection first if a write may be blocked on it.
+func (w *connWriter) close() {
+	w.closeOnce.Do(func() { close(w.done) })
+	w.heartbeat.Wait()
+}
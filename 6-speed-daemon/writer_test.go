@@ -0,0 +1,238 @@
+package spdaemon
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harveysanders/protohackers/6-speed-daemon/message"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// FakeClock only moves when advanced. Its tickers fire at exact multiples of their interval.
+	fakeClock struct {
+		mu      sync.Mutex
+		now     time.Time
+		tickers []*fakeTicker
+	}
+
+	fakeTicker struct {
+		c        chan time.Time
+		d        time.Duration
+		next     time.Time
+		stopped  chan struct{}
+		stopOnce sync.Once
+	}
+)
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time), d: d, next: c.now.Add(d), stopped: make(chan struct{})}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing every tick due along the way. It returns once each tick has been received or its ticker stopped.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	tickers := append([]*fakeTicker(nil), c.tickers...)
+	c.mu.Unlock()
+
+	for _, t := range tickers {
+		for !t.next.After(now) {
+			select {
+			case t.c <- t.next:
+			case <-t.stopped:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+}
+
+// Ticker waits for the nth ticker to be made.
+func (c *fakeClock) ticker(t *testing.T, n int) *fakeTicker {
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.tickers) > n
+	}, time.Second, time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tickers[n]
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() { t.stopOnce.Do(func() { close(t.stopped) }) }
+
+// byteConn writes one byte at a time, so concurrent writes interleave unless something serialises them.
+type byteConn struct {
+	net.Conn
+}
+
+func (c byteConn) Write(b []byte) (int, error) {
+	for i := range b {
+		if _, err := c.Conn.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+		runtime.Gosched()
+	}
+	return len(b), nil
+}
+
+func TestHeartbeatWriter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// connect starts handling a new connection, and returns the client end of it.
+	connect := func(t *testing.T, s *Server) net.Conn {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		ctx := context.WithValue(context.Background(), ctxKey(CONNECTION_ID), "test")
+		go s.HandleConnection(ctx, server)
+		return client
+	}
+
+	// requireSilent checks nothing is written to the connection.
+	requireSilent := func(t *testing.T, conn net.Conn) {
+		t.Helper()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Millisecond)))
+		_, err := conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	}
+
+	t.Run("beats every interval, to the decisecond", func(t *testing.T) {
+		clk := newFakeClock()
+		s := NewServer()
+		s.clock = clk
+		client := connect(t, s)
+
+		// A client doesn't need to identify itself to ask for heartbeats.
+		_, err := client.Write((&message.WantHeartbeat{Interval: 25 * message.HeartbeatUnit}).MarshalBinary())
+		require.NoError(t, err)
+		clk.ticker(t, 0)
+
+		dec := message.NewDecoder(client)
+		for ds := 1; ds <= 100; ds++ {
+			clk.advance(message.HeartbeatUnit)
+			if ds%25 != 0 {
+				requireSilent(t, client)
+				continue
+			}
+			msg, err := dec.Decode()
+			require.NoError(t, err)
+			require.IsType(t, &message.Heartbeat{}, msg, "decisecond %d", ds)
+		}
+	})
+
+	t.Run("stops when the client disconnects", func(t *testing.T) {
+		clk := newFakeClock()
+		s := NewServer()
+		s.clock = clk
+		client := connect(t, s)
+
+		_, err := client.Write((&message.WantHeartbeat{Interval: message.HeartbeatUnit}).MarshalBinary())
+		require.NoError(t, err)
+		tk := clk.ticker(t, 0)
+		clk.advance(message.HeartbeatUnit)
+		_, err = io.ReadFull(client, make([]byte, 1))
+		require.NoError(t, err)
+
+		client.Close()
+		select {
+		case <-tk.stopped:
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat still running after disconnect")
+		}
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		clk := newFakeClock()
+		server, client := net.Pipe()
+		defer client.Close()
+		w := newConnWriter(server, clk, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		w.startHeartbeat(ctx, message.HeartbeatUnit)
+		tk := clk.ticker(t, 0)
+		cancel()
+		w.close()
+		select {
+		case <-tk.stopped:
+		default:
+			t.Fatal("heartbeat still running after close")
+		}
+	})
+
+	t.Run("never interleaves heartbeats with tickets", func(t *testing.T) {
+		const n = 200
+		clk := newFakeClock()
+		server, client := net.Pipe()
+		defer client.Close()
+		w := newConnWriter(byteConn{server}, clk, 0)
+		defer w.close()
+		td := &TicketDispatcher{Roads: []uint16{1}, w: w}
+
+		w.startHeartbeat(context.Background(), message.HeartbeatUnit)
+		go clk.advance(n * message.HeartbeatUnit)
+		go func() {
+			for i := 0; i < n; i++ {
+				td.send(&message.Ticket{Plate: "UN1X", Road: 1, Mile1: 8, Timestamp1: message.UnixTime(i), Mile2: 9, Timestamp2: message.UnixTime(i + 45), Speed: 8000})
+			}
+		}()
+
+		// Interleaved frames may decode as a longer message than was sent.
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var heartbeats, tickets int
+		dec := message.NewDecoder(client)
+		for heartbeats+tickets < 2*n {
+			msg, err := dec.Decode()
+			require.NoError(t, err)
+			switch m := msg.(type) {
+			case *message.Heartbeat:
+				heartbeats++
+			case *message.Ticket:
+				require.Equal(t, message.UnixTime(tickets), m.Timestamp1)
+				tickets++
+			default:
+				t.Fatalf("unexpected %s", m.Type())
+			}
+		}
+		require.Equal(t, n, heartbeats)
+		require.Equal(t, n, tickets)
+	})
+
+	t.Run("write times out and closes the connection when the client stops reading", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		w := newConnWriter(server, newFakeClock(), 50*time.Millisecond)
+		err := w.write(&message.Heartbeat{})
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		_, err = client.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("write fails after the connection closes", func(t *testing.T) {
+		server, client := net.Pipe()
+		client.Close()
+		w := newConnWriter(server, newFakeClock(), 0)
+		err := w.write(&message.Heartbeat{})
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+}
@@ -0,0 +1,26 @@
+// Command isl-chat runs the budget chat server over Insecure Sockets Layer. Clients send a cipher spec first, then chat as usual.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	chat "github.com/harveysanders/protohackers/3-budget-chat"
+	isl "github.com/harveysanders/protohackers/8-insecure-sockets-layer"
+)
+
+func main() {
+	port := "9002"
+	if PORT := os.Getenv("PORT"); PORT != "" {
+		port = PORT
+	}
+
+	srv := isl.NewServer(isl.WithHandler(chat.NewServer()))
+	if err := srv.Start(port); err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Serve(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,51 @@
+// Command isl-spec converts ISL cipher specs between their text and wire forms.
+//
+//	isl-spec encode 'xor(1),reversebits,addpos'  # prints 02 01 01 05 00
+//	isl-spec decode '02 01 01 05 00'             # prints xor(1),reversebits,addpos
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	isl "github.com/harveysanders/protohackers/8-insecure-sockets-layer"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) != 3 {
+		log.Fatalf("usage: %s encode <spec> | decode <hex>", os.Args[0])
+	}
+
+	var spec isl.Spec
+	switch cmd, arg := os.Args[1], os.Args[2]; cmd {
+	case "encode":
+		var err error
+		if spec, err = isl.ParseSpec(arg); err != nil {
+			log.Fatal(err)
+		}
+		data, err := spec.MarshalBinary()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("% x\n", data)
+	case "decode":
+		data, err := hex.DecodeString(strings.Join(strings.Fields(arg), ""))
+		if err != nil {
+			log.Fatalf("invalid hex: %v", err)
+		}
+		if err := spec.UnmarshalBinary(data); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(spec)
+	default:
+		log.Fatalf("unknown command %q, want encode or decode", cmd)
+	}
+
+	if spec.IsNoOp() {
+		fmt.Fprintln(os.Stderr, "warning: cipher spec is a no-op, servers will disconnect")
+	}
+}
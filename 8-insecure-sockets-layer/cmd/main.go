@@ -6,6 +6,7 @@ import (
 	"os"
 
 	isl "github.com/harveysanders/protohackers/8-insecure-sockets-layer"
+	"github.com/harveysanders/protohackers/8-insecure-sockets-layer/orders"
 )
 
 func main() {
@@ -14,7 +15,16 @@ func main() {
 		port = PORT
 	}
 
-	srv := isl.Server{}
+	opts := []isl.Option{}
+	if AGGREGATION := os.Getenv("AGGREGATION"); AGGREGATION != "" {
+		aggregate, err := orders.ParseAggregation(AGGREGATION)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, isl.WithAggregation(aggregate))
+	}
+
+	srv := isl.NewServer(opts...)
 	if err := srv.Start(port); err != nil {
 		log.Fatal(err)
 	}
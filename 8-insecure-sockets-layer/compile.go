@@ -0,0 +1,154 @@
+package isl
+
+import "math/bits"
+
+type (
+	// Program is a cipher spec compiled for one direction. Runs of operations that don't depend on the stream position are folded into a single 256-byte lookup table, so a spec made only of reversebits, xor(N) and add(N) costs one table lookup per byte. Neighbouring position-dependent operations are merged too: a pair of xorpos cancels out, and addpos,addpos adds twice the position.
+	program []step
+
+	step struct {
+		kind  stepKind
+		n     byte      // Multiple of the position to add, for stepAddPos
+		table [256]byte // For stepTable
+	}
+
+	stepKind uint8
+)
+
+const (
+	stepTable  stepKind = iota // Replace the byte from the table.
+	stepXORPos                 // XOR the byte by its position.
+	stepAddPos                 // Add a multiple of the position to the byte.
+)
+
+// Compile builds the encode and decode programs from the cipher's operations.
+func (c *Cipher) compile() {
+	var enc program
+	for _, op := range c.spec {
+		var last *step
+		if len(enc) > 0 {
+			last = &enc[len(enc)-1]
+		}
+		switch op.Code {
+		case OpXORPos:
+			if last != nil && last.kind == stepXORPos {
+				enc = enc[:len(enc)-1]
+				continue
+			}
+			enc = append(enc, step{kind: stepXORPos})
+			continue
+		case OpAddPos:
+			if last != nil && last.kind == stepAddPos {
+				// Adding the position 256 times is a no-op.
+				if last.n++; last.n == 0 {
+					enc = enc[:len(enc)-1]
+				}
+				continue
+			}
+			enc = append(enc, step{kind: stepAddPos, n: 1})
+			continue
+		}
+
+		// Fold the operation into the table at the end of the program, starting a new one if needed.
+		if last == nil || last.kind != stepTable {
+			enc = append(enc, identityStep())
+		}
+		t := &enc[len(enc)-1].table
+		for i, b := range t {
+			t[i] = op.apply(b)
+		}
+	}
+
+	// Decoding undoes each step, last step first.
+	dec := make(program, len(enc))
+	for i, st := range enc {
+		inv := &dec[len(enc)-1-i]
+		switch st.kind {
+		case stepTable:
+			inv.kind = stepTable
+			for b, e := range st.table {
+				inv.table[e] = byte(b)
+			}
+		case stepXORPos:
+			inv.kind = stepXORPos
+		case stepAddPos:
+			inv.kind = stepAddPos
+			inv.n = -st.n
+		}
+	}
+
+	c.enc = enc.trim()
+	c.dec = dec.trim()
+}
+
+// Apply returns the result of the position-independent operation on b.
+func (op Op) apply(b byte) byte {
+	switch op.Code {
+	case OpReverseBits:
+		return bits.Reverse8(b)
+	case OpXOR:
+		return b ^ op.N
+	case OpAdd:
+		return b + op.N
+	}
+	return b
+}
+
+func identityStep() step {
+	st := step{kind: stepTable}
+	for i := range st.table {
+		st.table[i] = byte(i)
+	}
+	return st
+}
+
+// Trim drops table steps that leave every byte unchanged.
+func (p program) trim() program {
+	trimmed := p[:0]
+	for _, st := range p {
+		if st.kind == stepTable && st.table == identityStep().table {
+			continue
+		}
+		trimmed = append(trimmed, st)
+	}
+	return trimmed
+}
+
+// blockSize is how many bytes each step runs over before the next step starts. Small enough that the block stays in L1 cache between steps.
+const blockSize = 4096
+
+// Apply runs the program over src, which starts at pos in the stream, writing the result to dst. The buffer is processed a block at a time, and within a block each step runs over the whole block before the next, which keeps the inner loops tight.
+func (p program) apply(dst, src []byte, pos int) {
+	if len(dst) < len(src) {
+		panic("isl: output smaller than input")
+	}
+	for start := 0; start < len(src); start += blockSize {
+		end := min(start+blockSize, len(src))
+		block := dst[start:end]
+		copy(block, src[start:end])
+		p.applyBlock(block, pos+start)
+	}
+}
+
+// ApplyBlock runs each step over buf in place.
+func (p program) applyBlock(buf []byte, pos int) {
+	for i := range p {
+		st := &p[i]
+		switch st.kind {
+		case stepTable:
+			t := &st.table
+			for j, b := range buf {
+				buf[j] = t[b]
+			}
+		case stepXORPos:
+			for j, b := range buf {
+				buf[j] = b ^ byte(pos+j)
+			}
+		case stepAddPos:
+			n := st.n
+			for j, b := range buf {
+				buf[j] = b + n*byte(pos+j)
+			}
+		}
+	}
+}
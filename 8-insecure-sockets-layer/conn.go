@@ -0,0 +1,77 @@
+package isl
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Conn is a net.Conn obfuscated by a cipher spec. Reads are decoded and writes are encoded, each at its own position in the stream, which starts after the cipher spec. A Conn is safe for one reader and one writer at a time.
+type Conn struct {
+	net.Conn
+	cipher *Cipher
+
+	readMu sync.Mutex
+	dec    *StreamDecoder
+
+	writeMu  sync.Mutex
+	writePos int
+	// Reused for encoding, so the caller's buffer is left untouched.
+	writeBuf []byte
+}
+
+// NewConn wraps conn, which must be positioned just after the cipher spec.
+func NewConn(conn net.Conn, c *Cipher) *Conn {
+	return &Conn{Conn: conn, cipher: c, dec: NewStreamDecoder(conn, c, 0)}
+}
+
+// Dial connects to the ISL server at addr, sends the cipher spec and returns the obfuscated connection. The spec is checked before dialing.
+func Dial(addr string, spec []byte) (*Conn, error) {
+	var s Spec
+	if err := s.UnmarshalBinary(spec); err != nil {
+		return nil, fmt.Errorf("cipher spec: %w", err)
+	}
+	c := NewCipher()
+	if err := c.SetSpec(s); err != nil {
+		return nil, fmt.Errorf("cipher spec: %w", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	if _, err := conn.Write(spec); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write cipher spec: %w", err)
+	}
+	return NewConn(conn, c), nil
+}
+
+// Read reads and decodes data from the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.dec.Read(p)
+}
+
+// WriteTo decodes the connection into w until EOF or an error, for io.Copy.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.dec.WriteTo(w)
+}
+
+// Write encodes p and writes it to the connection. P is not modified.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if cap(c.writeBuf) < len(p) {
+		c.writeBuf = make([]byte, len(p))
+	}
+	buf := c.writeBuf[:len(p)]
+	c.cipher.Encode(buf, p, c.writePos)
+	n, err := c.Conn.Write(buf)
+	c.writePos += n
+	return n, err
+}
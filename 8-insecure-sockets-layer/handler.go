@@ -0,0 +1,86 @@
+package isl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/harveysanders/protohackers/8-insecure-sockets-layer/orders"
+)
+
+type (
+	// ConnHandler serves an application protocol over ISL. Reads from conn are already decoded and writes are encoded. HandleConnection returns when it is done with the client, and the server closes the connection after.
+	ConnHandler interface {
+		HandleConnection(ctx context.Context, conn net.Conn) error
+	}
+
+	// ConnHandlerFunc adapts a function to a ConnHandler.
+	ConnHandlerFunc func(ctx context.Context, conn net.Conn) error
+
+	// LineHandler answers each request line with a response line, both without the newline. An error disconnects the client.
+	LineHandler interface {
+		HandleLine(ctx context.Context, line []byte) ([]byte, error)
+	}
+
+	// LineHandlerFunc adapts a function to a LineHandler.
+	LineHandlerFunc func(ctx context.Context, line []byte) ([]byte, error)
+
+	lineServer struct {
+		h LineHandler
+	}
+
+	ctxKey string
+)
+
+// CONNECTION_ID is the context key for the ID of the client connection, an int.
+const CONNECTION_ID ctxKey = "CONNECTION_ID"
+
+// MaxLineLen is the longest request line a LineHandler is given, including the newline.
+const MaxLineLen = 5000
+
+func (f ConnHandlerFunc) HandleConnection(ctx context.Context, conn net.Conn) error {
+	return f(ctx, conn)
+}
+
+func (f LineHandlerFunc) HandleLine(ctx context.Context, line []byte) ([]byte, error) {
+	return f(ctx, line)
+}
+
+// ServeLines returns a ConnHandler that answers each newline-terminated request with h, one at a time.
+func ServeLines(h LineHandler) ConnHandler {
+	return lineServer{h: h}
+}
+
+func (s lineServer) HandleConnection(ctx context.Context, conn net.Conn) error {
+	scr := bufio.NewScanner(conn)
+	scr.Buffer(make([]byte, MaxLineLen), MaxLineLen)
+
+	for scr.Scan() {
+		resp, err := s.h.HandleLine(ctx, scr.Bytes())
+		if err != nil {
+			return fmt.Errorf("handle line: %w", err)
+		}
+
+		resp = append(resp, '\n')
+		if _, err := conn.Write(resp); err != nil {
+			return fmt.Errorf("conn.Write: %w", err)
+		}
+	}
+
+	if err := scr.Err(); err != nil {
+		return fmt.Errorf("scr.Err(): %w", err)
+	}
+	return nil
+}
+
+// AnswerOrders returns a LineHandler for the toy workshop: it answers each order with a.
+func AnswerOrders(a orders.Aggregation) LineHandler {
+	return LineHandlerFunc(func(ctx context.Context, line []byte) ([]byte, error) {
+		order, err := orders.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("orders.Parse: %w", err)
+		}
+		return []byte(a(order)), nil
+	})
+}
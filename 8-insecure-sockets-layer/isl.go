@@ -1,172 +1,107 @@
 package isl
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"math/bits"
+	"os"
 	"time"
 )
 
 const (
-	cipherEnd            = 0x00 // End of cipher spec.
-	operationReverseBits = 0x01 // Reverse the bits of the byte.
-	operationXORN        = 0x02 // XOR the byte by N.
-	operationXORPos      = 0x03 // XOR the byte by its position in the stream.
-	operationAddN        = 0x04 // Add N to the byte. If decoding, subtract N from the byte.
-	operationAddPos      = 0x05 // Add the position in the stream to the byte. If decoding, subtract the position from the byte.
-
-	MaxSpecLen    = 80  // Maximum length of the cipher spec.
-	maxEmptyReads = 100 // Maximum number of empty reads before returning EOF.
+	cipherEnd = 0x00 // End of cipher spec.
+
+	MaxSpecLen = 80 // Maximum length of the cipher spec.
 )
 
 var (
 	ErrMaxCipherSpecSize = errors.New("maximum cipher spec size exceeded")
-	ErrXOR0              = errors.New("xor(0) is invalid")
-	ErrAddN0             = errors.New("add(0) is invalid")
 	ErrNoOpCipher        = errors.New("cipher spec is a no-op")
 )
 
-type Operation = func(byte, int, bool) byte
+// Cipher obfuscates a byte stream with the operations of a cipher spec. The spec is compiled once, when it is set, into the fastest form it allows.
 type Cipher struct {
-	ops []Operation
+	spec Spec
+	enc  program
+	dec  program
 }
 
-// ReadFrom populates the cipher's operations from the spec
-// contained in the reader. The spec is translated to list of
-// operations to apply to a byte stream.
-// An error is returned for an invalid spec.
+// ReadFrom reads the cipher spec from the start of the stream and compiles it. It reads nothing past the end of the spec.
+// An error is returned for an invalid or no-op spec.
 func (c *Cipher) ReadFrom(r io.Reader) (int64, error) {
-	var err error
-	var nRead int64
-
-	header, err := readToDelimiter(r, cipherEnd)
-	nRead += int64(len(header))
+	spec, n, err := ReadSpec(r)
 	if err != nil {
-		return nRead, fmt.Errorf("rdr.ReadBytes: %w", err)
+		return n, fmt.Errorf("ReadSpec: %w", err)
 	}
+	return n, c.SetSpec(spec)
+}
 
-	rdr := bufio.NewReaderSize(bytes.NewReader(header), len(header))
-
-	for {
-		b, err := rdr.ReadByte()
-		if err != nil {
-			// Should never be EOF because of the Peek call above
-			return nRead, fmt.Errorf("rdr.ReadByte(): %w", err)
-		}
-		switch b {
-		case cipherEnd:
-			// Check for a noop cipher spec.
-			if c.IsNoOp() {
-				return nRead, ErrNoOpCipher
-			}
-			return nRead, nil
-		case operationReverseBits:
-			op := func(b byte, pos int, reverse bool) byte {
-				return byte(bits.Reverse8(uint8(b)))
-			}
-			c.ops = append(c.ops, op)
-		case operationXORN:
-			n, err := rdr.ReadByte()
-			if err != nil {
-				return nRead, fmt.Errorf("operandXORN ReadByte(): %w", err)
-			}
-
-			// Note that 0 is a valid value for N
-			if n == 0 {
-				return nRead, ErrXOR0
-			}
-			// XOR the byte by the value N.
-			op := func(b byte, pos int, reverse bool) byte {
-				return b ^ n
-			}
-			c.ops = append(c.ops, op)
-		case operationXORPos:
-			// XOR the byte by its position in the stream, starting from 0.
-			op := func(b byte, pos int, reverse bool) byte {
-				return b ^ byte(pos)
-			}
-			c.ops = append(c.ops, op)
-		case operationAddN:
-			n, err := rdr.ReadByte()
-			if err != nil {
-				return nRead, fmt.Errorf("operandAddN ReadByte: %w", err)
-			}
-
-			// Note that 0 is a valid value for N
-			if n == 0 {
-				return nRead, ErrAddN0
-			}
-			//  Add N to the byte, modulo 256.
-			//  Addition wraps, so that 255+1=0, 255+2=1, and so on.
-			op := func(b byte, pos int, reverse bool) byte {
-				if reverse {
-					return byte((uint(b) - uint(n)) % 256)
-				}
-				return byte((uint(b) + uint(n)) % 256)
-			}
-			c.ops = append(c.ops, op)
-		case operationAddPos:
-			op := func(b byte, pos int, reverse bool) byte {
-				if reverse {
-					return byte((uint(b) - uint(pos)) % 256)
-				}
-				return byte((uint(b) + uint(pos)) % 256)
-			}
-			c.ops = append(c.ops, op)
-		}
+// SetSpec compiles the spec into the cipher. It returns ErrNoOpCipher if the spec leaves every byte unchanged.
+func (c *Cipher) SetSpec(s Spec) error {
+	c.spec = s
+	c.compile()
+	if c.IsNoOp() {
+		return ErrNoOpCipher
 	}
+	return nil
+}
+
+// Spec returns the cipher's spec.
+func (c *Cipher) Spec() Spec {
+	return c.spec
 }
 
 // NewCipher creates a Cipher.
 func NewCipher() *Cipher {
-	return &Cipher{ops: []Operation{}}
+	return &Cipher{spec: Spec{}}
 }
 
-// Encode serially applies each operation in the Cipher,
-// one byte at a time, and returns the resulting byte slice.
-func (c Cipher) Encode(in []byte, streamPos int) []byte {
-	out := make([]byte, len(in))
-	_ = copy(out, in)
-	for _, op := range c.ops {
-		for bytePos, b := range out {
-			out[bytePos] = op(b, streamPos+bytePos, false)
-		}
-	}
-	return out
+// Encode applies the cipher to src, which starts at streamPos in the stream, and writes the result to dst. Dst must be at least as long as src. Dst and src may be the same slice, to encode in place without allocating.
+func (c *Cipher) Encode(dst, src []byte, streamPos int) {
+	c.enc.apply(dst, src, streamPos)
 }
 
-// Decode applies the cipher's operations in reverse order.
-func (c Cipher) Decode(in []byte, streamPos int) []byte {
-	out := make([]byte, len(in))
-	_ = copy(out, in)
-	for i := len(c.ops) - 1; i >= 0; i-- {
-		op := c.ops[i]
-		for bytePos, b := range out {
-			out[bytePos] = op(b, streamPos+bytePos, true)
-		}
-	}
-	return out
+// Decode applies the inverse of the cipher to src, which starts at streamPos in the stream, and writes the result to dst. Dst must be at least as long as src. Dst and src may be the same slice, to decode in place without allocating.
+func (c *Cipher) Decode(dst, src []byte, streamPos int) {
+	c.dec.apply(dst, src, streamPos)
 }
 
-// IsNoOp returns true if the cipher spec leaves every byte unchanged, e.g. no-op.
+// IsNoOp returns true if the cipher leaves every byte unchanged, e.g. no-op. The operations only see the position modulo 256, so it checks every byte value at every position modulo 256.
 func (c *Cipher) IsNoOp() bool {
-	// "hello world\n"
-	sample := []byte{0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x20, 0x77, 0x6f, 0x72, 0x6c, 0x64, 0x0a}
-	encoded := c.Encode(sample, 0)
-	return bytes.Equal(sample, encoded)
+	if len(c.enc) == 0 {
+		return true
+	}
+	var buf [256]byte
+	for pos := 0; pos < 256; pos++ {
+		// Byte value i is checked at position pos+i.
+		for i := range buf {
+			buf[i] = byte(i)
+		}
+		c.Encode(buf[:], buf[:], pos)
+		for i, b := range buf {
+			if b != byte(i) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-type StreamDecoder struct {
-	encoded    io.Reader
-	cipher     Cipher
-	pos        int
-	emptyReads int
-}
+type (
+	// StreamDecoder decodes a stream as it is read. Reads block on the underlying reader, and its EOF and errors are returned unchanged. A StreamDecoder is not safe for concurrent use.
+	StreamDecoder struct {
+		encoded io.Reader
+		cipher  *Cipher
+		pos     int
+	}
+
+	readDeadliner interface {
+		SetReadDeadline(t time.Time) error
+	}
+)
 
-func NewStreamDecoder(r io.Reader, c Cipher, pos int) *StreamDecoder {
+// NewStreamDecoder decodes r with c. Pos is the position in the stream of the first byte read from r.
+func NewStreamDecoder(r io.Reader, c *Cipher, pos int) *StreamDecoder {
 	return &StreamDecoder{
 		encoded: r,
 		cipher:  c,
@@ -174,47 +109,44 @@ func NewStreamDecoder(r io.Reader, c Cipher, pos int) *StreamDecoder {
 	}
 }
 
+// Read reads encoded bytes into p and decodes them in place.
 func (s *StreamDecoder) Read(p []byte) (int, error) {
-	buf := make([]byte, len(p))
+	n, err := s.encoded.Read(p)
+	s.cipher.Decode(p[:n], p[:n], s.pos)
+	s.pos += n
+	return n, err
+}
+
+// WriteTo decodes the stream into w until EOF or an error. It lets io.Copy decode without an extra buffer.
+func (s *StreamDecoder) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, 32<<10)
+	var written int64
 	for {
-		n, err := s.encoded.Read(buf)
+		n, rErr := s.Read(buf)
 		if n > 0 {
-			s.emptyReads = 0
-			decoded := s.cipher.Decode(buf[:n], s.pos)
-			_ = copy(p, decoded)
-			s.pos += n
-			return n, err
+			nw, wErr := w.Write(buf[:n])
+			written += int64(nw)
+			if wErr != nil {
+				return written, wErr
+			}
+			if nw != n {
+				return written, io.ErrShortWrite
+			}
 		}
-		// Empty read
-		s.emptyReads++
-		if s.emptyReads > maxEmptyReads {
-			return 0, io.EOF
+		if rErr == io.EOF {
+			return written, nil
 		}
-		if err != nil {
-			return 0, err
+		if rErr != nil {
+			return written, rErr
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
 }
 
-func readToDelimiter(r io.Reader, delim byte) ([]byte, error) {
-	nRead := 0
-
-	buf := make([]byte, 1)
-	var results []byte
-	for {
-		n, err := r.Read(buf)
-		nRead += n
-		if nRead > MaxSpecLen {
-			return nil, ErrMaxCipherSpecSize
-		}
-
-		if err != nil {
-			return nil, err
-		}
-		if buf[0] == delim {
-			return append(results, buf[0]), nil
-		}
-		results = append(results, buf[0])
+// SetReadDeadline sets the deadline for Reads blocked on the underlying reader, as in net.Conn. It returns os.ErrNoDeadline if the reader has no deadlines.
+func (s *StreamDecoder) SetReadDeadline(t time.Time) error {
+	d, ok := s.encoded.(readDeadliner)
+	if !ok {
+		return os.ErrNoDeadline
 	}
+	return d.SetReadDeadline(t)
 }
@@ -2,8 +2,15 @@ package isl_test
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"math/bits"
+	"math/rand"
+	"net"
 	"os"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	isl "github.com/harveysanders/protohackers/8-insecure-sockets-layer"
 	"github.com/stretchr/testify/require"
@@ -56,7 +63,8 @@ func TestEncode(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, len(tc.cipherSpec), int(n))
 
-			got := cipher.Encode(tc.input, 0)
+			got := make([]byte, len(tc.input))
+			cipher.Encode(got, tc.input, 0)
 			require.Equal(t, tc.want, got)
 		})
 	}
@@ -92,44 +100,13 @@ func TestDecode(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, len(tc.cipherSpec), int(n))
 
-			got := cipher.Decode(tc.input, 0)
+			got := make([]byte, len(tc.input))
+			cipher.Decode(got, tc.input, 0)
 			require.Equal(t, tc.want, got)
 		})
 	}
 }
 
-func TestEdgeCases(t *testing.T) {
-	testCases := []struct {
-		desc       string
-		cipherSpec []byte
-		input      []byte
-		want       []byte
-	}{
-		{
-			desc:       "long message with inline newline",
-			cipherSpec: edgeCase1.cipherSpec,
-			input:      edgeCase1.reqs[1],
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.desc, func(t *testing.T) {
-			cipher := isl.NewCipher()
-			n, err := cipher.ReadFrom(bytes.NewReader(tc.cipherSpec))
-			require.NoError(t, err)
-			require.Equal(t, len(tc.cipherSpec), int(n))
-
-			got := cipher.Decode(tc.input, 0)
-			f, err := os.Create("./got2.txt")
-			require.NoError(t, err)
-			defer f.Close()
-			_, err = f.Write(got)
-			require.NoError(t, err)
-			bytes.Contains(got, []byte{'\n'})
-		})
-	}
-}
-
 func TestDecodeCase(t *testing.T) {
 	cipher := isl.NewCipher()
 	_, err := cipher.ReadFrom(bytes.NewReader(edgeCase1.cipherSpec))
@@ -160,16 +137,304 @@ func TestDecodeCase(t *testing.T) {
 	offset := 0
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
-			got := cipher.Decode(tc.encoded, offset)
+			got := make([]byte, len(tc.encoded))
+			cipher.Decode(got, tc.encoded, offset)
 			require.Equal(t, string(tc.want), string(got))
 			offset += len(tc.encoded)
 		})
 	}
+}
+
+func TestConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// accept returns the server end of the next connection, with the cipher spec read.
+	accept := func(t *testing.T) (net.Conn, *isl.Cipher) {
+		raw, err := l.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { raw.Close() })
+		cipher := isl.NewCipher()
+		_, err = cipher.ReadFrom(raw)
+		require.NoError(t, err)
+		return raw, cipher
+	}
+
+	t.Run("encodes writes at their stream position", func(t *testing.T) {
+		conn, err := isl.Dial(l.Addr().String(), edgeCase1.cipherSpec)
+		require.NoError(t, err)
+		defer conn.Close()
+		raw, _ := accept(t)
+
+		msg := []byte("hello world")
+		for _, part := range [][]byte{msg[:5], msg[5:]} {
+			_, err := conn.Write(part)
+			require.NoError(t, err)
+		}
+		require.Equal(t, "hello world", string(msg), "Write must not modify its input")
 
-	decoded := cipher.Decode(edgeCase1.reqs[1], 4250)
-	os.WriteFile("./decoded.txt", decoded, 0644)
-	chunks := bytes.Split(decoded, []byte{'\n'})
-	require.Equal(t, 3, len(chunks))
-	require.Equal(t, "4x dog,5x car\n", string(decoded))
+		got := make([]byte, len(msg))
+		_, err = io.ReadFull(raw, got)
+		require.NoError(t, err)
+		require.Equal(t, naiveEncode(edgeCase1.cipherSpec, msg, 0), got)
+	})
+
+	t.Run("round trips through an echo server", func(t *testing.T) {
+		conn, err := isl.Dial(l.Addr().String(), edgeCase1.cipherSpec)
+		require.NoError(t, err)
+		defer conn.Close()
+		raw, cipher := accept(t)
+		echo := isl.NewConn(raw, cipher)
+		go io.Copy(echo, echo)
+
+		data := make([]byte, 1<<20)
+		rand.New(rand.NewSource(1)).Read(data)
+		go func() {
+			// Uneven writes, so reads and writes are at different positions.
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 777)
+				if _, err := conn.Write(rest[:n]); err != nil {
+					return
+				}
+				rest = rest[n:]
+			}
+		}()
+
+		got := make([]byte, len(data))
+		_, err = io.ReadFull(conn, got)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+	})
+
+	t.Run("rejects invalid specs before dialing", func(t *testing.T) {
+		_, err := isl.Dial(l.Addr().String(), []byte{0x02, 0x00, 0x00})
+		require.ErrorIs(t, err, isl.ErrNoOpCipher)
+
+		_, err = isl.Dial(l.Addr().String(), []byte{0x01, 0x00, 0x01})
+		require.Error(t, err)
+	})
+}
+
+func TestStreamDecoder(t *testing.T) {
+	// xor(123),addpos,reversebits
+	cipher := isl.NewCipher()
+	_, err := cipher.ReadFrom(bytes.NewReader([]byte{0x02, 0x7b, 0x05, 0x01, 0x00}))
+	require.NoError(t, err)
+	encode := func(msg string, pos int) []byte {
+		enc := make([]byte, len(msg))
+		cipher.Encode(enc, []byte(msg), pos)
+		return enc
+	}
 
+	t.Run("blocks on idle clients", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		sd := isl.NewStreamDecoder(server, cipher, 0)
+
+		go func() {
+			time.Sleep(1500 * time.Millisecond)
+			client.Write(encode("4x dog\n", 0))
+		}()
+
+		start := time.Now()
+		got, err := io.ReadAll(io.LimitReader(sd, 7))
+		require.NoError(t, err)
+		require.Equal(t, "4x dog\n", string(got))
+		require.Greater(t, time.Since(start), time.Second)
+	})
+
+	t.Run("returns the reader's EOF and errors", func(t *testing.T) {
+		sd := isl.NewStreamDecoder(iotest.DataErrReader(bytes.NewReader(encode("car", 5))), cipher, 5)
+		p := make([]byte, 10)
+		n, err := sd.Read(p)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, "car", string(p[:n]))
+
+		errBroken := errors.New("broken")
+		sd = isl.NewStreamDecoder(iotest.ErrReader(errBroken), cipher, 0)
+		_, err = sd.Read(p)
+		require.ErrorIs(t, err, errBroken)
+	})
+
+	t.Run("times out at the read deadline", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		sd := isl.NewStreamDecoder(server, cipher, 0)
+
+		require.NoError(t, sd.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+		_, err := sd.Read(make([]byte, 1))
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		// Clearing the deadline blocks until data arrives again.
+		require.NoError(t, sd.SetReadDeadline(time.Time{}))
+		go client.Write(encode("x", 0))
+		p := make([]byte, 1)
+		_, err = sd.Read(p)
+		require.NoError(t, err)
+		require.Equal(t, "x", string(p))
+
+		sd = isl.NewStreamDecoder(bytes.NewReader(nil), cipher, 0)
+		require.ErrorIs(t, sd.SetReadDeadline(time.Now()), os.ErrNoDeadline)
+	})
+
+	t.Run("decodes through io.Copy", func(t *testing.T) {
+		data := bytes.Repeat([]byte("10x toy car,15x dog on a string\n"), 5000)
+		enc := make([]byte, len(data))
+		cipher.Encode(enc, data, 100)
+
+		var got bytes.Buffer
+		// Hide the buffer's ReadFrom so io.Copy uses WriteTo.
+		n, err := io.Copy(struct{ io.Writer }{&got}, isl.NewStreamDecoder(bytes.NewReader(enc), cipher, 100))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), n)
+		require.Equal(t, data, got.Bytes())
+
+		errBroken := errors.New("broken")
+		r := io.MultiReader(bytes.NewReader(enc[:10]), iotest.ErrReader(errBroken))
+		n, err = io.Copy(io.Discard, isl.NewStreamDecoder(r, cipher, 0))
+		require.ErrorIs(t, err, errBroken)
+		require.Equal(t, int64(10), n)
+	})
+}
+
+// naiveEncode applies each operation of the spec to each byte, one at a time. It is the reference the compiled cipher is checked against.
+func naiveEncode(spec []byte, in []byte, streamPos int) []byte {
+	out := make([]byte, len(in))
+	for i, b := range in {
+		pos := byte(streamPos + i)
+		for j := 0; spec[j] != 0x00; j++ {
+			switch spec[j] {
+			case 0x01:
+				b = bits.Reverse8(b)
+			case 0x02:
+				j++
+				b ^= spec[j]
+			case 0x03:
+				b ^= pos
+			case 0x04:
+				j++
+				b += spec[j]
+			case 0x05:
+				b += pos
+			}
+		}
+		out[i] = b
+	}
+	return out
+}
+
+// randomSpec returns a valid cipher spec of up to n operations.
+func randomSpec(rng *rand.Rand, n int, positional bool) []byte {
+	var spec []byte
+	for i := rng.Intn(n) + 1; i > 0; i-- {
+		op := byte(rng.Intn(5) + 1)
+		if !positional && (op == 0x03 || op == 0x05) {
+			op = 0x01
+		}
+		spec = append(spec, op)
+		if op == 0x02 || op == 0x04 {
+			spec = append(spec, byte(rng.Intn(255)+1))
+		}
+	}
+	return append(spec, 0x00)
+}
+
+func TestCompiledCipher(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 1000)
+	rng.Read(data)
+
+	for i := 0; i < 500; i++ {
+		spec := randomSpec(rng, 20, i%2 == 0)
+		cipher := isl.NewCipher()
+		if _, err := cipher.ReadFrom(bytes.NewReader(spec)); err != nil {
+			require.ErrorIs(t, err, isl.ErrNoOpCipher)
+			continue
+		}
+		pos := rng.Intn(10_000)
+
+		want := naiveEncode(spec, data, pos)
+		got := make([]byte, len(data))
+		cipher.Encode(got, data, pos)
+		require.Equal(t, want, got, "spec % x", spec)
+
+		// In place
+		cipher.Decode(got, got, pos)
+		require.Equal(t, data, got, "spec % x", spec)
+	}
+
+	t.Run("transforms in place without allocating", func(t *testing.T) {
+		cipher := isl.NewCipher()
+		_, err := cipher.ReadFrom(bytes.NewReader(edgeCase1.cipherSpec))
+		require.NoError(t, err)
+		buf := make([]byte, 4096)
+		allocs := testing.AllocsPerRun(100, func() {
+			cipher.Encode(buf, buf, 10)
+			cipher.Decode(buf, buf, 10)
+		})
+		require.Zero(t, allocs)
+
+		sd := isl.NewStreamDecoder(bytes.NewReader(make([]byte, 1<<20)), cipher, 0)
+		allocs = testing.AllocsPerRun(100, func() {
+			_, err := sd.Read(buf)
+			require.NoError(t, err)
+		})
+		require.Zero(t, allocs)
+	})
+}
+
+func BenchmarkCipher(b *testing.B) {
+	const size = 4 << 20
+	specs := []struct {
+		name string
+		spec []byte
+	}{
+		// Folds into a single lookup table.
+		{name: "xor(123),add(5),reversebits", spec: []byte{0x02, 0x7b, 0x04, 0x05, 0x01, 0x00}},
+		{name: "xor(123),addpos,reversebits", spec: []byte{0x02, 0x7b, 0x05, 0x01, 0x00}},
+		{name: "from the wild", spec: edgeCase1.cipherSpec},
+	}
+
+	for _, s := range specs {
+		cipher := isl.NewCipher()
+		if _, err := cipher.ReadFrom(bytes.NewReader(s.spec)); err != nil {
+			b.Fatal(err)
+		}
+		buf := make([]byte, size)
+		rand.New(rand.NewSource(1)).Read(buf)
+
+		b.Run("encode "+s.name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cipher.Encode(buf, buf, i)
+			}
+		})
+
+		b.Run("decode "+s.name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cipher.Decode(buf, buf, i)
+			}
+		})
+
+		// Reads the whole stream in 32KB chunks, like io.Copy.
+		b.Run("stream "+s.name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			p := make([]byte, 32<<10)
+			r := bytes.NewReader(buf)
+			for i := 0; i < b.N; i++ {
+				r.Reset(buf)
+				sd := isl.NewStreamDecoder(r, cipher, 0)
+				for {
+					if _, err := sd.Read(p); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
 }
@@ -0,0 +1,76 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Aggregation answers an order with one line of text, without the trailing newline.
+type Aggregation func(Order) string
+
+var ErrUnknownAggregation = errors.New("unknown aggregation")
+
+// AggregateMost answers with the toy with the most copies to make. It is the answer the protocol asks for.
+func AggregateMost(o Order) string {
+	return o.Most().String()
+}
+
+// AggregateTop answers with the n toys with the most copies to make, most first, like "15x dog on a string,10x toy car".
+func AggregateTop(n int) Aggregation {
+	return func(o Order) string {
+		return o.Top(n).String()
+	}
+}
+
+// AggregateTotal answers with the total number of toys to make, like "29".
+func AggregateTotal(o Order) string {
+	total := new(big.Int)
+	for _, t := range o {
+		total.Add(total, new(big.Int).SetUint64(t.Qty))
+	}
+	return total.String()
+}
+
+// AggregateByName answers with the quantities of toys with the same name added together, in the order each name first appears, like "12x toy car,4x inflatable motorcycle".
+func AggregateByName(o Order) string {
+	var names []string
+	qty := map[string]*big.Int{}
+	for _, t := range o {
+		sum, ok := qty[t.Name]
+		if !ok {
+			sum = new(big.Int)
+			qty[t.Name] = sum
+			names = append(names, t.Name)
+		}
+		sum.Add(sum, new(big.Int).SetUint64(t.Qty))
+	}
+
+	toys := make([]string, len(names))
+	for i, name := range names {
+		toys[i] = qty[name].String() + "x " + name
+	}
+	return strings.Join(toys, ",")
+}
+
+// ParseAggregation returns the aggregation with the given name: "most", "top:N", "total" or "byname".
+func ParseAggregation(name string) (Aggregation, error) {
+	switch name {
+	case "most":
+		return AggregateMost, nil
+	case "total":
+		return AggregateTotal, nil
+	case "byname":
+		return AggregateByName, nil
+	}
+	if n, ok := strings.CutPrefix(name, "top:"); ok {
+		top, err := strconv.Atoi(n)
+		if err != nil || top < 1 {
+			return nil, fmt.Errorf("%w %q: want top:N, with N at least 1", ErrUnknownAggregation, name)
+		}
+		return AggregateTop(top), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownAggregation, name)
+}
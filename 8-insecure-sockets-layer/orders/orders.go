@@ -6,27 +6,137 @@ import (
 	"fmt"
 	"slices"
 	"strconv"
-	"strings"
 )
 
-// toyQty takes an ASCII line of a toy and returns the
-// quantity of toys to make.
+type (
+	// Toy is one entry of an order, like "10x toy car".
+	Toy struct {
+		Qty  uint64
+		Name string
+	}
+
+	// Order is a request for toys, in the order they were listed.
+	Order []Toy
+
+	// ParseError reports where an order line is invalid.
+	ParseError struct {
+		Col int // Column of the offending byte, starting from 1.
+		Msg string
+	}
+)
+
+var ErrInvalidOrder = errors.New("invalid order")
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at column %d: %s", ErrInvalidOrder, e.Col, e.Msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrInvalidOrder
+}
+
+// String returns the toy as it is written in an order, like "10x toy car".
+func (t Toy) String() string {
+	return strconv.FormatUint(t.Qty, 10) + "x " + t.Name
+}
+
+// Parse parses an ASCII line of a comma-separated list of toys to make. Whitespace around each toy, and between the quantity, "x" and name, is ignored. A trailing newline is allowed.
 //
 // Ex:
 //
-//	line:= []byte("10x toy car")
-//	toyQty(line) => 10
-func toyQty(in []byte) (int, error) {
-	parts := bytes.Split(in, []byte{'x'})
-	if len(parts) < 2 {
-		return 0, fmt.Errorf("invalid request %q", in)
-	}
-	rawQty := parts[0]
-	qty, err := strconv.Atoi(string(rawQty))
+//	line := []byte("10x toy car,15x dog on a string,4x inflatable motorcycle\n")
+//	Parse(line) => Order{{10, "toy car"}, {15, "dog on a string"}, {4, "inflatable motorcycle"}}
+func Parse(line []byte) (Order, error) {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+
+	var order Order
+	col := 1
+	for _, field := range bytes.Split(line, []byte{','}) {
+		toy, err := parseToy(field, col)
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, toy)
+		col += len(field) + 1
+	}
+	return order, nil
+}
+
+// ParseToy parses one toy of an order. Col is the column the toy starts at in the line.
+func parseToy(in []byte, col int) (Toy, error) {
+	i := skipSpace(in, 0)
+	start := i
+	for i < len(in) && '0' <= in[i] && in[i] <= '9' {
+		i++
+	}
+	if i == start {
+		return Toy{}, &ParseError{Col: col + i, Msg: "want a quantity"}
+	}
+	qty, err := strconv.ParseUint(string(in[start:i]), 10, 64)
 	if err != nil {
-		return 0, err
+		return Toy{}, &ParseError{Col: col + start, Msg: fmt.Sprintf("quantity %s is too large", in[start:i])}
+	}
+
+	i = skipSpace(in, i)
+	if i == len(in) || in[i] != 'x' {
+		return Toy{}, &ParseError{Col: col + i, Msg: `want "x" after the quantity`}
+	}
+
+	// Everything after the first "x" is the name, even if it contains more.
+	name := bytes.TrimSpace(in[i+1:])
+	if len(name) == 0 {
+		return Toy{}, &ParseError{Col: col + i + 1, Msg: "want a toy name"}
+	}
+	return Toy{Qty: qty, Name: string(name)}, nil
+}
+
+func skipSpace(in []byte, i int) int {
+	for i < len(in) && (in[i] == ' ' || in[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// Most returns the toy with the most copies to make. If several toys share the most, the first is returned.
+func (o Order) Most() Toy {
+	if len(o) == 0 {
+		return Toy{}
+	}
+	most := o[0]
+	for _, t := range o[1:] {
+		if t.Qty > most.Qty {
+			most = t
+		}
 	}
-	return qty, nil
+	return most
+}
+
+// Top returns the n toys with the most copies to make, most first. Toys with the same quantity keep their order.
+func (o Order) Top(n int) Order {
+	top := slices.Clone(o)
+	slices.SortStableFunc(top, func(a, b Toy) int {
+		switch {
+		case a.Qty > b.Qty:
+			return -1
+		case a.Qty < b.Qty:
+			return 1
+		}
+		return 0
+	})
+	return top[:min(n, len(top))]
+}
+
+// String returns the order as a comma-separated list of toys.
+func (o Order) String() string {
+	var b bytes.Buffer
+	for i, t := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(t.String())
+	}
+	return b.String()
 }
 
 // MostCopies takes and ASCII line of a comma-separated
@@ -36,28 +146,11 @@ func toyQty(in []byte) (int, error) {
 // Ex:
 //
 //	line:= []byte("10x toy car,15x dog on a string,4x inflatable motorcycle\n"
-//	MostCopies(line) => []byte("15x dog on a string\n")
+//	MostCopies(line) => []byte("15x dog on a string")
 func MostCopies(in []byte) ([]byte, error) {
-	toys := bytes.Split(in, []byte{','})
-	if len(toys) == 0 {
-		return []byte{}, nil
-	}
-	errs := []string{}
-	max := slices.MaxFunc(toys, func(a, b []byte) int {
-		aQty, aErr := toyQty(a)
-		if aErr != nil {
-			errs = append(errs, aErr.Error())
-		}
-
-		bQty, bErr := toyQty(b)
-		if bErr != nil {
-			errs = append(errs, bErr.Error())
-		}
-		return aQty - bQty
-	})
-
-	if len(errs) > 0 {
-		return []byte{}, errors.New(strings.Join(errs, ","))
+	order, err := Parse(in)
+	if err != nil {
+		return nil, err
 	}
-	return max, nil
+	return []byte(order.Most().String()), nil
 }
@@ -9,13 +9,113 @@ import (
 
 func TestMustCopies(t *testing.T) {
 	t.Run("returns the toy with the most requested copies", func(t *testing.T) {
-		input := "10x toy car,15x dog on a string,4x inflatable motorcycle"
+		input := []byte("10x toy car,15x dog on a string,4x inflatable motorcycle")
 
-		want := "15x dog on a string"
+		want := []byte("15x dog on a string")
 
 		got, err := orders.MostCopies(input)
 		require.NoError(t, err)
 		require.Equal(t, want, got)
+	})
+
+	t.Run("breaks ties with the first toy", func(t *testing.T) {
+		got, err := orders.MostCopies([]byte("3x rat,2x cat,3x bat\n"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("3x rat"), got)
+	})
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		desc string
+		line string
+		want orders.Order
+	}{
+		{
+			desc: "example order",
+			line: "10x toy car,15x dog on a string,4x inflatable motorcycle\n",
+			want: orders.Order{{Qty: 10, Name: "toy car"}, {Qty: 15, Name: "dog on a string"}, {Qty: 4, Name: "inflatable motorcycle"}},
+		},
+		{
+			desc: "whitespace",
+			line: " 10x toy car ,\t15 x  dog on a string\r\n",
+			want: orders.Order{{Qty: 10, Name: "toy car"}, {Qty: 15, Name: "dog on a string"}},
+		},
+		{
+			desc: "x inside names",
+			line: "2x xylophone,3x box of 6x6 tiles",
+			want: orders.Order{{Qty: 2, Name: "xylophone"}, {Qty: 3, Name: "box of 6x6 tiles"}},
+		},
+		{
+			desc: "large quantities",
+			line: "2147483648x pebble,18446744073709551615x grain of sand",
+			want: orders.Order{{Qty: 1 << 31, Name: "pebble"}, {Qty: 1<<64 - 1, Name: "grain of sand"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := orders.Parse([]byte(tc.line))
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
 
+	errCases := []struct {
+		line    string
+		wantCol int
+	}{
+		{line: "", wantCol: 1},
+		{line: "toy car", wantCol: 1},
+		{line: "10x toy car,,4x kite", wantCol: 13},
+		{line: "10x toy car, 15 dog", wantCol: 17},
+		{line: "10x toy car,4x ", wantCol: 15},
+		{line: "18446744073709551616x grain of sand", wantCol: 1},
+		{line: "1x car,-2x car", wantCol: 8},
+	}
+
+	for _, tc := range errCases {
+		t.Run(tc.line, func(t *testing.T) {
+			_, err := orders.Parse([]byte(tc.line))
+			require.ErrorIs(t, err, orders.ErrInvalidOrder)
+			var perr *orders.ParseError
+			require.ErrorAs(t, err, &perr)
+			require.Equal(t, tc.wantCol, perr.Col)
+		})
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	order, err := orders.Parse([]byte("10x toy car,15x dog on a string,4x inflatable motorcycle,2x toy car"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{name: "most", want: "15x dog on a string"},
+		{name: "top:2", want: "15x dog on a string,10x toy car"},
+		{name: "top:10", want: "15x dog on a string,10x toy car,4x inflatable motorcycle,2x toy car"},
+		{name: "total", want: "31"},
+		{name: "byname", want: "12x toy car,15x dog on a string,4x inflatable motorcycle"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			aggregate, err := orders.ParseAggregation(tc.name)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, aggregate(order))
+		})
+	}
+
+	t.Run("sums past the largest quantity", func(t *testing.T) {
+		order := orders.Order{{Qty: 1<<64 - 1, Name: "grain of sand"}, {Qty: 1, Name: "grain of sand"}}
+		require.Equal(t, "18446744073709551616", orders.AggregateTotal(order))
+		require.Equal(t, "18446744073709551616x grain of sand", orders.AggregateByName(order))
 	})
+
+	for _, name := range []string{"", "max", "top:", "top:0", "top:x"} {
+		_, err := orders.ParseAggregation(name)
+		require.ErrorIs(t, err, orders.ErrUnknownAggregation, name)
+	}
 }
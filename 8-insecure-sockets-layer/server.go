@@ -1,17 +1,48 @@
 package isl
 
 import (
-	"bufio"
 	"context"
-	"fmt"
 	"log"
 	"net"
 
 	"github.com/harveysanders/protohackers/8-insecure-sockets-layer/orders"
 )
 
-type Server struct {
-	l net.Listener
+type (
+	// Server accepts ISL clients, reads each client's cipher spec, and hands the decoded connection to its handler.
+	Server struct {
+		l net.Listener
+		// Serves the application protocol. Defaults to answering toy orders with the toy with the most copies.
+		handler ConnHandler
+	}
+
+	Option func(*Server)
+)
+
+// NewServer creates a Server. The zero Server is also ready to Start, and serves the toy workshop protocol.
+func NewServer(opts ...Option) *Server {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithHandler serves h over ISL, instead of the toy workshop protocol.
+func WithHandler(h ConnHandler) Option {
+	return func(s *Server) {
+		s.handler = h
+	}
+}
+
+// WithLineHandler serves the line-oriented protocol h over ISL, instead of the toy workshop protocol.
+func WithLineHandler(h LineHandler) Option {
+	return WithHandler(ServeLines(h))
+}
+
+// WithAggregation answers orders with a, instead of the toy with the most copies.
+func WithAggregation(a orders.Aggregation) Option {
+	return WithLineHandler(AnswerOrders(a))
 }
 
 func (s *Server) Start(port string) error {
@@ -28,7 +59,7 @@ func (s *Server) Stop() error {
 }
 
 func (s *Server) Serve(ctx context.Context) error {
-	fmt.Printf("Server listening on %s\n", s.Address())
+	log.Printf("Server listening on %s", s.Address())
 	clientID := 0
 	for {
 		clientID++
@@ -38,7 +69,7 @@ func (s *Server) Serve(ctx context.Context) error {
 			return nil
 		}
 
-		go handleConnection(ctx, conn, clientID)
+		go s.handleConnection(ctx, conn, clientID)
 	}
 }
 
@@ -46,68 +77,26 @@ func (s *Server) Address() string {
 	return s.l.Addr().String()
 }
 
-func handleConnection(ctx context.Context, conn net.Conn, clientID int) {
-	defer func() {
-		fmt.Printf("[%d]: handler complete\n", clientID)
-		conn.Close()
-	}()
-	const maxMessageLen = 5000
-	var (
-		nRead    int // Total bytes read from the stream, not including the cipher spec.
-		nWritten int // Total bytes written to the stream.
-	)
+func (s *Server) handleConnection(ctx context.Context, conn net.Conn, clientID int) {
+	defer conn.Close()
+	handler := s.handler
+	if handler == nil {
+		handler = ServeLines(AnswerOrders(orders.AggregateMost))
+	}
 
 	cipherSpec := NewCipher()
-	n, err := cipherSpec.ReadFrom(conn)
-	fmt.Printf("[%d]: read %d bytes from cipher spec\n", clientID, n)
-	if err != nil {
+	if _, err := cipherSpec.ReadFrom(conn); err != nil {
 		if err == ErrNoOpCipher {
-			fmt.Printf("cipher spec is a no-op")
+			log.Printf("[%d]: cipher spec is a no-op", clientID)
 			return
 		}
-		fmt.Printf("newCipher: %v", err)
+		log.Printf("[%d]: newCipher: %v", clientID, err)
 		return
 	}
 
-	// Stream start pos begins immediately after cipher spec.
-	// nRead is 0 at this point.
-	sd := NewStreamDecoder(conn, *cipherSpec, nRead)
-	scr := bufio.NewScanner(sd)
-	scr.Buffer(make([]byte, maxMessageLen), maxMessageLen)
-
-	for scr.Scan() {
-		line := scr.Bytes()
-		if len(line) >= 30 {
-			fmt.Printf("[%d]: Received: %s\n", clientID, string(line[:30])+`...`)
-		} else {
-			fmt.Printf("[%d]: Received: %s\n", clientID, string(line))
-		}
-		// Re add the newline stripped by the scanner
-		line = append(line, '\n')
-		nRead += len(line)
-		fmt.Printf("[%d]: nRead: %9d\n", clientID, nRead)
-		fmt.Printf("[%d]: message len: %1d\n\n", clientID, len(line))
-		toy, err := orders.MostCopies(line)
-		if err != nil {
-			fmt.Printf("[%d]: orders.MostCopies: %v\n", clientID, err)
-			return
-		}
-
-		toy = append(toy, '\n')
-		fmt.Printf("[%d]: Sending: %s\n", clientID, string(toy))
-		encoded := cipherSpec.Encode(toy, nWritten)
-		n, err := conn.Write(encoded)
-		nWritten += n
-		if err != nil {
-			fmt.Printf("conn.Write: %v", err)
-			return
-		}
-	}
-
-	if err := scr.Err(); err != nil {
-		fmt.Printf("scr.Err(): %v", err)
-		return
+	// Stream positions begin immediately after the cipher spec.
+	ctx = context.WithValue(ctx, CONNECTION_ID, clientID)
+	if err := handler.HandleConnection(ctx, NewConn(conn, cipherSpec)); err != nil {
+		log.Printf("[%d]: %v", clientID, err)
 	}
-
-	fmt.Printf("[%d]: scan loop complete\n", clientID)
 }
@@ -1,94 +1,95 @@
 package isl_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net"
 	"sync"
 	"testing"
 	"time"
 
+	chat "github.com/harveysanders/protohackers/3-budget-chat"
 	isl "github.com/harveysanders/protohackers/8-insecure-sockets-layer"
+	"github.com/harveysanders/protohackers/8-insecure-sockets-layer/orders"
 	"github.com/stretchr/testify/require"
 )
 
-func TestServer(t *testing.T) {
-	t.Run("example session", func(t *testing.T) {
-		var wg sync.WaitGroup
-		port := "9999"
-		server := isl.Server{}
-		err := server.Start(port)
-		require.NoError(t, err)
-		ctx := context.Background()
+// startServer serves ISL on a random port until the test ends.
+func startServer(t *testing.T, opts ...isl.Option) *isl.Server {
+	t.Helper()
+	var wg sync.WaitGroup
+	server := isl.NewServer(opts...)
+	require.NoError(t, server.Start(""))
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Serve(context.Background()); err != nil {
+			t.Logf("server.Serve(): %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = server.Stop()
+		wg.Wait()
+	})
+	return server
+}
 
-			err := server.Serve(ctx)
-			if err != nil {
-				t.Logf("server.Serve(): %v", err)
-				return
-			}
-		}()
+func TestServer(t *testing.T) {
+	// xor(123),addpos,reversebits
+	spec := []byte{0x02, 0x7b, 0x05, 0x01, 0x00}
 
-		conn, err := net.Dial("tcp", server.Address())
+	t.Run("example session", func(t *testing.T) {
+		server := startServer(t)
+		conn, err := isl.Dial(server.Address(), spec)
 		require.NoError(t, err)
+		defer conn.Close()
 
+		resp := bufio.NewReader(conn)
 		msgs := []struct {
-			req      []byte
-			wantResp []byte
+			req      string
+			wantResp string
 		}{
-			{
-				req: []byte{
-					// xor(123),addpos,reversebits
-					0x02, 0x7b, 0x05, 0x01, 0x00,
-					//  4x dog,5x car\n
-					0xf2, 0x20, 0xba, 0x44, 0x18, 0x84, 0xba, 0xaa, 0xd0, 0x26, 0x44, 0xa4, 0xa8, 0x7e,
-				},
-				// 5x car\n (encrypted)
-				wantResp: []byte{0x72, 0x20, 0xba, 0xd8, 0x78, 0x70, 0xee},
-			},
+			{req: "4x dog,5x car\n", wantResp: "5x car\n"},
+			{req: "3x rat,2x cat\n", wantResp: "3x rat\n"},
 		}
 
 		for _, m := range msgs {
-			_, err := conn.Write(m.req)
+			_, err := conn.Write([]byte(m.req))
 			require.NoError(t, err)
 
-			resp := make([]byte, 5000)
-			n, err := conn.Read(resp)
-			require.NotErrorIs(t, io.EOF, err)
-
-			require.Equal(t, m.wantResp, resp[:n])
+			got, err := resp.ReadString('\n')
+			require.NoError(t, err)
+			require.Equal(t, m.wantResp, got)
 		}
-
-		_ = conn.Close()
-		_ = server.Stop()
-		wg.Wait()
 	})
 
-	t.Run("no-op cipher specs", func(t *testing.T) {
-		var wg sync.WaitGroup
-		port := "9999"
-		server := isl.Server{}
-		err := server.Start(port)
+	t.Run("encodes the response stream", func(t *testing.T) {
+		server := startServer(t)
+		conn, err := isl.Dial(server.Address(), spec)
 		require.NoError(t, err)
-		ctx := context.Background()
+		defer conn.Close()
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+		_, err = conn.Write([]byte("4x dog,5x car\n"))
+		require.NoError(t, err)
 
-			err := server.Serve(ctx)
-			if err != nil {
-				t.Logf("server.Serve(): %v", err)
-				return
-			}
-		}()
+		// Read the raw bytes from under the cipher.
+		resp := make([]byte, 7)
+		_, err = io.ReadFull(conn.Conn, resp)
+		require.NoError(t, err)
+		// 5x car\n (encrypted)
+		require.Equal(t, []byte{0x72, 0x20, 0xba, 0xd8, 0x78, 0x70, 0xee}, resp)
+	})
 
+	t.Run("no-op cipher specs", func(t *testing.T) {
+		server := startServer(t)
+		// The client refuses to send a no-op spec, so send it by hand.
 		conn, err := net.Dial("tcp", server.Address())
 		require.NoError(t, err)
+		defer conn.Close()
 
 		// If a client tries to use a cipher that leaves every byte of input unchanged,
 		// the server must immediately disconnect without sending any data back
@@ -119,122 +120,161 @@ func TestServer(t *testing.T) {
 			require.ErrorContains(t, err, "reset", "expected to immediately close connection")
 		}
 
-		_ = conn.Close()
-		_ = server.Stop()
-		wg.Wait()
+		_, err = isl.Dial(server.Address(), []byte{0x02, 0xab, 0x02, 0xab, 0x00})
+		require.ErrorIs(t, err, isl.ErrNoOpCipher)
 	})
 
 	t.Run("handles slow clients", func(t *testing.T) {
-		var wg sync.WaitGroup
-		port := ""
-		server := isl.Server{}
-		err := server.Start(port)
+		server := startServer(t)
+		conn, err := isl.Dial(server.Address(), spec)
 		require.NoError(t, err)
-		ctx := context.Background()
+		defer conn.Close()
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+		for _, m := range []string{"4x dog,", "5x car\n"} {
+			time.Sleep(500 * time.Millisecond)
+			_, err := conn.Write([]byte(m))
+			require.NoError(t, err)
+		}
 
-			err := server.Serve(ctx)
-			if err != nil {
-				t.Logf("server.Serve(): %v", err)
-				return
-			}
-		}()
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "5x car\n", got)
+	})
 
-		conn, err := net.Dial("tcp", server.Address())
+	t.Run("keeps idle clients connected", func(t *testing.T) {
+		server := startServer(t)
+		conn, err := isl.Dial(server.Address(), spec)
 		require.NoError(t, err)
+		defer conn.Close()
 
-		msgs := [][]byte{
-			// xor(123),addpos,reversebits
-			{0x02, 0x7b, 0x05, 0x01, 0x00},
-			// 4x dog,5x car\n
-			{0xf2, 0x20, 0xba, 0x44, 0x18, 0x84, 0xba, 0xaa, 0xd0, 0x26, 0x44, 0xa4, 0xa8, 0x7e},
-		}
+		resp := bufio.NewReader(conn)
+		for _, idle := range []time.Duration{1500 * time.Millisecond, 0, 1200 * time.Millisecond} {
+			time.Sleep(idle)
+			_, err := conn.Write([]byte("4x dog,5x car\n"))
+			require.NoError(t, err)
 
-		for _, m := range msgs {
-			_, err := conn.Write(m)
+			got, err := resp.ReadString('\n')
 			require.NoError(t, err)
-			time.Sleep(500 * time.Millisecond)
+			require.Equal(t, "5x car\n", got)
 		}
+	})
 
-		resp := make([]byte, 5000)
-		n, err := conn.Read(resp)
-		require.NotErrorIs(t, io.EOF, err)
+	t.Run("answers with another aggregation", func(t *testing.T) {
+		server := startServer(t, isl.WithAggregation(orders.AggregateTop(2)))
+		conn, err := isl.Dial(server.Address(), spec)
+		require.NoError(t, err)
+		defer conn.Close()
 
-		wantResp := []byte{0x72, 0x20, 0xba, 0xd8, 0x78, 0x70, 0xee}
-		require.Equal(t, wantResp, resp[:n])
+		_, err = conn.Write([]byte("4x dog,5x car,6x xylophone\n"))
+		require.NoError(t, err)
 
-		// Close the connection before reading the response
-		_ = conn.Close()
-		_ = server.Stop()
-		wg.Wait()
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "6x xylophone,5x car\n", got)
+	})
+
+	t.Run("disconnects on an invalid order", func(t *testing.T) {
+		server := startServer(t)
+		conn, err := isl.Dial(server.Address(), spec)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("dog,5x car\n"))
+		require.NoError(t, err)
+
+		_, err = bufio.NewReader(conn).ReadString('\n')
+		require.ErrorIs(t, err, io.EOF)
 	})
 }
 
-func TestServerEdgeCases(t *testing.T) {
-	t.Skip("fix wait group")
+func TestServerHandlers(t *testing.T) {
+	// xor(123),addpos,reversebits
+	spec := []byte{0x02, 0x7b, 0x05, 0x01, 0x00}
 
-	var wg sync.WaitGroup
-	port := ""
-	server := isl.Server{}
-	err := server.Start(port)
-	require.NoError(t, err)
-	ctx := context.Background()
+	t.Run("serves a line handler", func(t *testing.T) {
+		upper := isl.LineHandlerFunc(func(ctx context.Context, line []byte) ([]byte, error) {
+			if len(line) == 0 {
+				return nil, errors.New("empty line")
+			}
+			return bytes.ToUpper(line), nil
+		})
+		server := startServer(t, isl.WithLineHandler(upper))
+		conn, err := isl.Dial(server.Address(), spec)
+		require.NoError(t, err)
+		defer conn.Close()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+		_, err = conn.Write([]byte("hello\nworld\n\nignored\n"))
+		require.NoError(t, err)
 
-		err := server.Serve(ctx)
-		if err != nil {
-			t.Logf("server.Serve(): %v", err)
-			return
+		resp := bufio.NewReader(conn)
+		for _, want := range []string{"HELLO\n", "WORLD\n"} {
+			got, err := resp.ReadString('\n')
+			require.NoError(t, err)
+			require.Equal(t, want, got)
 		}
-	}()
+		// The handler's error disconnects the client.
+		_, err = resp.ReadString('\n')
+		require.ErrorIs(t, err, io.EOF)
+	})
 
-	conn, err := net.Dial("tcp", server.Address())
-	require.NoError(t, err)
+	t.Run("serves budget chat", func(t *testing.T) {
+		server := startServer(t, isl.WithHandler(chat.NewServer()))
 
-	msgs := [][]byte{
-		edgeCase1.cipherSpec,
-		edgeCase1.reqs[0],
-		edgeCase1.reqs[1],
-	}
+		join := func(name string, spec []byte) (*isl.Conn, *bufio.Reader) {
+			conn, err := isl.Dial(server.Address(), spec)
+			require.NoError(t, err)
+			t.Cleanup(func() { conn.Close() })
+			resp := bufio.NewReader(conn)
+			got, err := resp.ReadString('\n')
+			require.NoError(t, err)
+			require.Equal(t, "New chat server. Who dis?\n", got)
+			_, err = conn.Write([]byte(name + "\n"))
+			require.NoError(t, err)
+			return conn, resp
+		}
 
-	expectedResponseCount := 2
-	wg.Add(expectedResponseCount)
-	go func(t *testing.T, conn net.Conn, wg *sync.WaitGroup) {
-		readPump(t, conn, wg)
-		server.Stop()
-	}(t, conn, &wg)
+		_, alice := join("alice", spec)
+		got, err := alice.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "* you're the first one here!\n", got)
 
-	for _, msg := range msgs {
-		nSent, err := conn.Write(msg)
+		// Each client has its own cipher.
+		conn, bob := join("bob", []byte{0x05, 0x00})
+		got, err = bob.ReadString('\n')
 		require.NoError(t, err)
-		require.Greater(t, nSent, 0)
-	}
+		require.Equal(t, "* connected users: alice\n", got)
 
-	wg.Wait()
-	conn.Close()
+		// Bob's join is announced before this reply, so his message comes after it.
+		_, err = conn.Write([]byte("hi alice\n"))
+		require.NoError(t, err)
+		for _, want := range []string{"* bob joined the chat!\n", "[bob] hi alice\n"} {
+			got, err := alice.ReadString('\n')
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		}
+	})
 }
 
-func readPump(t *testing.T, conn net.Conn, wg *sync.WaitGroup) {
-	t.Helper()
-	c := isl.NewCipher()
-	c.ReadFrom(bytes.NewReader(edgeCase1.cipherSpec))
-	var nRead int
-	for {
-		resp := make([]byte, 5000)
-		nRecv, err := conn.Read(resp)
-		t.Logf("readPump: received %d bytes", nRecv)
-		require.NotErrorIs(t, io.EOF, err)
-
-		t.Logf("readPump: raw response: %s", resp[:nRecv])
-		decoded := c.Decode(resp[:nRecv], nRead)
-		t.Logf("readPump: decoded: %s", decoded)
-		nRead += nRecv
-		wg.Done()
+func TestServerEdgeCases(t *testing.T) {
+	server := startServer(t)
+	conn, err := isl.Dial(server.Address(), edgeCase1.cipherSpec)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The requests were captured already encoded, so write them under the cipher.
+	for _, msg := range edgeCase1.reqs {
+		_, err := conn.Conn.Write(msg)
+		require.NoError(t, err)
+	}
+
+	// The capture ends part way through the third request, so there are only two responses.
+	resp := bufio.NewReader(conn)
+	for _, want := range []string{
+		"99x bear with remote-controlled lorry simulator\n",
+		"100x inflatable bear toy\n",
+	} {
+		got, err := resp.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, want, got)
 	}
 }
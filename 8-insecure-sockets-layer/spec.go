@@ -0,0 +1,228 @@
+package isl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// OpCode is the byte that identifies an operation in a cipher spec.
+	OpCode byte
+
+	// Op is a single operation of a cipher spec. N is the operand for xor(N) and add(N).
+	Op struct {
+		Code OpCode
+		N    byte
+	}
+
+	// Spec is a cipher spec: the operations applied, in order, to each byte of the stream.
+	Spec []Op
+)
+
+const (
+	OpReverseBits OpCode = 0x01 // Reverse the bits of the byte.
+	OpXOR         OpCode = 0x02 // XOR the byte by N.
+	OpXORPos      OpCode = 0x03 // XOR the byte by its position in the stream.
+	OpAdd         OpCode = 0x04 // Add N to the byte. If decoding, subtract N from the byte.
+	OpAddPos      OpCode = 0x05 // Add the position in the stream to the byte. If decoding, subtract the position from the byte.
+)
+
+var (
+	ErrUnknownOp   = errors.New("unknown operation")
+	ErrSpecEnd     = errors.New("cipher spec ended early")
+	ErrSpecTrailer = errors.New("data after end of cipher spec")
+	ErrSpecSyntax  = errors.New("invalid cipher spec syntax")
+)
+
+// HasOperand reports whether the operation is followed by an operand byte.
+func (c OpCode) HasOperand() bool {
+	return c == OpXOR || c == OpAdd
+}
+
+// Valid reports whether the code is a known operation.
+func (c OpCode) Valid() bool {
+	return c >= OpReverseBits && c <= OpAddPos
+}
+
+func (c OpCode) String() string {
+	switch c {
+	case OpReverseBits:
+		return "reversebits"
+	case OpXOR:
+		return "xor"
+	case OpXORPos:
+		return "xorpos"
+	case OpAdd:
+		return "add"
+	case OpAddPos:
+		return "addpos"
+	}
+	return fmt.Sprintf("OpCode(0x%02x)", byte(c))
+}
+
+// String returns the operation in the text form used by the protocol docs, e.g. "xor(123)".
+func (o Op) String() string {
+	if o.Code.HasOperand() {
+		return fmt.Sprintf("%s(%d)", o.Code, o.N)
+	}
+	return o.Code.String()
+}
+
+// String returns the spec in its text form, e.g. "xor(1),reversebits,addpos".
+func (s Spec) String() string {
+	ops := make([]string, len(s))
+	for i, op := range s {
+		ops[i] = op.String()
+	}
+	return strings.Join(ops, ",")
+}
+
+// MarshalBinary encodes the spec as it is sent on the wire, ending with a 00 byte.
+func (s Spec) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 2*len(s)+1)
+	for i, op := range s {
+		if !op.Code.Valid() {
+			return nil, fmt.Errorf("operation %d: %w %s", i, ErrUnknownOp, op.Code)
+		}
+		data = append(data, byte(op.Code))
+		if op.Code.HasOperand() {
+			data = append(data, op.N)
+		}
+	}
+	data = append(data, cipherEnd)
+	if len(data) > MaxSpecLen {
+		return nil, ErrMaxCipherSpecSize
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a spec from data, which must hold exactly one spec, ending with a 00 byte.
+func (s *Spec) UnmarshalBinary(data []byte) error {
+	spec, n, err := ReadSpec(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if int(n) != len(data) {
+		return fmt.Errorf("%w at byte %d", ErrSpecTrailer, n)
+	}
+	*s = spec
+	return nil
+}
+
+// ReadSpec reads a spec from the start of a stream, up to and including its 00 byte, and returns it with the number of bytes read. It reads one byte at a time, so nothing after the spec is consumed. Unknown operations and specs longer than MaxSpecLen are errors.
+func ReadSpec(r io.Reader) (Spec, int64, error) {
+	var spec Spec
+	var nRead int64
+	buf := make([]byte, 1)
+	next := func() (byte, error) {
+		if nRead >= MaxSpecLen {
+			return 0, ErrMaxCipherSpecSize
+		}
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, fmt.Errorf("%w at byte %d: %w", ErrSpecEnd, nRead, io.ErrUnexpectedEOF)
+			}
+			return 0, err
+		}
+		nRead++
+		return buf[0], nil
+	}
+
+	for {
+		b, err := next()
+		if err != nil {
+			return nil, nRead, err
+		}
+		if b == cipherEnd {
+			return spec, nRead, nil
+		}
+		op := Op{Code: OpCode(b)}
+		if !op.Code.Valid() {
+			return nil, nRead, fmt.Errorf("%w 0x%02x at byte %d", ErrUnknownOp, b, nRead-1)
+		}
+		if op.Code.HasOperand() {
+			// Note that 0 is a valid value for N
+			if op.N, err = next(); err != nil {
+				return nil, nRead, err
+			}
+		}
+		spec = append(spec, op)
+	}
+}
+
+// ParseSpec parses the text form of a spec: operations separated by commas, like "xor(1),reversebits,addpos". Operands may be decimal or 0x-prefixed hex. Errors report the column of the offending operation, starting from 1.
+func ParseSpec(text string) (Spec, error) {
+	spec := Spec{}
+	if strings.TrimSpace(text) == "" {
+		return spec, nil
+	}
+
+	col := 1
+	for _, field := range strings.Split(text, ",") {
+		op, err := parseOp(field)
+		if err != nil {
+			// Point at the operation, not the whitespace before it.
+			start := col + len(field) - len(strings.TrimLeftFunc(field, unicode.IsSpace))
+			return nil, fmt.Errorf("%w at column %d: %w", ErrSpecSyntax, start, err)
+		}
+		spec = append(spec, op)
+		col += len(field) + 1
+	}
+	return spec, nil
+}
+
+func parseOp(text string) (Op, error) {
+	text = strings.TrimSpace(text)
+	name, arg, hasArg := strings.Cut(text, "(")
+	name = strings.TrimSpace(name)
+
+	var op Op
+	switch name {
+	case "reversebits":
+		op.Code = OpReverseBits
+	case "xor":
+		op.Code = OpXOR
+	case "xorpos":
+		op.Code = OpXORPos
+	case "add":
+		op.Code = OpAdd
+	case "addpos":
+		op.Code = OpAddPos
+	case "":
+		return op, errors.New("missing operation")
+	default:
+		return op, fmt.Errorf("%w %q", ErrUnknownOp, name)
+	}
+
+	if !op.Code.HasOperand() {
+		if hasArg {
+			return op, fmt.Errorf("%s takes no operand", name)
+		}
+		return op, nil
+	}
+	if !hasArg {
+		return op, fmt.Errorf("%s needs an operand, like %s(1)", name, name)
+	}
+	arg, ok := strings.CutSuffix(arg, ")")
+	if !ok {
+		return op, fmt.Errorf("%s: missing )", name)
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(arg), 0, 8)
+	if err != nil {
+		return op, fmt.Errorf("%s: operand must be 0 to 255: %q", name, arg)
+	}
+	op.N = byte(n)
+	return op, nil
+}
+
+// IsNoOp reports whether the spec leaves every byte unchanged at every position.
+func (s Spec) IsNoOp() bool {
+	c := &Cipher{spec: s}
+	c.compile()
+	return c.IsNoOp()
+}
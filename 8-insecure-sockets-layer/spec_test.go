@@ -0,0 +1,166 @@
+package isl_test
+
+import (
+	"bytes"
+	"testing"
+
+	isl "github.com/harveysanders/protohackers/8-insecure-sockets-layer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpec(t *testing.T) {
+	testCases := []struct {
+		text string
+		want isl.Spec
+	}{
+		{text: "", want: isl.Spec{}},
+		{text: "reversebits", want: isl.Spec{{Code: isl.OpReverseBits}}},
+		{
+			text: "xor(1),reversebits,addpos",
+			want: isl.Spec{{Code: isl.OpXOR, N: 1}, {Code: isl.OpReverseBits}, {Code: isl.OpAddPos}},
+		},
+		{
+			text: " xor( 0x7b ) , add(255),\txorpos ",
+			want: isl.Spec{{Code: isl.OpXOR, N: 123}, {Code: isl.OpAdd, N: 255}, {Code: isl.OpXORPos}},
+		},
+		{text: "add(0)", want: isl.Spec{{Code: isl.OpAdd, N: 0}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			got, err := isl.ParseSpec(tc.text)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+
+			// The text form round trips.
+			again, err := isl.ParseSpec(got.String())
+			require.NoError(t, err)
+			require.Equal(t, got, again)
+		})
+	}
+
+	errCases := []struct {
+		text    string
+		wantErr string
+	}{
+		{text: "rot13", wantErr: `at column 1: unknown operation "rot13"`},
+		{text: "xor(1), rot13", wantErr: `at column 9: unknown operation "rot13"`},
+		{text: "xor(1),,addpos", wantErr: "at column 8: missing operation"},
+		{text: "xor", wantErr: "at column 1: xor needs an operand"},
+		{text: "add(1", wantErr: "at column 1: add: missing )"},
+		{text: "add(256)", wantErr: "at column 1: add: operand must be 0 to 255"},
+		{text: "add(-1)", wantErr: "at column 1: add: operand must be 0 to 255"},
+		{text: "addpos,reversebits(1)", wantErr: "at column 8: reversebits takes no operand"},
+	}
+
+	for _, tc := range errCases {
+		t.Run(tc.text, func(t *testing.T) {
+			_, err := isl.ParseSpec(tc.text)
+			require.ErrorIs(t, err, isl.ErrSpecSyntax)
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestSpecBinary(t *testing.T) {
+	t.Run("round trips", func(t *testing.T) {
+		spec := isl.Spec{{Code: isl.OpXOR, N: 0}, {Code: isl.OpReverseBits}, {Code: isl.OpAdd, N: 7}, {Code: isl.OpXORPos}, {Code: isl.OpAddPos}}
+		data, err := spec.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x02, 0x00, 0x01, 0x04, 0x07, 0x03, 0x05, 0x00}, data)
+
+		var got isl.Spec
+		require.NoError(t, got.UnmarshalBinary(data))
+		require.Equal(t, spec, got)
+	})
+
+	t.Run("marshal errors", func(t *testing.T) {
+		_, err := isl.Spec{{Code: 0x06}}.MarshalBinary()
+		require.ErrorIs(t, err, isl.ErrUnknownOp)
+
+		long := make(isl.Spec, isl.MaxSpecLen)
+		for i := range long {
+			long[i].Code = isl.OpXORPos
+		}
+		_, err = long.MarshalBinary()
+		require.ErrorIs(t, err, isl.ErrMaxCipherSpecSize)
+	})
+
+	errCases := []struct {
+		desc    string
+		data    []byte
+		wantErr error
+		wantMsg string
+	}{
+		{desc: "unknown opcode", data: []byte{0x01, 0x06, 0x00}, wantErr: isl.ErrUnknownOp, wantMsg: "0x06 at byte 1"},
+		{desc: "missing end", data: []byte{0x01, 0x05}, wantErr: isl.ErrSpecEnd, wantMsg: "at byte 2"},
+		{desc: "missing operand", data: []byte{0x01, 0x02}, wantErr: isl.ErrSpecEnd, wantMsg: "at byte 2"},
+		{desc: "trailing data", data: []byte{0x01, 0x00, 0x68}, wantErr: isl.ErrSpecTrailer, wantMsg: "at byte 2"},
+		{desc: "too long", data: bytes.Repeat([]byte{0x03}, isl.MaxSpecLen+1), wantErr: isl.ErrMaxCipherSpecSize},
+		{desc: "empty", data: []byte{}, wantErr: isl.ErrSpecEnd, wantMsg: "at byte 0"},
+	}
+
+	for _, tc := range errCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var s isl.Spec
+			err := s.UnmarshalBinary(tc.data)
+			require.ErrorIs(t, err, tc.wantErr)
+			require.ErrorContains(t, err, tc.wantMsg)
+		})
+	}
+
+	t.Run("reads nothing past the end of the spec", func(t *testing.T) {
+		r := bytes.NewReader([]byte{0x04, 0x00, 0x03, 0x00, 0x68, 0x69})
+		spec, n, err := isl.ReadSpec(r)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), n)
+		require.Equal(t, "add(0),xorpos", spec.String())
+		require.Equal(t, 2, r.Len())
+	})
+}
+
+func TestSpecIsNoOp(t *testing.T) {
+	testCases := []struct {
+		text string
+		want bool
+	}{
+		{text: "", want: true},
+		{text: "xor(0)", want: true},
+		{text: "add(0),xor(0)", want: true},
+		{text: "reversebits,reversebits", want: true},
+		{text: "xorpos,xorpos", want: true},
+		{text: "xor(128),add(128)", want: true},
+		// 0x81 reads the same reversed, so the two xors cancel.
+		{text: "reversebits,xor(0x81),reversebits,xor(0x81)", want: true},
+		{text: "xor(0),reversebits", want: false},
+		{text: "xorpos", want: false},
+		{text: "xorpos,addpos", want: false},
+		{text: "reversebits,xor(1),reversebits,xor(1)", want: false},
+		{text: "xor(1),add(1)", want: false},
+		// Leaves the byte alone at position 0, but not after.
+		{text: "addpos,addpos,xorpos", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			spec, err := isl.ParseSpec(tc.text)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, spec.IsNoOp())
+
+			err = isl.NewCipher().SetSpec(spec)
+			if tc.want {
+				require.ErrorIs(t, err, isl.ErrNoOpCipher)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("256 addpos is a no-op", func(t *testing.T) {
+		spec := make(isl.Spec, 256)
+		for i := range spec {
+			spec[i].Code = isl.OpAddPos
+		}
+		require.True(t, spec.IsNoOp())
+	})
+}